@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"go/format"
+	"os"
+
+	"github.com/hybridgroup/wasman"
+)
+
+var strMainModuleFile = flag.String("main", "module.wasm", "the module translated to Go")
+var pkg = flag.String("pkg", "main", "the package name of the generated source")
+var strOutFile = flag.String("out", "", "the generated Go file, the stdout if empty")
+
+func main() {
+	flag.Parse()
+
+	bin, err := os.ReadFile(*strMainModuleFile)
+	if err != nil {
+		panic(err)
+	}
+
+	var buf bytes.Buffer
+	if err := wasman.GenerateGo(&buf, *pkg, bin); err != nil {
+		panic(err)
+	}
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		panic(err)
+	}
+
+	if *strOutFile == "" {
+		_, err = os.Stdout.Write(src)
+	} else {
+		err = os.WriteFile(*strOutFile, src, 0o644)
+	}
+	if err != nil {
+		panic(err)
+	}
+}
@@ -1,26 +1,35 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/hybridgroup/wasman"
 	"github.com/hybridgroup/wasman/config"
 	"github.com/hybridgroup/wasman/tollstation"
-	"github.com/hybridgroup/wasman/utils"
+	"github.com/hybridgroup/wasman/types"
+	"github.com/hybridgroup/wasman/wasi"
 )
 
 var strMainModuleFile = flag.String("main", "module.wasm", "main module")
 
 var funcName = flag.String("func", "main", "main func")
 var maxToll = flag.Uint64("max-toll", 0, "the maximum toll in simple toll station")
+var disableFloat = flag.Bool("disable-float", false, "reject the main module using f32 or f64")
+var validate = flag.Bool("validate", false, "validate the main module before running it")
+var closure = flag.Bool("closure", false, "run the main module on the closure engine, which is faster than the interpreter")
+var maxMemoryPages = flag.Uint("max-memory-pages", 0, "the maximum number of memory pages, no limit if 0")
 
 var strExternModules = flag.String("extern-files", "", "external modules files")
 
-var stdout = os.Stdout // for wasi
+var enableWASI = flag.Bool("wasi", false, "provide the wasi_snapshot_preview1 module, passing the args to the guest")
+var strWASIDirs = flag.String("wasi-dirs", "", "host directories preopened for wasi")
 
 func main() {
 	flag.Parse()
@@ -31,15 +40,57 @@ func main() {
 		panic(err)
 	}
 
+	var memoryLimit *uint32
+	if *maxMemoryPages > 0 {
+		limit := uint32(*maxMemoryPages)
+		memoryLimit = &limit
+	}
+
+	engine := config.EngineInterpreter
+	if *closure {
+		engine = config.EngineClosure
+	}
+
 	mainMod, err := wasman.NewModule(config.ModuleConfig{
-		DisableFloatPoint: false,
+		DisableFloatPoint: *disableFloat,
+		EnableValidation:  *validate,
 		TollStation:       tollstation.NewSimpleTollStation(*maxToll),
+		MemoryLimitPages:  memoryLimit,
+		Engine:            engine,
 	}, f)
 	if err != nil {
 		panic(err)
 	}
 
-	externMods := make(map[string]*wasman.Module)
+	l := wasman.NewLinker(config.LinkerConfig{})
+	if *enableWASI {
+		var preopens []config.WASIPreopen
+		for _, pair := range strings.Split(*strWASIDirs, ",") {
+			if pair == "" {
+				continue
+			}
+
+			li := strings.Split(pair, ":")
+			if len(li) != 2 {
+				panic("invalid wasi dir: should input with -wasi-dirs=<guest path1>:<host dir1>,<guest path2>:<host dir2>")
+			}
+
+			preopens = append(preopens, config.WASIPreopen{GuestPath: li[0], FS: wasi.DirFS(li[1])})
+		}
+
+		err = l.DefineWASI(config.WASIConfig{
+			Args:     append([]string{*strMainModuleFile}, flag.Args()...),
+			Stdin:    os.Stdin,
+			Stdout:   os.Stdout,
+			Stderr:   os.Stderr,
+			Preopens: preopens,
+		})
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	// the external modules are instantiated in order, so they can import the wasi and the former ones
 	for _, pair := range externModules {
 		if pair == "" {
 			continue
@@ -47,7 +98,7 @@ func main() {
 
 		li := strings.Split(pair, ":")
 		if len(li) != 2 {
-			panic("invalid external module: should input with -extern=<name1>:<file1>,<name2>:<file2>")
+			panic("invalid external module: should input with -extern-files=<name1>:<file1>,<name2>:<file2>")
 		}
 
 		f, err := os.Open(li[1])
@@ -60,28 +111,58 @@ func main() {
 			panic(err)
 		}
 
-		externMods[li[0]] = mod
+		ins, err := l.Instantiate(mod)
+		if err != nil {
+			panic(err)
+		}
+
+		if err := l.DefineInstance(li[0], ins); err != nil {
+			panic(err)
+		}
 	}
 
-	l := wasman.NewLinkerWithModuleMap(config.LinkerConfig{}, externMods)
 	ins, err := l.Instantiate(mainMod)
 	if err != nil {
 		panic(err)
 	}
 
-	args := make([]uint64, 0)
-	for _, strArg := range flag.Args() {
-		if len(strArg) == 0 {
-			continue
-		}
+	ft, err := ins.ExportedFuncType(*funcName)
+	if err != nil {
+		panic(err)
+	}
+
+	args := make([]types.Value, 0)
+	if !*enableWASI {
+		for _, strArg := range flag.Args() {
+			if len(strArg) == 0 {
+				continue
+			}
 
-		arg := uint64(utils.StringToInt(strArg))
+			if len(args) >= len(ft.InputTypes) {
+				panic(fmt.Errorf("too many args, %s takes %d", *funcName, len(ft.InputTypes)))
+			}
 
-		args = append(args, arg)
+			arg, err := parseValue(ft.InputTypes[len(args)], strArg)
+			if err != nil {
+				panic(err)
+			}
+
+			args = append(args, arg)
+		}
 	}
 
-	r, ty, err := ins.CallExportedFunc(*funcName, args...)
-	if err != nil {
+	r, err := ins.Call(context.Background(), *funcName, args...)
+	var exitErr *wasman.ExitError
+	var trap *wasman.Trap
+	if errors.As(err, &exitErr) {
+		os.Exit(int(exitErr.Code))
+	} else if errors.As(err, &trap) {
+		fmt.Fprintln(os.Stderr, err)
+		for _, frame := range trap.Frames {
+			fmt.Fprintln(os.Stderr, "\t"+frame.String())
+		}
+		os.Exit(1)
+	} else if err != nil {
 		panic(err)
 	}
 
@@ -100,12 +181,47 @@ func main() {
 		toll,
 	}
 
-	if r != nil {
-		result.Type = ty[0].String()
-		result.Result = r[0]
+	if len(r) > 0 {
+		result.Type = r[0].Type.String()
+		result.Result = r[0].Interface()
 	}
 
 	out, _ := json.MarshalIndent(result, "", "  ")
 
 	fmt.Printf(string(out))
 }
+
+// parseValue parses the arg from the command line as a value of the type
+func parseValue(ty types.ValueType, str string) (types.Value, error) {
+	switch ty {
+	case types.ValueTypeI32:
+		v, err := strconv.ParseInt(str, 0, 32)
+		if err != nil {
+			// allow the unsigned form, e.g. 0xffffffff
+			u, uerr := strconv.ParseUint(str, 0, 32)
+			if uerr != nil {
+				return types.Value{}, err
+			}
+			v = int64(int32(uint32(u)))
+		}
+		return types.I32(int32(v)), nil
+	case types.ValueTypeI64:
+		v, err := strconv.ParseInt(str, 0, 64)
+		if err != nil {
+			u, uerr := strconv.ParseUint(str, 0, 64)
+			if uerr != nil {
+				return types.Value{}, err
+			}
+			v = int64(u)
+		}
+		return types.I64(v), nil
+	case types.ValueTypeF32:
+		v, err := strconv.ParseFloat(str, 32)
+		return types.F32(float32(v)), err
+	case types.ValueTypeF64:
+		v, err := strconv.ParseFloat(str, 64)
+		return types.F64(v), err
+	default:
+		return types.Value{}, fmt.Errorf("unsupported arg type %s", ty)
+	}
+}
@@ -16,14 +16,13 @@ const (
 	// MemoryPageSize is the unit of memory length in WebAssembly,
 	// and is defined as 2^16 = 65536.
 	// See https://www.w3.org/TR/2019/REC-wasm-core-1-20191205/#memory-instances%E2%91%A0
+	// A smaller page size for tiny devices can be set with the ModuleConfig.MemoryPageSize.
 	DefaultMemoryPageSize = 65536
-	//DefaultMemoryPageSize = 16384 // if you need a tiny page size for tiny devices...
 	// MemoryMaxPages is maximum number of pages defined (2^16).
 	// See https://www.w3.org/TR/2019/REC-wasm-core-1-20191205/#grow-mem
-	DefaultMemoryMaxPages = 1
+	DefaultMemoryMaxPages = 65536
 	// MemoryPageSizeInBits satisfies the relation: "1 << MemoryPageSizeInBits == MemoryPageSize".
 	DefaultMemoryPageSizeInBits = 16
-	//DefaultMemoryPageSizeInBits = 14 // to match the tiny config version
 )
 
 var (
@@ -31,12 +30,28 @@ var (
 	ErrShadowing = errors.New("shadowing is disabled")
 )
 
+// Engine is the engine running the wasm funcs of the instances
+type Engine uint8
+
+const (
+	// EngineInterpreter dispatches the instrs one by one, it is the default one
+	EngineInterpreter Engine = iota
+	// EngineClosure compiles the funcs into the trees of Go closures when instantiating,
+	// which runs faster with the same results, tolls and traps
+	EngineClosure
+)
+
 // ModuleConfig is the config applied to the wasman.Module
 type ModuleConfig struct {
-	DisableFloatPoint bool
+	DisableFloatPoint bool // reject the modules using f32 or f64 in NewModule
+	EnableValidation  bool // reject the invalid modules in NewModule, see wasm.Module.Validate
 	TollStation       tollstation.TollStation
-	CallDepthLimit    *uint64
-	Recover           bool // avoid panic inside vm
+	CallDepthLimit    *uint64 // the max number of nested wasm func calls, no limit if nil
+	OperandStackLimit *uint64 // the max height of the operand stack, no limit if nil
+	MemoryLimitPages  *uint32 // the max number of memory pages of the instance, DefaultMemoryMaxPages if nil
+	MemoryPageSize    uint32  // the page size of the memories without a custom one, a power of 2 up to DefaultMemoryPageSize if not 0
+	Engine            Engine  // the engine running the funcs of the instances, EngineInterpreter by default
+	Recover           bool    // avoid panic inside vm
 	Logger            func(text string)
 }
 
@@ -1,5 +1,28 @@
 package config
 
-// WASIConfig : TODO
+import (
+	"io"
+	"io/fs"
+	"time"
+)
+
+// WASIConfig is the config applied to the wasi_snapshot_preview1 module
 type WASIConfig struct {
+	Args []string // command line arguments, Args[0] is the program name by convention
+	Env  []string // environment variables in the form "key=value"
+
+	Stdin  io.Reader // nil means an empty input
+	Stdout io.Writer // nil means the output is discarded
+	Stderr io.Writer // nil means the output is discarded
+
+	Random io.Reader        // source of random_get, crypto/rand.Reader by default
+	Now    func() time.Time // source of the clocks, time.Now by default
+
+	Preopens []WASIPreopen // directories opened for the guest, as the fd 3, 4, ...
+}
+
+// WASIPreopen is a directory preopened for the guest
+type WASIPreopen struct {
+	GuestPath string // the path seen by the guest, e.g. "/" or "/etc/plugin"
+	FS        fs.FS  // the files under the path, writable when it implements wasi.WritableFS
 }
@@ -1,6 +1,5 @@
 package main
 
-import "C"
 import (
 	"fmt"
 	"os"
@@ -12,7 +11,6 @@ import (
 // Run me on root folder
 // go run ./examples/hostbytes
 func main() {
-	var ins *wasman.Instance
 	linker1 := wasman.NewLinker(config.LinkerConfig{})
 
 	message1 := []byte{0xDE, 0xAD, 0x00, 0xBE, 0xEF, 0x00, 0xBA, 0xAD, 0x00, 0xF0, 0x0D}
@@ -24,8 +22,8 @@ func main() {
 		panic(err)
 	}
 
-	err = wasman.DefineFunc10(linker1, "env", "get_host_bytes", func(ptr uint32) {
-		copy(ins.Memory.Value[ptr:], message1)
+	err = linker1.DefineGoFunc("env", "get_host_bytes", func(caller *wasman.Caller, ptr uint32) error {
+		return caller.Memory().Write(ptr, message1)
 	})
 	if err != nil {
 		panic(err)
@@ -33,28 +31,31 @@ func main() {
 
 	message2 := append(message1, message1...)
 
-	err = wasman.DefineFunc21(linker1, "env", "get_host_bytes_with_buffer", func(index uint32, ptr uint32) uint32 {
+	err = linker1.DefineGoFunc("env", "get_host_bytes_with_buffer", func(caller *wasman.Caller, index uint32, ptr uint32) (uint32, error) {
 		if index == 0 {
 			message2 = append(message1, message1...) // reset the value
 		}
 
-		length := copy(ins.Memory.Value[ptr:], message2)
+		length := copy(caller.Memory().Value[ptr:], message2)
 		message2 = message2[length:]
 
-		return uint32(length)
+		return uint32(length), nil
 	})
 	if err != nil {
 		panic(err)
 	}
 
-	// cannot call host func in the host func
-	err = wasman.DefineFunc20(linker1, "env", "log_message", func(ptr uint32, l uint32) {
+	err = linker1.DefineGoFunc("env", "log_message", func(caller *wasman.Caller, ptr uint32, l uint32) error {
 		// string way
-		// fmt.Println(C.GoString((*C.char)(unsafe.Pointer(&ins.Memory.Value[ptr])))) // not good for bytes
+		// fmt.Println(caller.Memory().ReadCString(ptr)) // not good for bytes
 
 		// bytes way
-		msg := ins.Memory.Value[ptr : ptr+l]
+		msg, err := caller.Memory().Read(ptr, l)
+		if err != nil {
+			return err
+		}
 		fmt.Printf("%x\n", msg)
+		return nil
 	})
 	if err != nil {
 		panic(err)
@@ -69,7 +70,7 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	ins, err = linker1.Instantiate(module)
+	ins, err := linker1.Instantiate(module)
 	if err != nil {
 		panic(err)
 	}
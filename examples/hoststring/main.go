@@ -1,10 +1,8 @@
 package main
 
-import "C"
 import (
 	"fmt"
 	"os"
-	"unsafe"
 
 	"github.com/hybridgroup/wasman"
 	"github.com/hybridgroup/wasman/config"
@@ -13,35 +11,47 @@ import (
 // Run me on root folder
 // go run ./examples/hoststring
 func main() {
-	var ins *wasman.Instance
 	linker1 := wasman.NewLinker(config.LinkerConfig{})
 
 	//err := linker1.DefineMemory("env", "memory", make([]byte, 10))
 
-	err := wasman.DefineFunc01(linker1, "env", "host_string", func() uint32 {
+	err := linker1.DefineGoFunc("env", "host_string", func(caller *wasman.Caller) (uint32, error) {
 		message := "WASMan"
 
-		ret, _, err := ins.CallExportedFunc("allocate", uint64(len(message)+1))
+		ret, _, err := caller.Instance.CallExportedFunc("allocate", uint64(len(message)+1))
 		if err != nil {
-			panic(err)
+			return 0, err
 		}
 
-		copy(ins.Memory.Value[ret[0]:], append([]byte(message), byte(0))) // act as a string for rust's CStr::from_ptr(ptr)
+		err = caller.Memory().Write(uint32(ret[0]), append([]byte(message), byte(0))) // act as a string for rust's CStr::from_ptr(ptr)
+		if err != nil {
+			return 0, err
+		}
 
-		return uint32(ret[0])
+		return uint32(ret[0]), nil
 	})
 	if err != nil {
 		panic(err)
 	}
 
-	// cannot call host func in the host func
-	err = wasman.DefineFunc20(linker1, "env", "log_message", func(ptr uint32, l uint32) {
+	err = linker1.DefineGoFunc("env", "log_message", func(caller *wasman.Caller, ptr uint32, l uint32) error {
+		mem := caller.Memory()
+
 		// string way
-		fmt.Println(C.GoString((*C.char)(unsafe.Pointer(&ins.Memory.Value[ptr]))))
+		str, err := mem.ReadCString(ptr)
+		if err != nil {
+			return err
+		}
+		fmt.Println(str)
 
 		// bytes way
-		msg := ins.Memory.Value[ptr : ptr+l]
-		fmt.Println(string(msg))
+		msg, err := mem.ReadString(ptr, l)
+		if err != nil {
+			return err
+		}
+		fmt.Println(msg)
+
+		return nil
 	})
 	if err != nil {
 		panic(err)
@@ -56,7 +66,7 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	ins, err = linker1.Instantiate(module)
+	ins, err := linker1.Instantiate(module)
 	if err != nil {
 		panic(err)
 	}
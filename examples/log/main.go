@@ -1,30 +1,36 @@
 package main
 
 import (
-	"C"
 	"fmt"
 	"os"
 
 	"github.com/hybridgroup/wasman"
 	"github.com/hybridgroup/wasman/config"
 )
-import "unsafe"
 
 // Run me on root folder
 // go run ./examples/log
 func main() {
-	var ins *wasman.Instance
 	linker1 := wasman.NewLinker(config.LinkerConfig{})
 
-	// cannot call host func in the host func
-	err := wasman.DefineFunc20(linker1, "env", "log_message", func(ptr uint32, l uint32) {
+	err := linker1.DefineGoFunc("env", "log_message", func(caller *wasman.Caller, ptr uint32, l uint32) error {
+		mem := caller.Memory()
+
 		// need ptr & l
-		messageByLen := ins.Memory.Value[int(ptr):int(ptr+l)]
-		fmt.Println(string(messageByLen))
+		messageByLen, err := mem.ReadString(ptr, l)
+		if err != nil {
+			return err
+		}
+		fmt.Println(messageByLen)
 
 		// this method just need one ptr
-		messageByCharVec := C.GoString((*C.char)(unsafe.Pointer(&ins.Memory.Value[ptr])))
+		messageByCharVec, err := mem.ReadCString(ptr)
+		if err != nil {
+			return err
+		}
 		fmt.Println(messageByCharVec)
+
+		return nil
 	})
 	if err != nil {
 		panic(err)
@@ -39,7 +45,7 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	ins, err = linker1.Instantiate(module)
+	ins, err := linker1.Instantiate(module)
 	if err != nil {
 		panic(err)
 	}
@@ -52,7 +58,10 @@ func main() {
 
 	ptr := ret[0]
 
-	copy(ins.Memory.Value[ptr:], name)
+	err = ins.Memory.Write(uint32(ptr), []byte(name))
+	if err != nil {
+		panic(err)
+	}
 
 	for range make([]byte, 100) {
 		_, _, err = ins.CallExportedFunc("greet", ptr)
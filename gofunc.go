@@ -0,0 +1,244 @@
+package wasman
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/hybridgroup/wasman/types"
+	"github.com/hybridgroup/wasman/wasm"
+)
+
+var (
+	callerType  = reflect.TypeOf((*Caller)(nil))
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// DefineGoFunc puts the go func f with any number of params and results into Linker's modules,
+// the wasm signature is derived from the types of them.
+//
+// The first param of f can be a *Caller or a context.Context which receives the calling Instance
+// or the context of the running call, and the last result can be an error which traps the guest.
+func (l *Linker) DefineGoFunc(modName, funcName string, f any) error {
+	fv := reflect.ValueOf(f)
+	if !fv.IsValid() || fv.Kind() != reflect.Func || fv.IsNil() {
+		return fmt.Errorf("%w: %T is not a func", ErrInvalidSign, f)
+	}
+
+	ft := fv.Type()
+	params := make([]reflect.Type, 0, ft.NumIn())
+	for i := 0; i < ft.NumIn(); i++ {
+		params = append(params, ft.In(i))
+	}
+
+	withCaller, withContext := false, false
+	if len(params) > 0 {
+		switch params[0] {
+		case callerType:
+			withCaller = true
+			params = params[1:]
+		case contextType:
+			withContext = true
+			params = params[1:]
+		}
+	}
+
+	results := make([]reflect.Type, 0, ft.NumOut())
+	for i := 0; i < ft.NumOut(); i++ {
+		results = append(results, ft.Out(i))
+	}
+
+	withError := len(results) > 0 && results[len(results)-1] == errorType
+	if withError {
+		results = results[:len(results)-1]
+	}
+
+	var err error
+	sig := &types.FuncType{}
+	sig.InputTypes, err = getReflectTypesOf(params)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSign, err)
+	}
+	sig.ReturnTypes, err = getReflectTypesOf(results)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSign, err)
+	}
+
+	call := func(c *Caller, args []uint64) ([]uint64, error) {
+		in := make([]reflect.Value, 0, ft.NumIn())
+		if withCaller {
+			in = append(in, reflect.ValueOf(c))
+		} else if withContext {
+			in = append(in, reflect.ValueOf(c.Context()))
+		}
+
+		for i, t := range params {
+			in = append(in, valueFromU(t, args[i]))
+		}
+
+		out := fv.Call(in)
+		if withError {
+			if err, _ := out[len(out)-1].Interface().(error); err != nil {
+				return nil, err
+			}
+			out = out[:len(out)-1]
+		}
+
+		ret := make([]uint64, len(out))
+		for i, v := range out {
+			ret[i] = valueToU(v)
+		}
+
+		return ret, nil
+	}
+
+	return l.DefineHostFunc(modName, funcName, &wasm.HostFunc{
+		GeneratorWithError: func(ins *Instance) wasm.RawHostFuncWithError {
+			c := &Caller{Instance: ins}
+			return func(args []uint64) ([]uint64, error) {
+				return call(c, args)
+			}
+		},
+		Signature: sig,
+	})
+}
+
+func getReflectTypesOf(ts []reflect.Type) ([]types.ValueType, error) {
+	var err error
+	ret := make([]types.ValueType, len(ts))
+	for i, t := range ts {
+		ret[i], err = getTypeOf(reflect.Zero(t).Interface())
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// valueFromU converts the raw wasm value into the value of the go type t
+func valueFromU(t reflect.Type, val uint64) reflect.Value {
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Float32:
+		v.SetFloat(float64(types.Value{Type: types.ValueTypeF32, Raw: val}.F32()))
+	case reflect.Float64:
+		v.SetFloat(types.Value{Type: types.ValueTypeF64, Raw: val}.F64())
+	case reflect.Bool:
+		v.SetBool(uint32(val) != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+		v.SetInt(int64(int32(val)))
+	case reflect.Int64:
+		v.SetInt(int64(val))
+	case reflect.Uint32:
+		v.SetUint(uint64(uint32(val)))
+	default:
+		v.SetUint(val)
+	}
+	return v
+}
+
+// valueToU converts the go value into the raw wasm value
+func valueToU(v reflect.Value) uint64 {
+	switch v.Kind() {
+	case reflect.Float32:
+		return types.F32(float32(v.Float())).Raw
+	case reflect.Float64:
+		return types.F64(v.Float()).Raw
+	case reflect.Bool:
+		if v.Bool() {
+			return 1
+		}
+		return 0
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+		return uint64(uint32(v.Int()))
+	case reflect.Int64:
+		return uint64(v.Int())
+	case reflect.Uint32:
+		return uint64(uint32(v.Uint()))
+	default:
+		return v.Uint()
+	}
+}
+
+// GetFunc returns the exported func `name` of the Instance as the go func F,
+// whose last result should be an error, and the first param can be a context.Context
+// passed to the CallExportedFuncContext. The signature of F is checked against the exported one.
+func GetFunc[F any](ins *Instance, name string) (F, error) {
+	var f F
+	ft := reflect.TypeOf(f)
+	if ft == nil || ft.Kind() != reflect.Func {
+		return f, fmt.Errorf("%w: %T is not a func", ErrInvalidSign, f)
+	}
+
+	if ft.NumOut() == 0 || ft.Out(ft.NumOut()-1) != errorType {
+		return f, fmt.Errorf("%w: the last result of %T should be an error", ErrInvalidSign, f)
+	}
+
+	params := make([]reflect.Type, 0, ft.NumIn())
+	for i := 0; i < ft.NumIn(); i++ {
+		params = append(params, ft.In(i))
+	}
+
+	withContext := len(params) > 0 && params[0] == contextType
+	if withContext {
+		params = params[1:]
+	}
+
+	results := make([]reflect.Type, 0, ft.NumOut()-1)
+	for i := 0; i < ft.NumOut()-1; i++ {
+		results = append(results, ft.Out(i))
+	}
+
+	sig, err := ins.ExportedFuncType(name)
+	if err != nil {
+		return f, err
+	}
+
+	inputTypes, err := getReflectTypesOf(params)
+	if err != nil {
+		return f, fmt.Errorf("%w: %v", ErrInvalidSign, err)
+	}
+	returnTypes, err := getReflectTypesOf(results)
+	if err != nil {
+		return f, fmt.Errorf("%w: %v", ErrInvalidSign, err)
+	}
+
+	if !types.HasSameSignature(inputTypes, sig.InputTypes) || !types.HasSameSignature(returnTypes, sig.ReturnTypes) {
+		return f, fmt.Errorf("%w: %T does not match %s", ErrInvalidSign, f, name)
+	}
+
+	fv := reflect.MakeFunc(ft, func(in []reflect.Value) []reflect.Value {
+		ctx := context.Background()
+		if withContext {
+			if c, ok := in[0].Interface().(context.Context); ok && c != nil {
+				ctx = c
+			}
+			in = in[1:]
+		}
+
+		args := make([]uint64, len(in))
+		for i, v := range in {
+			args[i] = valueToU(v)
+		}
+
+		out := make([]reflect.Value, ft.NumOut())
+		ret, _, err := ins.CallExportedFuncContext(ctx, name, args...)
+		for i, t := range results {
+			if err != nil {
+				out[i] = reflect.Zero(t)
+			} else {
+				out[i] = valueFromU(t, ret[i])
+			}
+		}
+
+		out[len(out)-1] = reflect.Zero(errorType)
+		if err != nil {
+			out[len(out)-1] = reflect.ValueOf(&err).Elem()
+		}
+
+		return out
+	})
+
+	return fv.Interface().(F), nil
+}
@@ -0,0 +1,196 @@
+package wasman_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/hybridgroup/wasman"
+	"github.com/hybridgroup/wasman/config"
+	"github.com/hybridgroup/wasman/expr"
+	"github.com/hybridgroup/wasman/segments"
+	"github.com/hybridgroup/wasman/types"
+	"github.com/hybridgroup/wasman/utils"
+)
+
+func TestLinker_DefineGoFunc(t *testing.T) {
+	type key struct{}
+	errNegative := errors.New("negative")
+
+	l := wasman.NewLinker(config.LinkerConfig{})
+	err := l.DefineGoFunc("env", "sum", func(ctx context.Context, a int32, b int64, c uint32, d float32, e float64, f int) (float64, int32, error) {
+		if ctx.Value(key{}) != "value" {
+			t.Fail()
+		}
+		if a < 0 {
+			return 0, 0, errNegative
+		}
+		return float64(a) + float64(b) + float64(c) + float64(d) + e + float64(f), -a, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := l.DefineGoFunc("env", "invalid", func(s string) {}); err == nil {
+		t.Fail()
+	}
+	if err := l.DefineGoFunc("env", "invalid", 1); err == nil {
+		t.Fail()
+	}
+	if err := l.DefineGoFunc("env", "invalid", nil); !errors.Is(err, wasman.ErrInvalidSign) {
+		t.Logf("nil: %v", err)
+		t.Fail()
+	}
+	if err := l.DefineGoFunc("env", "invalid", (func())(nil)); !errors.Is(err, wasman.ErrInvalidSign) {
+		t.Logf("nil func: %v", err)
+		t.Fail()
+	}
+
+	i32, i64, f32, f64 := types.ValueTypeI32, types.ValueTypeI64, types.ValueTypeF32, types.ValueTypeF64
+	sig := &types.FuncType{
+		InputTypes:  []types.ValueType{i32, i64, i32, f32, f64, i32},
+		ReturnTypes: []types.ValueType{f64, i32},
+	}
+	mod := &wasman.Module{
+		TypeSection: []*types.FuncType{sig},
+		ImportSection: []*segments.ImportSegment{
+			{Module: "env", Name: "sum", Desc: &segments.ImportDesc{Kind: segments.KindFunction, TypeIndexPtr: utils.Uint32Ptr(0)}},
+		},
+		FunctionSection: []uint32{0},
+		CodeSection: []*segments.CodeSegment{
+			{Body: []byte{
+				expr.OpCodeLocalGet, 0x00,
+				expr.OpCodeLocalGet, 0x01,
+				expr.OpCodeLocalGet, 0x02,
+				expr.OpCodeLocalGet, 0x03,
+				expr.OpCodeLocalGet, 0x04,
+				expr.OpCodeLocalGet, 0x05,
+				expr.OpCodeCall, 0x00,
+				expr.OpCodeEnd,
+			}},
+		},
+		ExportSection: map[string]*segments.ExportSegment{
+			"main": {Name: "main", Desc: &segments.ExportDesc{Kind: segments.KindFunction, Index: 1}},
+		},
+	}
+
+	ins, err := l.Instantiate(mod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.WithValue(context.Background(), key{}, "value")
+	ret, _, err := ins.CallExportedFuncContext(ctx, "main",
+		1, 2, 3, uint64(math.Float32bits(0.5)), math.Float64bits(0.25), uint64(uint32(0xffffffff)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Float64frombits(ret[0]) != 5.75 || int32(ret[1]) != -1 {
+		t.Logf("main: %v", ret)
+		t.Fail()
+	}
+
+	_, _, err = ins.CallExportedFuncContext(ctx, "main", uint64(uint32(0xffffffff)), 0, 0, 0, 0, 0)
+	if !errors.Is(err, errNegative) {
+		t.Logf("main: %v", err)
+		t.Fail()
+	}
+}
+
+func TestLinker_DefineGoFunc_namedTypes(t *testing.T) {
+	type Handle int32
+	type Ratio float64
+
+	l := wasman.NewLinker(config.LinkerConfig{})
+	err := l.DefineGoFunc("env", "scale", func(h Handle, r Ratio) Handle {
+		return Handle(float64(h) * float64(r))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i32, f64 := types.ValueTypeI32, types.ValueTypeF64
+	mod := &wasman.Module{
+		TypeSection: []*types.FuncType{{InputTypes: []types.ValueType{i32, f64}, ReturnTypes: []types.ValueType{i32}}},
+		ImportSection: []*segments.ImportSegment{
+			{Module: "env", Name: "scale", Desc: &segments.ImportDesc{Kind: segments.KindFunction, TypeIndexPtr: utils.Uint32Ptr(0)}},
+		},
+		ExportSection: map[string]*segments.ExportSegment{
+			"scale": {Name: "scale", Desc: &segments.ExportDesc{Kind: segments.KindFunction, Index: 0}},
+		},
+	}
+
+	ins, err := l.Instantiate(mod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ret, _, err := ins.CallExportedFunc("scale", uint64(uint32(0xfffffffa)), math.Float64bits(1.5))
+	if err != nil || int32(ret[0]) != -9 {
+		t.Logf("scale: %v, %v", ret, err)
+		t.Fail()
+	}
+
+	scale, err := wasman.GetFunc[func(Handle, Ratio) (Handle, error)](ins, "scale")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h, err := scale(4, 2); err != nil || h != 8 {
+		t.Logf("scale: %v, %v", h, err)
+		t.Fail()
+	}
+}
+
+func TestGetFunc(t *testing.T) {
+	i32, f64 := types.ValueTypeI32, types.ValueTypeF64
+	mod := &wasman.Module{
+		TypeSection: []*types.FuncType{
+			{InputTypes: []types.ValueType{i32, f64}, ReturnTypes: []types.ValueType{f64, i32}},
+			{},
+		},
+		FunctionSection: []uint32{0, 1},
+		CodeSection: []*segments.CodeSegment{
+			{Body: []byte{expr.OpCodeLocalGet, 0x01, expr.OpCodeLocalGet, 0x00, expr.OpCodeEnd}},
+			{Body: []byte{expr.OpCodeUnreachable, expr.OpCodeEnd}},
+		},
+		ExportSection: map[string]*segments.ExportSegment{
+			"swap":  {Name: "swap", Desc: &segments.ExportDesc{Kind: segments.KindFunction, Index: 0}},
+			"crash": {Name: "crash", Desc: &segments.ExportDesc{Kind: segments.KindFunction, Index: 1}},
+		},
+	}
+
+	ins, err := wasman.NewLinker(config.LinkerConfig{}).Instantiate(mod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	swap, err := wasman.GetFunc[func(context.Context, int32, float64) (float64, int32, error)](ins, "swap")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, b, err := swap(context.Background(), -3, 1.5)
+	if err != nil || a != 1.5 || b != -3 {
+		t.Logf("swap: %v, %v, %v", a, b, err)
+		t.Fail()
+	}
+
+	crash, err := wasman.GetFunc[func() error](ins, "crash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := crash(); err == nil {
+		t.Fail()
+	}
+
+	if _, err := wasman.GetFunc[func(int32, float64) (float64, int32)](ins, "swap"); !errors.Is(err, wasman.ErrInvalidSign) {
+		t.Fail()
+	}
+	if _, err := wasman.GetFunc[func(int32) (float64, int32, error)](ins, "swap"); !errors.Is(err, wasman.ErrInvalidSign) {
+		t.Fail()
+	}
+	if _, err := wasman.GetFunc[func() error](ins, "none"); err == nil {
+		t.Fail()
+	}
+}
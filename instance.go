@@ -5,6 +5,21 @@ import "github.com/hybridgroup/wasman/wasm"
 // Instance is same to wasm.Instance
 type Instance = wasm.Instance
 
+// Caller is same to wasm.Caller
+type Caller = wasm.Caller
+
+// ExitError is same to wasm.ExitError
+type ExitError = wasm.ExitError
+
+// Trap is same to wasm.Trap
+type Trap = wasm.Trap
+
+// Memory is same to wasm.Memory
+type Memory = wasm.Memory
+
+// MemoryView is same to wasm.MemoryView
+type MemoryView = wasm.MemoryView
+
 // NewInstance is a wrapper to the wasm.NewInstance
 func NewInstance(module *Module, externModules map[string]*Module) (*Instance, error) {
 	return wasm.NewInstance(module, externModules)
@@ -3,7 +3,8 @@ package wasman
 import (
 	"errors"
 	"fmt"
-	"math"
+	"reflect"
+	"sort"
 
 	"github.com/hybridgroup/wasman/config"
 	"github.com/hybridgroup/wasman/utils"
@@ -98,6 +99,50 @@ func DefineFunc32[A, B, C, Y, Z Primitive](l *Linker, modName, funcName string,
 	return l.defineFunc(modName, funcName, wrapFunc32(f), []any{*new(A), *new(B), *new(C)}, []any{*new(Y), *new(Z)})
 }
 
+func DefineFunc00E(l *Linker, modName, funcName string, f func() error) error {
+	return l.defineFuncE(modName, funcName, wrapFunc00E(f), []any{}, []any{})
+}
+
+func DefineFunc01E[Z Primitive](l *Linker, modName, funcName string, f func() (Z, error)) error {
+	return l.defineFuncE(modName, funcName, wrapFunc01E(f), []any{}, []any{*new(Z)})
+}
+
+func DefineFunc02E[Y, Z Primitive](l *Linker, modName, funcName string, f func() (Y, Z, error)) error {
+	return l.defineFuncE(modName, funcName, wrapFunc02E(f), []any{}, []any{*new(Y), *new(Z)})
+}
+
+func DefineFunc10E[A Primitive](l *Linker, modName, funcName string, f func(A) error) error {
+	return l.defineFuncE(modName, funcName, wrapFunc10E(f), []any{*new(A)}, []any{})
+}
+
+func DefineFunc11E[A, Z Primitive](l *Linker, modName, funcName string, f func(A) (Z, error)) error {
+	return l.defineFuncE(modName, funcName, wrapFunc11E(f), []any{*new(A)}, []any{*new(Z)})
+}
+
+func DefineFunc12E[A, Y, Z Primitive](l *Linker, modName, funcName string, f func(A) (Y, Z, error)) error {
+	return l.defineFuncE(modName, funcName, wrapFunc12E(f), []any{*new(A)}, []any{*new(Y), *new(Z)})
+}
+
+func DefineFunc20E[A, B Primitive](l *Linker, modName, funcName string, f func(A, B) error) error {
+	return l.defineFuncE(modName, funcName, wrapFunc20E(f), []any{*new(A), *new(B)}, []any{})
+}
+
+func DefineFunc21E[A, B, Z Primitive](l *Linker, modName, funcName string, f func(A, B) (Z, error)) error {
+	return l.defineFuncE(modName, funcName, wrapFunc21E(f), []any{*new(A), *new(B)}, []any{*new(Z)})
+}
+
+func DefineFunc22E[A, B, Y, Z Primitive](l *Linker, modName, funcName string, f func(A, B) (Y, Z, error)) error {
+	return l.defineFuncE(modName, funcName, wrapFunc22E(f), []any{*new(A), *new(B)}, []any{*new(Y), *new(Z)})
+}
+
+func DefineFunc31E[A, B, C, Z Primitive](l *Linker, modName, funcName string, f func(A, B, C) (Z, error)) error {
+	return l.defineFuncE(modName, funcName, wrapFunc31E(f), []any{*new(A), *new(B), *new(C)}, []any{*new(Z)})
+}
+
+func DefineFunc32E[A, B, C, Y, Z Primitive](l *Linker, modName, funcName string, f func(A, B, C) (Y, Z, error)) error {
+	return l.defineFuncE(modName, funcName, wrapFunc32E(f), []any{*new(A), *new(B), *new(C)}, []any{*new(Y), *new(Z)})
+}
+
 func (l *Linker) defineFunc(modName, funcName string, f wasm.RawHostFunc, ins []any, outs []any) error {
 	var err error
 	sig := &types.FuncType{}
@@ -112,10 +157,48 @@ func (l *Linker) defineFunc(modName, funcName string, f wasm.RawHostFunc, ins []
 	return l.DefineRawHostFunc(modName, funcName, sig, f)
 }
 
+func (l *Linker) defineFuncE(modName, funcName string, f wasm.RawHostFuncWithError, ins []any, outs []any) error {
+	var err error
+	sig := &types.FuncType{}
+	sig.InputTypes, err = getTypesOf(ins)
+	if err != nil {
+		return err
+	}
+	sig.ReturnTypes, err = getTypesOf(outs)
+	if err != nil {
+		return err
+	}
+	return l.DefineRawHostFuncE(modName, funcName, sig, f)
+}
+
 // DefineRawHostFunc puts a simple raw func into Linker's modules.
 func (l *Linker) DefineRawHostFunc(
 	modName, funcName string, sig *types.FuncType, f wasm.RawHostFunc,
 ) error {
+	return l.DefineHostFunc(modName, funcName, &wasm.HostFunc{
+		Generator: func(_ *Instance) wasm.RawHostFunc {
+			return f
+		},
+		Signature: sig,
+	})
+}
+
+// DefineRawHostFuncE puts a simple raw func into Linker's modules,
+// the error returned by it traps the guest.
+func (l *Linker) DefineRawHostFuncE(
+	modName, funcName string, sig *types.FuncType, f wasm.RawHostFuncWithError,
+) error {
+	return l.DefineHostFunc(modName, funcName, &wasm.HostFunc{
+		GeneratorWithError: func(_ *Instance) wasm.RawHostFuncWithError {
+			return f
+		},
+		Signature: sig,
+	})
+}
+
+// DefineHostFunc puts a host func into Linker's modules,
+// its Generator will be called with each Instance importing it.
+func (l *Linker) DefineHostFunc(modName, funcName string, f *wasm.HostFunc) error {
 	mod, exists := l.Modules[modName]
 	if !exists {
 		mod = &Module{IndexSpace: new(wasm.IndexSpace), ExportSection: map[string]*segments.ExportSegment{}}
@@ -134,12 +217,7 @@ func (l *Linker) DefineRawHostFunc(
 		},
 	}
 
-	mod.IndexSpace.Functions = append(mod.IndexSpace.Functions, &wasm.HostFunc{
-		Generator: func(_ *Instance) wasm.RawHostFunc {
-			return f
-		},
-		Signature: sig,
-	})
+	mod.IndexSpace.Functions = append(mod.IndexSpace.Functions, f)
 
 	return nil
 }
@@ -240,6 +318,89 @@ func (l *Linker) DefineMemory(modName, memName string, mem []byte) error {
 	return nil
 }
 
+// DefineInstance puts the funcs, memories and globals exported by the instance into Linker's modules as the module modName,
+// so that the modules instantiated later can import them.
+// The funcs run on the instance, and the memories are shared with the instances importing them.
+// The tables are not exported, as their elements are the funcs of the instance.
+func (l *Linker) DefineInstance(modName string, ins *Instance) error {
+	names := make([]string, 0, len(ins.ExportSection))
+	for name := range ins.ExportSection {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		name, desc := name, ins.ExportSection[name].Desc
+		switch desc.Kind {
+		case segments.KindFunction:
+			ft, err := ins.ExportedFuncType(name)
+			if err != nil {
+				return err
+			}
+
+			err = l.DefineHostFunc(modName, name, &wasm.HostFunc{
+				GeneratorWithError: func(importer *Instance) wasm.RawHostFuncWithError {
+					caller := &Caller{Instance: importer}
+					return func(args []uint64) ([]uint64, error) {
+						ret, _, err := ins.CallExportedFuncContext(caller.Context(), name, args...)
+						return ret, err
+					}
+				},
+				Signature: ft,
+			})
+			if err != nil {
+				return err
+			}
+		case segments.KindMem:
+			if desc.Index >= uint32(len(ins.IndexSpace.Memories)) {
+				return fmt.Errorf("memory %s: exported index out of range", name)
+			}
+
+			err := l.defineExport(modName, name, segments.KindMem, func(mod *Module) uint32 {
+				mod.IndexSpace.Memories = append(mod.IndexSpace.Memories, ins.IndexSpace.Memories[desc.Index])
+				return uint32(len(mod.IndexSpace.Memories) - 1)
+			})
+			if err != nil {
+				return err
+			}
+		case segments.KindGlobal:
+			if desc.Index >= uint32(len(ins.IndexSpace.Globals)) {
+				return fmt.Errorf("global %s: exported index out of range", name)
+			}
+
+			err := l.defineExport(modName, name, segments.KindGlobal, func(mod *Module) uint32 {
+				mod.IndexSpace.Globals = append(mod.IndexSpace.Globals, ins.IndexSpace.Globals[desc.Index])
+				return uint32(len(mod.IndexSpace.Globals) - 1)
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// defineExport exports the value appended by the add to the module modName with the name
+func (l *Linker) defineExport(modName, name string, kind segments.Kind, add func(mod *Module) uint32) error {
+	mod, exists := l.Modules[modName]
+	if !exists {
+		mod = &Module{IndexSpace: new(wasm.IndexSpace), ExportSection: map[string]*segments.ExportSegment{}}
+		l.Modules[modName] = mod
+	}
+
+	if l.DisableShadowing && mod.ExportSection[name] != nil {
+		return config.ErrShadowing
+	}
+
+	mod.ExportSection[name] = &segments.ExportSegment{
+		Name: name,
+		Desc: &segments.ExportDesc{Kind: kind, Index: add(mod)},
+	}
+
+	return nil
+}
+
 // Instantiate will instantiate a Module into an runnable Instance
 func (l *Linker) Instantiate(mainModule *Module) (*Instance, error) {
 	return NewInstance(mainModule, l.Modules)
@@ -257,19 +418,25 @@ func getTypesOf(defaults []any) ([]types.ValueType, error) {
 	return types, nil
 }
 
-// getTypeOf converts the go type into wasm val type
+// getTypeOf converts the go type into wasm val type by its kind,
+// so the named types like `type Handle int32` are accepted too
 func getTypeOf(def any) (types.ValueType, error) {
-	switch def.(type) {
-	case float64:
+	t := reflect.TypeOf(def)
+	if t == nil {
+		return 0x00, fmt.Errorf("invalid type: %T", def)
+	}
+
+	switch t.Kind() {
+	case reflect.Float64:
 		return types.ValueTypeF64, nil
-	case float32:
+	case reflect.Float32:
 		return types.ValueTypeF32, nil
-	case int32, uint32, int, int16, int8, bool:
+	case reflect.Int32, reflect.Uint32, reflect.Int, reflect.Int16, reflect.Int8, reflect.Bool:
 		return types.ValueTypeI32, nil
-	case int64, uint64, uintptr, uint:
+	case reflect.Int64, reflect.Uint64, reflect.Uintptr, reflect.Uint:
 		return types.ValueTypeI64, nil
 	default:
-		return 0x00, fmt.Errorf("invalid type: %T", def)
+		return 0x00, fmt.Errorf("invalid type: %s", t)
 	}
 }
 
@@ -376,12 +543,148 @@ func wrapFunc32[A, B, C, Y, Z Primitive](f func(A, B, C) (Y, Z)) wasm.RawHostFun
 	return wrapper
 }
 
+func wrapFunc00E(f func() error) wasm.RawHostFuncWithError {
+	wrapper := func(a []uint64) ([]uint64, error) {
+		err := f()
+		if err != nil {
+			return nil, err
+		}
+		return []uint64{}, nil
+	}
+	return wrapper
+}
+
+func wrapFunc01E[Z Primitive](f func() (Z, error)) wasm.RawHostFuncWithError {
+	wrapper := func(a []uint64) ([]uint64, error) {
+		r1, err := f()
+		if err != nil {
+			return nil, err
+		}
+		return []uint64{toU(r1)}, nil
+	}
+	return wrapper
+}
+
+func wrapFunc02E[Y, Z Primitive](f func() (Y, Z, error)) wasm.RawHostFuncWithError {
+	wrapper := func(a []uint64) ([]uint64, error) {
+		r1, r2, err := f()
+		if err != nil {
+			return nil, err
+		}
+		return []uint64{toU(r1), toU(r2)}, nil
+	}
+	return wrapper
+}
+
+func wrapFunc10E[A Primitive](f func(A) error) wasm.RawHostFuncWithError {
+	wrapper := func(a []uint64) ([]uint64, error) {
+		a1 := fromU[A](a[0])
+		err := f(a1)
+		if err != nil {
+			return nil, err
+		}
+		return []uint64{}, nil
+	}
+	return wrapper
+}
+
+func wrapFunc11E[A, Z Primitive](f func(A) (Z, error)) wasm.RawHostFuncWithError {
+	wrapper := func(a []uint64) ([]uint64, error) {
+		a1 := fromU[A](a[0])
+		r1, err := f(a1)
+		if err != nil {
+			return nil, err
+		}
+		return []uint64{toU(r1)}, nil
+	}
+	return wrapper
+}
+
+func wrapFunc12E[A, Y, Z Primitive](f func(A) (Y, Z, error)) wasm.RawHostFuncWithError {
+	wrapper := func(a []uint64) ([]uint64, error) {
+		a1 := fromU[A](a[0])
+		r1, r2, err := f(a1)
+		if err != nil {
+			return nil, err
+		}
+		return []uint64{toU(r1), toU(r2)}, nil
+	}
+	return wrapper
+}
+
+func wrapFunc20E[A, B Primitive](f func(A, B) error) wasm.RawHostFuncWithError {
+	wrapper := func(a []uint64) ([]uint64, error) {
+		a1 := fromU[A](a[0])
+		a2 := fromU[B](a[1])
+		err := f(a1, a2)
+		if err != nil {
+			return nil, err
+		}
+		return []uint64{}, nil
+	}
+	return wrapper
+}
+
+func wrapFunc21E[A, B, Z Primitive](f func(A, B) (Z, error)) wasm.RawHostFuncWithError {
+	wrapper := func(a []uint64) ([]uint64, error) {
+		a1 := fromU[A](a[0])
+		a2 := fromU[B](a[1])
+		r1, err := f(a1, a2)
+		if err != nil {
+			return nil, err
+		}
+		return []uint64{toU(r1)}, nil
+	}
+	return wrapper
+}
+
+func wrapFunc22E[A, B, Y, Z Primitive](f func(A, B) (Y, Z, error)) wasm.RawHostFuncWithError {
+	wrapper := func(a []uint64) ([]uint64, error) {
+		a1 := fromU[A](a[0])
+		a2 := fromU[B](a[1])
+		r1, r2, err := f(a1, a2)
+		if err != nil {
+			return nil, err
+		}
+		return []uint64{toU(r1), toU(r2)}, nil
+	}
+	return wrapper
+}
+
+func wrapFunc31E[A, B, C, Z Primitive](f func(A, B, C) (Z, error)) wasm.RawHostFuncWithError {
+	wrapper := func(a []uint64) ([]uint64, error) {
+		a1 := fromU[A](a[0])
+		a2 := fromU[B](a[1])
+		a3 := fromU[C](a[2])
+		r1, err := f(a1, a2, a3)
+		if err != nil {
+			return nil, err
+		}
+		return []uint64{toU(r1)}, nil
+	}
+	return wrapper
+}
+
+func wrapFunc32E[A, B, C, Y, Z Primitive](f func(A, B, C) (Y, Z, error)) wasm.RawHostFuncWithError {
+	wrapper := func(a []uint64) ([]uint64, error) {
+		a1 := fromU[A](a[0])
+		a2 := fromU[B](a[1])
+		a3 := fromU[C](a[2])
+		r1, r2, err := f(a1, a2, a3)
+		if err != nil {
+			return nil, err
+		}
+		return []uint64{toU(r1), toU(r2)}, nil
+	}
+	return wrapper
+}
+
 func fromU[T Primitive](val uint64) T {
 	switch any(*new(T)).(type) {
 	case float32:
-		return T(float32(math.Float64frombits(val)))
+		return T(types.Value{Type: types.ValueTypeF32, Raw: val}.F32())
 	case float64:
-		return T(math.Float64frombits(val))
+		return T(types.Value{Type: types.ValueTypeF64, Raw: val}.F64())
 	default:
 		return T(val)
 	}
@@ -390,9 +693,9 @@ func fromU[T Primitive](val uint64) T {
 func toU[T Primitive](val T) uint64 {
 	switch v := any(val).(type) {
 	case float32:
-		return math.Float64bits(float64(v))
+		return types.F32(v).Raw
 	case float64:
-		return math.Float64bits(v)
+		return types.F64(v).Raw
 	default:
 		return uint64(val)
 	}
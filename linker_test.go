@@ -0,0 +1,356 @@
+package wasman_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hybridgroup/wasman"
+	"github.com/hybridgroup/wasman/config"
+	"github.com/hybridgroup/wasman/expr"
+	"github.com/hybridgroup/wasman/segments"
+	"github.com/hybridgroup/wasman/types"
+	"github.com/hybridgroup/wasman/utils"
+	"github.com/hybridgroup/wasman/wasm"
+)
+
+func TestLinker_DefineRawHostFunc(t *testing.T) {
+	errInvalidPtr := errors.New("invalid pointer")
+
+	i32 := types.ValueTypeI32
+	sig := &types.FuncType{InputTypes: []types.ValueType{i32}, ReturnTypes: []types.ValueType{i32}}
+
+	l := wasman.NewLinker(config.LinkerConfig{})
+	err := l.DefineRawHostFuncE("env", "check", sig, func(args []uint64) ([]uint64, error) {
+		if uint32(args[0]) == 0 {
+			return nil, errInvalidPtr
+		}
+		return []uint64{args[0] * 2}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = wasman.DefineFunc11E(l, "env", "half", func(v uint32) (uint32, error) {
+		if v%2 != 0 {
+			return 0, errInvalidPtr
+		}
+		return v / 2, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mod := &wasman.Module{
+		TypeSection: []*types.FuncType{{InputTypes: []types.ValueType{i32}, ReturnTypes: []types.ValueType{i32}}},
+		ImportSection: []*segments.ImportSegment{
+			{Module: "env", Name: "check", Desc: &segments.ImportDesc{Kind: segments.KindFunction, TypeIndexPtr: utils.Uint32Ptr(0)}},
+			{Module: "env", Name: "half", Desc: &segments.ImportDesc{Kind: segments.KindFunction, TypeIndexPtr: utils.Uint32Ptr(0)}},
+		},
+		FunctionSection: []uint32{0, 0},
+		CodeSection: []*segments.CodeSegment{
+			{Body: []byte{expr.OpCodeLocalGet, 0x00, expr.OpCodeCall, 0x00, expr.OpCodeEnd}},
+			{Body: []byte{expr.OpCodeLocalGet, 0x00, expr.OpCodeCall, 0x01, expr.OpCodeEnd}},
+		},
+		ExportSection: map[string]*segments.ExportSegment{
+			"main":  {Name: "main", Desc: &segments.ExportDesc{Kind: segments.KindFunction, Index: 2}},
+			"halve": {Name: "halve", Desc: &segments.ExportDesc{Kind: segments.KindFunction, Index: 3}},
+		},
+	}
+
+	ins, err := l.Instantiate(mod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ret, _, err := ins.CallExportedFunc("main", 21)
+	if err != nil || ret[0] != 42 {
+		t.Logf("main: %v, %v", ret, err)
+		t.Fail()
+	}
+
+	_, _, err = ins.CallExportedFunc("main", 0)
+	var trap *wasman.Trap
+	if !errors.Is(err, errInvalidPtr) || !errors.As(err, &trap) || trap.OpCode != expr.OpCodeCall {
+		t.Logf("main: %v", err)
+		t.Fail()
+	}
+
+	ret, _, err = ins.CallExportedFunc("halve", 42)
+	if err != nil || ret[0] != 21 {
+		t.Logf("halve: %v, %v", ret, err)
+		t.Fail()
+	}
+
+	if _, _, err = ins.CallExportedFunc("halve", 21); !errors.Is(err, errInvalidPtr) {
+		t.Logf("halve: %v", err)
+		t.Fail()
+	}
+}
+
+func TestDefineFunc11_float32(t *testing.T) {
+	l := wasman.NewLinker(config.LinkerConfig{})
+	err := wasman.DefineFunc11(l, "env", "half", func(v float32) float32 {
+		return v / 2
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f32 := types.ValueTypeF32
+	mod := &wasman.Module{
+		TypeSection: []*types.FuncType{{InputTypes: []types.ValueType{f32}, ReturnTypes: []types.ValueType{f32}}},
+		ImportSection: []*segments.ImportSegment{
+			{Module: "env", Name: "half", Desc: &segments.ImportDesc{Kind: segments.KindFunction, TypeIndexPtr: utils.Uint32Ptr(0)}},
+		},
+		FunctionSection: []uint32{0},
+		CodeSection: []*segments.CodeSegment{
+			// the f32.add makes sure the host result is encoded like the interpreter does
+			{Body: []byte{expr.OpCodeLocalGet, 0x00, expr.OpCodeCall, 0x00, expr.OpCodeLocalGet, 0x00, expr.OpCodeF32Add, expr.OpCodeEnd}},
+		},
+		ExportSection: map[string]*segments.ExportSegment{
+			"main": {Name: "main", Desc: &segments.ExportDesc{Kind: segments.KindFunction, Index: 1}},
+		},
+	}
+
+	ins, err := l.Instantiate(mod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ret, err := ins.Call(context.Background(), "main", types.F32(3))
+	if err != nil || len(ret) != 1 || ret[0] != types.F32(4.5) {
+		t.Logf("main: %v, %v", ret, err)
+		t.Fail()
+	}
+
+	if _, err := ins.Call(context.Background(), "main", types.F64(3)); !errors.Is(err, wasm.ErrFuncInvalidInputType) {
+		t.Logf("main: %v", err)
+		t.Fail()
+	}
+}
+
+func TestLinker_DefineHostFunc_caller(t *testing.T) {
+	i32 := types.ValueTypeI32
+
+	l := wasman.NewLinker(config.LinkerConfig{})
+	err := l.DefineHostFunc("env", "bump", &wasm.HostFunc{
+		GeneratorWithError: func(ins *wasman.Instance) wasm.RawHostFuncWithError {
+			caller := &wasman.Caller{Instance: ins}
+			return func(args []uint64) ([]uint64, error) {
+				if caller.Frame() == nil {
+					t.Fail()
+				}
+
+				counter, ok := caller.Global("counter")
+				if !ok || !caller.SetGlobal("counter", types.I32(counter.I32()+int32(caller.Memory().Value[uint32(args[0])]))) {
+					t.Fail()
+				}
+				return []uint64{}, nil
+			}
+		},
+		Signature: &types.FuncType{InputTypes: []types.ValueType{i32}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mod := &wasman.Module{
+		TypeSection: []*types.FuncType{{InputTypes: []types.ValueType{i32}}, {}},
+		ImportSection: []*segments.ImportSegment{
+			{Module: "env", Name: "bump", Desc: &segments.ImportDesc{Kind: segments.KindFunction, TypeIndexPtr: utils.Uint32Ptr(0)}},
+		},
+		FunctionSection: []uint32{1},
+		MemorySection:   []*types.MemoryType{{Min: 1}},
+		GlobalSection: []*segments.GlobalSegment{
+			{Type: &types.GlobalType{ValType: i32, Mutable: true}, Init: &expr.Expression{OpCode: expr.OpCodeI32Const, Data: []byte{0x01}}},
+		},
+		CodeSection: []*segments.CodeSegment{
+			{Body: []byte{expr.OpCodeI32Const, 0x04, expr.OpCodeCall, 0x00, expr.OpCodeEnd}},
+		},
+		DataSection: []*segments.DataSegment{
+			{OffsetExpression: &expr.Expression{OpCode: expr.OpCodeI32Const, Data: []byte{0x04}}, Init: []byte{0x29}},
+		},
+		ExportSection: map[string]*segments.ExportSegment{
+			"main":    {Name: "main", Desc: &segments.ExportDesc{Kind: segments.KindFunction, Index: 1}},
+			"counter": {Name: "counter", Desc: &segments.ExportDesc{Kind: segments.KindGlobal, Index: 0}},
+		},
+	}
+
+	// the host module is shared by the instances
+	for i := 0; i < 2; i++ {
+		ins, err := l.Instantiate(mod)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, _, err := ins.CallExportedFunc("main"); err != nil {
+			t.Fatal(err)
+		}
+
+		if counter, ok := ins.ExportedGlobal("counter"); !ok || counter != types.I32(42) {
+			t.Logf("counter: %s", counter)
+			t.Fail()
+		}
+	}
+}
+
+func TestCaller_reentrant(t *testing.T) {
+	l := wasman.NewLinker(config.LinkerConfig{})
+	err := l.DefineGoFunc("env", "each", func(caller *wasman.Caller, n uint32) (uint32, error) {
+		// the trap of the nested call should not break the outer one
+		if _, _, err := caller.Instance.CallExportedFunc("crash"); err == nil {
+			t.Fail()
+		}
+
+		sum := uint32(0)
+		for i := uint32(0); i < n; i++ {
+			ret, _, err := caller.Instance.CallExportedFunc("square", uint64(i))
+			if err != nil {
+				return 0, err
+			}
+			sum += uint32(ret[0])
+		}
+		return sum, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = l.DefineGoFunc("env", "stop", func(caller *wasman.Caller) error {
+		caller.Instance.Interrupt()
+
+		// the nested call does not clear the interruption
+		if _, _, err := caller.Instance.CallExportedFunc("square", 1); !errors.Is(err, wasm.ErrInterrupted) {
+			t.Fail()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i32 := types.ValueTypeI32
+	mod := &wasman.Module{
+		TypeSection: []*types.FuncType{{InputTypes: []types.ValueType{i32}, ReturnTypes: []types.ValueType{i32}}, {}},
+		ImportSection: []*segments.ImportSegment{
+			{Module: "env", Name: "each", Desc: &segments.ImportDesc{Kind: segments.KindFunction, TypeIndexPtr: utils.Uint32Ptr(0)}},
+			{Module: "env", Name: "stop", Desc: &segments.ImportDesc{Kind: segments.KindFunction, TypeIndexPtr: utils.Uint32Ptr(1)}},
+		},
+		FunctionSection: []uint32{0, 0, 1, 1},
+		CodeSection: []*segments.CodeSegment{
+			{Body: []byte{
+				expr.OpCodeI32Const, 0xe4, 0x00, // 100
+				expr.OpCodeLocalGet, 0x00,
+				expr.OpCodeCall, 0x00,
+				expr.OpCodeI32Add,
+				expr.OpCodeEnd,
+			}},
+			{Body: []byte{expr.OpCodeLocalGet, 0x00, expr.OpCodeLocalGet, 0x00, expr.OpCodeI32Mul, expr.OpCodeEnd}},
+			{Body: []byte{expr.OpCodeUnreachable, expr.OpCodeEnd}},
+			{Body: []byte{expr.OpCodeCall, 0x01, expr.OpCodeNop, expr.OpCodeEnd}},
+		},
+		ExportSection: map[string]*segments.ExportSegment{
+			"main":   {Name: "main", Desc: &segments.ExportDesc{Kind: segments.KindFunction, Index: 2}},
+			"square": {Name: "square", Desc: &segments.ExportDesc{Kind: segments.KindFunction, Index: 3}},
+			"crash":  {Name: "crash", Desc: &segments.ExportDesc{Kind: segments.KindFunction, Index: 4}},
+			"stop":   {Name: "stop", Desc: &segments.ExportDesc{Kind: segments.KindFunction, Index: 5}},
+		},
+	}
+
+	ins, err := l.Instantiate(mod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ret, _, err := ins.CallExportedFunc("main", 4)
+	if err != nil || ret[0] != 114 {
+		t.Logf("main: %v, %v", ret, err)
+		t.Fail()
+	}
+
+	if _, _, err := ins.CallExportedFunc("stop"); !errors.Is(err, wasm.ErrInterrupted) {
+		t.Logf("stop: %v", err)
+		t.Fail()
+	}
+
+	if ins.OperandStack.Ptr != -1 || ins.FrameStack.Ptr != -1 || ins.Active != nil {
+		t.Logf("operand stack %v, frame stack %v", ins.OperandStack.Ptr, ins.FrameStack.Ptr)
+		t.Fail()
+	}
+}
+
+func TestLinker_DefineInstance(t *testing.T) {
+	i32 := types.ValueTypeI32
+	ft := &types.FuncType{ReturnTypes: []types.ValueType{i32}}
+
+	// next counts up the mutable global of the lib, and adds the byte at 0 of its memory
+	lib := &wasman.Module{
+		TypeSection:     []*types.FuncType{ft},
+		FunctionSection: []uint32{0},
+		MemorySection:   []*types.MemoryType{{Min: 1}},
+		GlobalSection: []*segments.GlobalSegment{
+			{Type: &types.GlobalType{ValType: i32}, Init: &expr.Expression{OpCode: expr.OpCodeI32Const, Data: []byte{0x28}}},
+			{Type: &types.GlobalType{ValType: i32, Mutable: true}, Init: &expr.Expression{OpCode: expr.OpCodeI32Const, Data: []byte{0x00}}},
+		},
+		CodeSection: []*segments.CodeSegment{
+			{Body: []byte{
+				expr.OpCodeGlobalGet, 0x01, expr.OpCodeI32Const, 0x01, expr.OpCodeI32Add, expr.OpCodeGlobalSet, 0x01,
+				expr.OpCodeGlobalGet, 0x01, expr.OpCodeI32Const, 0x00, expr.OpCodeI32Load8u, 0x00, 0x00, expr.OpCodeI32Add,
+				expr.OpCodeEnd,
+			}},
+		},
+		ExportSection: map[string]*segments.ExportSegment{
+			"next": {Name: "next", Desc: &segments.ExportDesc{Kind: segments.KindFunction, Index: 0}},
+			"mem":  {Name: "mem", Desc: &segments.ExportDesc{Kind: segments.KindMem, Index: 0}},
+			"base": {Name: "base", Desc: &segments.ExportDesc{Kind: segments.KindGlobal, Index: 0}},
+		},
+	}
+
+	// main writes 2 into the memory of the lib, and returns next() + base
+	mod := &wasman.Module{
+		TypeSection: []*types.FuncType{ft},
+		ImportSection: []*segments.ImportSegment{
+			{Module: "lib", Name: "next", Desc: &segments.ImportDesc{Kind: segments.KindFunction, TypeIndexPtr: utils.Uint32Ptr(0)}},
+			{Module: "lib", Name: "mem", Desc: &segments.ImportDesc{Kind: segments.KindMem, MemTypePtr: &types.MemoryType{Min: 1}}},
+			{Module: "lib", Name: "base", Desc: &segments.ImportDesc{Kind: segments.KindGlobal, GlobalTypePtr: &types.GlobalType{ValType: i32}}},
+		},
+		FunctionSection: []uint32{0},
+		CodeSection: []*segments.CodeSegment{
+			{Body: []byte{expr.OpCodeCall, 0x00, expr.OpCodeGlobalGet, 0x00, expr.OpCodeI32Add, expr.OpCodeEnd}},
+		},
+		DataSection: []*segments.DataSegment{
+			{OffsetExpression: &expr.Expression{OpCode: expr.OpCodeI32Const, Data: []byte{0x00}}, Init: []byte{0x02}},
+		},
+		ExportSection: map[string]*segments.ExportSegment{
+			"main": {Name: "main", Desc: &segments.ExportDesc{Kind: segments.KindFunction, Index: 1}},
+		},
+	}
+
+	l := wasman.NewLinker(config.LinkerConfig{})
+	libIns, err := l.Instantiate(lib)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.DefineInstance("lib", libIns); err != nil {
+		t.Fatal(err)
+	}
+
+	ins, err := l.Instantiate(mod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []uint64{43, 44} {
+		if ret, _, err := ins.CallExportedFunc("main"); err != nil || ret[0] != want {
+			t.Logf("main: %v, %v, want %d", ret, err, want)
+			t.Fail()
+		}
+	}
+
+	// the memory is shared, and the globals of the lib are updated by the lib
+	if ins.Memory != libIns.Memory || libIns.Memory.Value[0] != 2 || libIns.Globals[1] != 2 {
+		t.Logf("memory[0] %d, counter %d", libIns.Memory.Value[0], libIns.Globals[1])
+		t.Fail()
+	}
+}
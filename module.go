@@ -2,6 +2,7 @@ package wasman
 
 import (
 	"bytes"
+	"io"
 
 	"github.com/hybridgroup/wasman/config"
 	"github.com/hybridgroup/wasman/utils"
@@ -11,6 +12,9 @@ import (
 // Module is same to wasm.Module
 type Module = wasm.Module
 
+// CustomSection is same to wasm.CustomSection
+type CustomSection = wasm.CustomSection
+
 // NewModule is a wrapper to the wasm.NewModule
 func NewModule(config config.ModuleConfig, r utils.Reader) (*Module, error) {
 	return wasm.NewModule(config, r)
@@ -21,3 +25,8 @@ func NewModule(config config.ModuleConfig, r utils.Reader) (*Module, error) {
 func NewModuleFromBytes(config config.ModuleConfig, b []byte) (*Module, error) {
 	return wasm.NewModule(config, bytes.NewReader(b))
 }
+
+// GenerateGo is a wrapper to the wasm.GenerateGo
+func GenerateGo(w io.Writer, pkg string, binary []byte) error {
+	return wasm.GenerateGo(w, pkg, binary)
+}
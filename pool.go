@@ -0,0 +1,150 @@
+package wasman
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/hybridgroup/wasman/types"
+)
+
+// errors on pool
+var (
+	ErrInvalidPoolSize = errors.New("pool size should be positive")
+	ErrNotInPool       = errors.New("instance is not taken from the pool")
+)
+
+// Pool is a goroutine-safe set of the Instances of one Module,
+// each Instance is used by a single goroutine at a time.
+//
+// An Instance put back is dropped and a fresh one is instantiated in place of it on the next Get,
+// so that a call does not see the memory, globals and host state (e.g. the wasi fds) left by the former calls.
+// The one failed on a call (e.g. trapped or interrupted) is dropped in the same way.
+type Pool struct {
+	// Recycle puts the Instance back as it is, keeping its memory, globals and host state for the next Get,
+	// which saves the instantiation but does not isolate the calls
+	Recycle bool
+
+	linker *Linker
+	module *Module
+
+	// instances holds the idle Instances, nil for the ones to be instantiated
+	instances chan *Instance
+
+	mu    sync.Mutex
+	taken map[*Instance]struct{} // the Instances given by Get and not given back yet
+}
+
+// NewPool instantiates n Instances of the module with the modules of the linker,
+// the linker should not be modified while the pool is in use
+func NewPool(l *Linker, module *Module, n int) (*Pool, error) {
+	if n <= 0 {
+		return nil, ErrInvalidPoolSize
+	}
+
+	p := &Pool{
+		linker:    l,
+		module:    module,
+		instances: make(chan *Instance, n),
+		taken:     map[*Instance]struct{}{},
+	}
+
+	for i := 0; i < n; i++ {
+		ins, err := l.Instantiate(module)
+		if err != nil {
+			return nil, err
+		}
+
+		p.instances <- ins
+	}
+
+	return p, nil
+}
+
+// Get takes an idle Instance from the pool, waiting for one until the ctx is done.
+// The Instance should be given back with Put or Discard.
+func (p *Pool) Get(ctx context.Context) (*Instance, error) {
+	var ins *Instance
+	select {
+	case ins = <-p.instances:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if ins == nil {
+		var err error
+		ins, err = p.linker.Instantiate(p.module)
+		if err != nil {
+			// retried by the next Get
+			p.instances <- nil
+			return nil, err
+		}
+	}
+
+	p.mu.Lock()
+	p.taken[ins] = struct{}{}
+	p.mu.Unlock()
+
+	return ins, nil
+}
+
+// Put gives the Instance back to the pool,
+// it is replaced by a fresh Instance on the next Get unless the pool recycles the Instances.
+// ErrNotInPool is returned for an Instance not taken by Get or already given back.
+func (p *Pool) Put(ins *Instance) error {
+	if err := p.giveBack(ins); err != nil {
+		return err
+	}
+
+	if p.Recycle {
+		p.instances <- ins
+		return nil
+	}
+
+	p.instances <- nil
+	return nil
+}
+
+// Discard drops the Instance taken from the pool,
+// a fresh Instance will be instantiated in place of it on the next Get.
+// ErrNotInPool is returned for an Instance not taken by Get or already given back.
+func (p *Pool) Discard(ins *Instance) error {
+	if err := p.giveBack(ins); err != nil {
+		return err
+	}
+
+	p.instances <- nil
+	return nil
+}
+
+// giveBack forgets the taken Instance, so that the channel always has room for its slot
+func (p *Pool) giveBack(ins *Instance) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.taken[ins]; !ok {
+		return ErrNotInPool
+	}
+
+	delete(p.taken, ins)
+	return nil
+}
+
+// CallExportedFunc calls the func `name` with the args on an idle Instance,
+// the Instance is discarded if the call fails
+func (p *Pool) CallExportedFunc(ctx context.Context, name string, args ...uint64) ([]uint64, []types.ValueType, error) {
+	ins, err := p.Get(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ret, retTypes, err := ins.CallExportedFuncContext(ctx, name, args...)
+	if err != nil {
+		_ = p.Discard(ins)
+		return nil, nil, err
+	}
+
+	_ = p.Put(ins)
+
+	return ret, retTypes, nil
+}
@@ -0,0 +1,171 @@
+package wasman_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/hybridgroup/wasman"
+	"github.com/hybridgroup/wasman/config"
+	"github.com/hybridgroup/wasman/expr"
+	"github.com/hybridgroup/wasman/segments"
+	"github.com/hybridgroup/wasman/types"
+	"github.com/hybridgroup/wasman/wasm"
+)
+
+func TestPool(t *testing.T) {
+	i32 := types.ValueTypeI32
+	mod := &wasman.Module{
+		TypeSection:     []*types.FuncType{{ReturnTypes: []types.ValueType{i32}}, {}},
+		FunctionSection: []uint32{0, 1},
+		GlobalSection: []*segments.GlobalSegment{
+			{Type: &types.GlobalType{ValType: i32, Mutable: true}, Init: &expr.Expression{OpCode: expr.OpCodeI32Const, Data: []byte{0x00}}},
+		},
+		CodeSection: []*segments.CodeSegment{
+			{Body: []byte{
+				expr.OpCodeGlobalGet, 0x00,
+				expr.OpCodeI32Const, 0x01,
+				expr.OpCodeI32Add,
+				expr.OpCodeGlobalSet, 0x00,
+				expr.OpCodeGlobalGet, 0x00,
+				expr.OpCodeEnd,
+			}},
+			{Body: []byte{expr.OpCodeUnreachable, expr.OpCodeEnd}},
+		},
+		ExportSection: map[string]*segments.ExportSegment{
+			"inc":  {Name: "inc", Desc: &segments.ExportDesc{Kind: segments.KindFunction, Index: 0}},
+			"trap": {Name: "trap", Desc: &segments.ExportDesc{Kind: segments.KindFunction, Index: 1}},
+		},
+	}
+
+	if _, err := wasman.NewPool(wasman.NewLinker(config.LinkerConfig{}), mod, 0); err != wasman.ErrInvalidPoolSize {
+		t.Fail()
+	}
+
+	pool, err := wasman.NewPool(wasman.NewLinker(config.LinkerConfig{}), mod, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.Recycle = true
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := pool.CallExportedFunc(ctx, "inc"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// the counters of the two recycled instances are kept
+	ins1, _ := pool.Get(ctx)
+	ins2, _ := pool.Get(ctx)
+	if ins1.Globals[0]+ins2.Globals[0] != 50 {
+		t.Logf("counters: %d, %d", ins1.Globals[0], ins2.Globals[0])
+		t.Fail()
+	}
+
+	// no instance is idle
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := pool.Get(canceled); err != context.Canceled {
+		t.Fail()
+	}
+
+	if err := pool.Put(ins1); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Discard(ins2); err != nil {
+		t.Fatal(err)
+	}
+
+	// the instances given back twice or not taken from the pool are rejected
+	if err := pool.Put(ins1); err != wasman.ErrNotInPool {
+		t.Logf("put twice: %v", err)
+		t.Fail()
+	}
+	if err := pool.Discard(ins2); err != wasman.ErrNotInPool {
+		t.Logf("discard twice: %v", err)
+		t.Fail()
+	}
+	foreign, err := wasman.NewLinker(config.LinkerConfig{}).Instantiate(mod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Put(foreign); err != wasman.ErrNotInPool {
+		t.Logf("put foreign: %v", err)
+		t.Fail()
+	}
+
+	// the trapped instance is replaced
+	if _, _, err := pool.CallExportedFunc(ctx, "trap"); !errors.Is(err, wasm.ErrUnreachable) {
+		t.Fail()
+	}
+	for i := 0; i < 2; i++ {
+		ret, _, err := pool.CallExportedFunc(ctx, "inc")
+		if err != nil || ret[0] != 1 {
+			t.Logf("inc: %v, %v", ret, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestPool_isolation(t *testing.T) {
+	i32 := types.ValueTypeI32
+
+	// store writes the arg at 0 of the memory, and returns the value found there
+	mod := &wasman.Module{
+		TypeSection:     []*types.FuncType{{InputTypes: []types.ValueType{i32}, ReturnTypes: []types.ValueType{i32}}},
+		FunctionSection: []uint32{0},
+		MemorySection:   []*types.MemoryType{{Min: 1}},
+		CodeSection: []*segments.CodeSegment{
+			{Body: []byte{
+				expr.OpCodeI32Const, 0x00, expr.OpCodeI32Load, 0x02, 0x00,
+				expr.OpCodeI32Const, 0x00, expr.OpCodeLocalGet, 0x00, expr.OpCodeI32Store, 0x02, 0x00,
+				expr.OpCodeEnd,
+			}},
+		},
+		ExportSection: map[string]*segments.ExportSegment{
+			"store": {Name: "store", Desc: &segments.ExportDesc{Kind: segments.KindFunction, Index: 0}},
+		},
+	}
+
+	pool, err := wasman.NewPool(wasman.NewLinker(config.LinkerConfig{}), mod, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for i := uint64(1); i <= 3; i++ {
+		ret, _, err := pool.CallExportedFunc(ctx, "store", i)
+		if err != nil || ret[0] != 0 {
+			t.Logf("store %d: %v, %v", i, ret, err)
+			t.Fail()
+		}
+	}
+
+	ins, err := pool.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ins.CallExportedFunc("store", 7); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Put(ins); err != nil {
+		t.Fatal(err)
+	}
+
+	ins, err = pool.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ins.Memory.Value[0] != 0 {
+		t.Logf("the write of the former call is seen: %d", ins.Memory.Value[0])
+		t.Fail()
+	}
+}
@@ -6,13 +6,19 @@ import (
 
 	"github.com/hybridgroup/wasman/expr"
 	"github.com/hybridgroup/wasman/leb128decode"
+	"github.com/hybridgroup/wasman/types"
 	"github.com/hybridgroup/wasman/utils"
 )
 
+// maxNumLocals is the limit of the locals declared by a func,
+// which keeps the LocalTypes of a malformed module from exhausting the memory
+const maxNumLocals = 50000
+
 // CodeSegment is one unit in the wasman.Module's CodeSection
 type CodeSegment struct {
-	NumLocals uint32
-	Body      []byte
+	NumLocals  uint32
+	LocalTypes []types.ValueType // the types of the NumLocals locals, excluding the params
+	Body       []byte
 }
 
 // ReadCodeSegment reads one CodeSegment from the io.Reader
@@ -33,6 +39,7 @@ func ReadCodeSegment(r utils.Reader) (*CodeSegment, error) {
 	}
 
 	var numLocals uint32
+	var localTypes []types.ValueType
 	var n uint32
 	var b [1]byte
 	for i := uint32(0); i < ls; i++ {
@@ -43,10 +50,17 @@ func ReadCodeSegment(r utils.Reader) (*CodeSegment, error) {
 		} else if remaining < 0 {
 			return nil, io.EOF
 		}
+		if uint64(numLocals)+uint64(n) > maxNumLocals {
+			return nil, fmt.Errorf("too many locals: more than %d", maxNumLocals)
+		}
 		numLocals += n
 
 		if _, err := r.Read(b[:]); err != nil {
-			return nil, fmt.Errorf("read type of local") // TODO: save read localType
+			return nil, fmt.Errorf("read type of local")
+		}
+
+		for j := uint32(0); j < n; j++ {
+			localTypes = append(localTypes, types.ValueType(b[0]))
 		}
 	}
 
@@ -62,7 +76,8 @@ func ReadCodeSegment(r utils.Reader) (*CodeSegment, error) {
 	}
 
 	return &CodeSegment{
-		Body:      body[:len(body)-1],
-		NumLocals: numLocals,
+		Body:       body[:len(body)-1],
+		NumLocals:  numLocals,
+		LocalTypes: localTypes,
 	}, nil
 }
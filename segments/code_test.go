@@ -6,13 +6,15 @@ import (
 	"testing"
 
 	"github.com/hybridgroup/wasman/segments"
+	"github.com/hybridgroup/wasman/types"
 )
 
 func TestReadCodeSegment(t *testing.T) {
 	buf := []byte{0x9, 0x1, 0x1, 0x1, 0x1, 0x1, 0x12, 0x3, 0x01, 0x0b}
 	exp := &segments.CodeSegment{
-		NumLocals: 0x01,
-		Body:      []byte{0x1, 0x1, 0x12, 0x3, 0x01},
+		NumLocals:  0x01,
+		LocalTypes: []types.ValueType{0x01},
+		Body:       []byte{0x1, 0x1, 0x12, 0x3, 0x01},
 	}
 	actual, err := segments.ReadCodeSegment(bytes.NewReader(buf))
 	if err != nil {
@@ -22,3 +24,14 @@ func TestReadCodeSegment(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestReadCodeSegment_tooManyLocals(t *testing.T) {
+	for _, buf := range [][]byte{
+		{0x8, 0x1, 0xff, 0xff, 0xff, 0xff, 0x0f, 0x7f, 0x0b},
+		{0x8, 0x2, 0xd0, 0x86, 0x03, 0x7f, 0x01, 0x7e, 0x0b}, // 50000 + 1
+	} {
+		if _, err := segments.ReadCodeSegment(bytes.NewReader(buf)); err == nil {
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package segments
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/hybridgroup/wasman/leb128decode"
+	"github.com/hybridgroup/wasman/types"
+	"github.com/hybridgroup/wasman/utils"
+)
+
+// ids of the subsections in the name section
+const (
+	nameSubsectionModule   = 0x00
+	nameSubsectionFunction = 0x01
+	nameSubsectionLocal    = 0x02
+)
+
+// NameSection is the debug names decoded from the "name" custom section,
+// https://www.w3.org/TR/wasm-core-2/#name-section%E2%91%A0
+type NameSection struct {
+	ModuleName    string
+	FunctionNames map[uint32]string            // keyed by the func index
+	LocalNames    map[uint32]map[uint32]string // keyed by the func index and then the local index
+}
+
+// ReadNameSection reads the NameSection from the io.Reader, which should hold the contents after the section name
+func ReadNameSection(r utils.Reader) (*NameSection, error) {
+	ns := &NameSection{
+		FunctionNames: map[uint32]string{},
+		LocalNames:    map[uint32]map[uint32]string{},
+	}
+
+	b := make([]byte, 1)
+	for {
+		if _, err := io.ReadFull(r, b); errors.Is(err, io.EOF) {
+			return ns, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("read subsection id: %w", err)
+		}
+
+		size, _, err := leb128decode.DecodeUint32(r)
+		if err != nil {
+			return nil, fmt.Errorf("read size of subsection: %w", err)
+		}
+
+		// the subsection is read within its size, which can not be trusted to allocate
+		data, err := io.ReadAll(io.LimitReader(r, int64(size)))
+		if err != nil {
+			return nil, fmt.Errorf("read subsection for %d: %w", b[0], err)
+		} else if len(data) != int(size) {
+			return nil, fmt.Errorf("read subsection for %d: %w", b[0], io.ErrUnexpectedEOF)
+		}
+
+		sr := bytes.NewReader(data)
+		switch b[0] {
+		case nameSubsectionModule:
+			ns.ModuleName, err = types.ReadNameValue(sr)
+		case nameSubsectionFunction:
+			ns.FunctionNames, err = readNameMap(sr)
+		case nameSubsectionLocal:
+			ns.LocalNames, err = readIndirectNameMap(sr)
+		default:
+			// unknown subsections are skipped
+			continue
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("read subsection for %d: %w", b[0], err)
+		} else if sr.Len() != 0 {
+			return nil, fmt.Errorf("read subsection for %d: %d bytes remain", b[0], sr.Len())
+		}
+	}
+}
+
+func readNameMap(r utils.Reader) (map[uint32]string, error) {
+	vs, _, err := leb128decode.DecodeUint32(r)
+	if err != nil {
+		return nil, fmt.Errorf("get size of vector: %w", err)
+	}
+
+	ret := map[uint32]string{} // not presized by the untrusted vs
+	for i := uint32(0); i < vs; i++ {
+		idx, _, err := leb128decode.DecodeUint32(r)
+		if err != nil {
+			return nil, fmt.Errorf("read index: %w", err)
+		}
+
+		ret[idx], err = types.ReadNameValue(r)
+		if err != nil {
+			return nil, fmt.Errorf("read name of %d: %w", idx, err)
+		}
+	}
+
+	return ret, nil
+}
+
+func readIndirectNameMap(r utils.Reader) (map[uint32]map[uint32]string, error) {
+	vs, _, err := leb128decode.DecodeUint32(r)
+	if err != nil {
+		return nil, fmt.Errorf("get size of vector: %w", err)
+	}
+
+	ret := map[uint32]map[uint32]string{} // not presized by the untrusted vs
+	for i := uint32(0); i < vs; i++ {
+		idx, _, err := leb128decode.DecodeUint32(r)
+		if err != nil {
+			return nil, fmt.Errorf("read index: %w", err)
+		}
+
+		ret[idx], err = readNameMap(r)
+		if err != nil {
+			return nil, fmt.Errorf("read name map of %d: %w", idx, err)
+		}
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,46 @@
+package segments_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/hybridgroup/wasman/segments"
+)
+
+func TestReadNameSection(t *testing.T) {
+	buf := []byte{
+		0x00, 0x04, 0x03, 'm', 'o', 'd', // module name
+		0x01, 0x07, 0x02, 0x00, 0x01, 'a', 0x02, 0x01, 'b', // function names
+		0x07, 0x01, 0xff, // unknown subsection
+		0x02, 0x06, 0x01, 0x02, 0x01, 0x00, 0x01, 'x', // local names
+	}
+
+	actual, err := segments.ReadNameSection(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := &segments.NameSection{
+		ModuleName:    "mod",
+		FunctionNames: map[uint32]string{0: "a", 2: "b"},
+		LocalNames:    map[uint32]map[uint32]string{2: {0: "x"}},
+	}
+	if !reflect.DeepEqual(exp, actual) {
+		t.Logf("actual: %+v", actual)
+		t.Fail()
+	}
+
+	for i, b := range [][]byte{
+		{0x01, 0x03, 0x01, 0x00},
+		{0x01, 0x06, 0xff, 0xff, 0xff, 0xff, 0x0f, 0x00}, // too many names for the subsection
+		{0x00, 0x01, 0x03, 'm', 'o', 'd'},                // the module name overruns the subsection
+		{0x00, 0x05, 0x03, 'm', 'o', 'd', 0x00},          // a byte remains in the subsection
+		{0x07, 0x05, 0xff},                               // the unknown subsection is truncated
+	} {
+		if _, err := segments.ReadNameSection(bytes.NewReader(b)); err == nil {
+			t.Logf("no error on %d", i)
+			t.Fail()
+		}
+	}
+}
@@ -13,8 +13,18 @@ import (
 type Limits struct {
 	Min uint32
 	Max *uint32 // can be nil
+
+	// PageSizeLog2 is the log2 of the page size of the memory in bytes, see ReadMemoryType.
+	// It is nil for the tables and the memories using the default page size.
+	PageSizeLog2 *uint32
 }
 
+// the flags of the leading byte of the limits
+const (
+	limitsFlagHasMax      = 0x01
+	limitsFlagHasPageSize = 0x08 // only for the memory types
+)
+
 // ReadLimits will read a types.Limits from the io.Reader
 func ReadLimits(r utils.Reader) (*Limits, error) {
 	b := make([]byte, 1)
@@ -23,25 +33,28 @@ func ReadLimits(r utils.Reader) (*Limits, error) {
 		return nil, fmt.Errorf("read leading byte: %w", err)
 	}
 
+	if b[0]&^limitsFlagHasMax != 0 {
+		return nil, fmt.Errorf("%w for limits: %#x != 0x00 or 0x01", ErrInvalidTypeByte, b[0])
+	}
+
+	return readLimits(r, b[0])
+}
+
+func readLimits(r utils.Reader, flag byte) (*Limits, error) {
+	var err error
 	ret := &Limits{}
-	switch b[0] {
-	case 0x00:
-		ret.Min, _, err = leb128decode.DecodeUint32(r)
-		if err != nil {
-			return nil, fmt.Errorf("read min of limit: %w", err)
-		}
-	case 0x01:
-		ret.Min, _, err = leb128decode.DecodeUint32(r)
-		if err != nil {
-			return nil, fmt.Errorf("read min of limit: %w", err)
-		}
+	ret.Min, _, err = leb128decode.DecodeUint32(r)
+	if err != nil {
+		return nil, fmt.Errorf("read min of limit: %w", err)
+	}
+
+	if flag&limitsFlagHasMax != 0 {
 		m, _, err := leb128decode.DecodeUint32(r)
 		if err != nil {
-			return nil, fmt.Errorf("read min of limit: %w", err)
+			return nil, fmt.Errorf("read max of limit: %w", err)
 		}
 		ret.Max = &m
-	default:
-		return nil, fmt.Errorf("%w for limits: %#x != 0x00 or 0x01", ErrInvalidTypeByte, b[0])
 	}
+
 	return ret, nil
 }
@@ -1,12 +1,51 @@
 package types
 
-import "github.com/hybridgroup/wasman/utils"
+import (
+	"fmt"
+	"io"
+
+	"github.com/hybridgroup/wasman/leb128decode"
+	"github.com/hybridgroup/wasman/utils"
+)
 
 // MemoryType classify linear memories and their size range.
 // https://www.w3.org/TR/wasm-core-1/#memory-types%E2%91%A0
 type MemoryType = Limits
 
-// ReadMemoryType will read a types.MemoryType from the io.Reader
+// MaxMemoryPageSizeLog2 is the log2 of the largest page size of the memories, which is the default 64KiB
+const MaxMemoryPageSizeLog2 = 16
+
+// ReadMemoryType will read a types.MemoryType from the io.Reader,
+// the custom page size of the custom-page-sizes proposal is read into the PageSizeLog2,
+// which allows only the 1 byte and the default 64KiB pages.
+// https://github.com/WebAssembly/custom-page-sizes
 func ReadMemoryType(r utils.Reader) (*MemoryType, error) {
-	return ReadLimits(r)
+	b := make([]byte, 1)
+	_, err := io.ReadFull(r, b)
+	if err != nil {
+		return nil, fmt.Errorf("read leading byte: %w", err)
+	}
+
+	if b[0]&^(limitsFlagHasMax|limitsFlagHasPageSize) != 0 {
+		return nil, fmt.Errorf("%w for memory type: %#x", ErrInvalidTypeByte, b[0])
+	}
+
+	mt, err := readLimits(r, b[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if b[0]&limitsFlagHasPageSize != 0 {
+		log2, _, err := leb128decode.DecodeUint32(r)
+		if err != nil {
+			return nil, fmt.Errorf("read page size of memory type: %w", err)
+		}
+
+		if log2 != 0 && log2 != MaxMemoryPageSizeLog2 {
+			return nil, fmt.Errorf("%w: page size 2^%d is not allowed", ErrInvalidTypeByte, log2)
+		}
+		mt.PageSizeLog2 = &log2
+	}
+
+	return mt, nil
 }
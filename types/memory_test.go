@@ -17,6 +17,8 @@ func TestReadMemoryType(t *testing.T) {
 	}{
 		{bytes: []byte{0x00, 0xa}, exp: &types.MemoryType{Min: 10}},
 		{bytes: []byte{0x01, 0xa, 0xa}, exp: &types.MemoryType{Min: 10, Max: utils.Uint32Ptr(10)}},
+		{bytes: []byte{0x08, 0xa, 0x0}, exp: &types.MemoryType{Min: 10, PageSizeLog2: utils.Uint32Ptr(0)}},
+		{bytes: []byte{0x09, 0xa, 0xa, 0x10}, exp: &types.MemoryType{Min: 10, Max: utils.Uint32Ptr(10), PageSizeLog2: utils.Uint32Ptr(16)}},
 	} {
 		t.Run(utils.IntToString(i), func(t *testing.T) {
 			actual, err := types.ReadMemoryType(bytes.NewReader(c.bytes))
@@ -29,3 +31,18 @@ func TestReadMemoryType(t *testing.T) {
 		})
 	}
 }
+
+func TestReadMemoryType_error(t *testing.T) {
+	for i, b := range [][]byte{
+		{0x02, 0xa},       // unknown flag
+		{0x08, 0xa, 0x11}, // page size larger than 64KiB
+		{0x08, 0xa, 0x0e}, // page size other than 1 byte or 64KiB
+		{0x08, 0xa},       // missing page size
+	} {
+		t.Run(utils.IntToString(i), func(t *testing.T) {
+			if _, err := types.ReadMemoryType(bytes.NewReader(b)); err == nil {
+				t.Fail()
+			}
+		})
+	}
+}
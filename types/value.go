@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 
 	"github.com/hybridgroup/wasman/leb128decode"
 	"github.com/hybridgroup/wasman/utils"
@@ -26,6 +27,8 @@ const (
 	ValueTypeF32 ValueType = 0x7d
 	// ValueTypeF64 classify 64 bit floating-point data, known as double
 	ValueTypeF64 ValueType = 0x7c
+	// ValueTypeFuncref is a funcref type.
+	ValueTypeFuncref ValueType = 0x70
 	// ValueTypeExternref is a externref type.
 	ValueTypeExternref ValueType = 0x6f
 )
@@ -41,6 +44,8 @@ func (v ValueType) String() string {
 		return "f32"
 	case ValueTypeF64:
 		return "f64"
+	case ValueTypeFuncref:
+		return "funcref"
 	case ValueTypeExternref:
 		return "externref"
 	default:
@@ -48,6 +53,85 @@ func (v ValueType) String() string {
 	}
 }
 
+// Value is a wasm value with its type,
+// the Raw is the bits of the value on the operand stack
+type Value struct {
+	Type ValueType
+	Raw  uint64
+}
+
+// I32 creates an i32 Value
+func I32(v int32) Value {
+	return Value{Type: ValueTypeI32, Raw: uint64(uint32(v))}
+}
+
+// I64 creates an i64 Value
+func I64(v int64) Value {
+	return Value{Type: ValueTypeI64, Raw: uint64(v)}
+}
+
+// F32 creates an f32 Value
+func F32(v float32) Value {
+	return Value{Type: ValueTypeF32, Raw: uint64(math.Float32bits(v))}
+}
+
+// F64 creates an f64 Value
+func F64(v float64) Value {
+	return Value{Type: ValueTypeF64, Raw: math.Float64bits(v)}
+}
+
+// ExternRef creates an externref Value
+func ExternRef(ref uint64) Value {
+	return Value{Type: ValueTypeExternref, Raw: ref}
+}
+
+// I32 returns the value as an i32
+func (v Value) I32() int32 {
+	return int32(uint32(v.Raw))
+}
+
+// I64 returns the value as an i64
+func (v Value) I64() int64 {
+	return int64(v.Raw)
+}
+
+// F32 returns the value as an f32
+func (v Value) F32() float32 {
+	return math.Float32frombits(uint32(v.Raw))
+}
+
+// F64 returns the value as an f64
+func (v Value) F64() float64 {
+	return math.Float64frombits(v.Raw)
+}
+
+// ExternRef returns the value as an externref
+func (v Value) ExternRef() uint64 {
+	return v.Raw
+}
+
+// Interface returns the value as the go value of its type,
+// which is one of int32, int64, float32, float64 and uint64 (for externref)
+func (v Value) Interface() interface{} {
+	switch v.Type {
+	case ValueTypeI32:
+		return v.I32()
+	case ValueTypeI64:
+		return v.I64()
+	case ValueTypeF32:
+		return v.F32()
+	case ValueTypeF64:
+		return v.F64()
+	default:
+		return v.Raw
+	}
+}
+
+// String will convert the types.Value into a string like "i32(-1)"
+func (v Value) String() string {
+	return fmt.Sprintf("%s(%v)", v.Type, v.Interface())
+}
+
 // ReadValueTypes will read a types.ValueType from the io.Reader
 func ReadValueTypes(r io.Reader, num uint32) ([]ValueType, error) {
 	ret := make([]ValueType, num)
@@ -59,7 +143,7 @@ func ReadValueTypes(r io.Reader, num uint32) ([]ValueType, error) {
 
 	for i, v := range buf {
 		switch vt := ValueType(v); vt {
-		case ValueTypeI32, ValueTypeF32, ValueTypeI64, ValueTypeF64, ValueTypeExternref:
+		case ValueTypeI32, ValueTypeF32, ValueTypeI64, ValueTypeF64, ValueTypeFuncref, ValueTypeExternref:
 			ret[i] = vt
 		default:
 			return nil, fmt.Errorf("invalid value type: %d", vt)
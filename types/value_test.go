@@ -70,3 +70,29 @@ func TestHasSameValues(t *testing.T) {
 		}
 	}
 }
+
+func TestValue(t *testing.T) {
+	for i, c := range []struct {
+		val types.Value
+		raw uint64
+		exp interface{}
+		str string
+	}{
+		{val: types.I32(-1), raw: 0xffffffff, exp: int32(-1), str: "i32(-1)"},
+		{val: types.I64(-1), raw: 0xffffffffffffffff, exp: int64(-1), str: "i64(-1)"},
+		{val: types.F32(1.5), raw: 0x3fc00000, exp: float32(1.5), str: "f32(1.5)"},
+		{val: types.F64(-2), raw: 0xc000000000000000, exp: float64(-2), str: "f64(-2)"},
+		{val: types.ExternRef(7), raw: 7, exp: uint64(7), str: "externref(7)"},
+	} {
+		t.Run(utils.IntToString(i), func(t *testing.T) {
+			if c.val.Raw != c.raw || c.val.Interface() != c.exp || c.val.String() != c.str {
+				t.Logf("%#x, %v, %s", c.val.Raw, c.val.Interface(), c.val)
+				t.Fail()
+			}
+		})
+	}
+
+	if types.I32(-5).I32() != -5 || types.I64(-5).I64() != -5 || types.F32(0.25).F32() != 0.25 || types.F64(0.125).F64() != 0.125 {
+		t.Fail()
+	}
+}
@@ -0,0 +1,28 @@
+package wasman
+
+import (
+	"sort"
+
+	"github.com/hybridgroup/wasman/config"
+	"github.com/hybridgroup/wasman/wasi"
+)
+
+// DefineWASI puts the wasi_snapshot_preview1 functions into Linker's modules,
+// each Instance importing them gets its own fds, while the Stdin, Stdout, Stderr and Preopens are shared
+func (l *Linker) DefineWASI(conf config.WASIConfig) error {
+	funcs := wasi.HostFuncs(conf)
+
+	names := make([]string, 0, len(funcs))
+	for name := range funcs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := l.DefineHostFunc(wasi.ModuleName, name, funcs[name]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,179 @@
+package wasi
+
+import (
+	"encoding/binary"
+	"runtime"
+	"time"
+)
+
+// available clock ids
+const (
+	clockRealtime         = 0
+	clockMonotonic        = 1
+	clockProcessCPUTimeID = 2
+	clockThreadCPUTimeID  = 3
+)
+
+// available event types of the subscriptions in poll_oneoff
+const (
+	eventTypeClock   = 0
+	eventTypeFdRead  = 1
+	eventTypeFdWrite = 2
+)
+
+const (
+	subscriptionSize = 48
+	eventSize        = 32
+
+	subclockFlagAbstime = 1
+)
+
+func clockResGet(_ *System, mem []byte, args []uint64) Errno {
+	switch args[0] {
+	case clockRealtime, clockMonotonic:
+	case clockProcessCPUTimeID, clockThreadCPUTimeID:
+		return ErrnoNotsup
+	default:
+		return ErrnoInval
+	}
+
+	if !writeUint64(mem, uint32(args[1]), uint64(time.Nanosecond)) {
+		return ErrnoFault
+	}
+
+	return ErrnoSuccess
+}
+
+// clockTime returns the time in nanoseconds of the clock id
+func (s *System) clockTime(id uint64) (uint64, Errno) {
+	switch id {
+	case clockRealtime:
+		return uint64(s.Now().UnixNano()), ErrnoSuccess
+	case clockMonotonic:
+		return uint64(s.Now().Sub(s.start)), ErrnoSuccess
+	case clockProcessCPUTimeID, clockThreadCPUTimeID:
+		return 0, ErrnoNotsup
+	default:
+		return 0, ErrnoInval
+	}
+}
+
+func clockTimeGet(s *System, mem []byte, args []uint64) Errno {
+	t, errno := s.clockTime(args[0])
+	if errno != ErrnoSuccess {
+		return errno
+	}
+
+	if !writeUint64(mem, uint32(args[2]), t) {
+		return ErrnoFault
+	}
+
+	return ErrnoSuccess
+}
+
+func schedYield(_ *System, _ []byte, _ []uint64) Errno {
+	runtime.Gosched()
+	return ErrnoSuccess
+}
+
+// pollOneoff only waits on the clock subscriptions,
+// the fd subscriptions are always reported to be ready
+func pollOneoff(s *System, mem []byte, args []uint64) Errno {
+	in, out, n, neventsPtr := uint32(args[0]), uint32(args[1]), uint32(args[2]), uint32(args[3])
+	if n == 0 {
+		return ErrnoInval
+	}
+
+	if uint64(n)*subscriptionSize > uint64(len(mem)) || uint64(n)*eventSize > uint64(len(mem)) {
+		return ErrnoFault
+	}
+
+	subs, ok := memRange(mem, in, n*subscriptionSize)
+	if !ok {
+		return ErrnoFault
+	}
+
+	events, ok := memRange(mem, out, n*eventSize)
+	if !ok {
+		return ErrnoFault
+	}
+
+	// find out the nearest timeout
+	var timeout time.Duration
+	hasFd, hasClock := false, false
+	for i := uint32(0); i < n; i++ {
+		sub := subs[i*subscriptionSize:]
+		switch sub[8] {
+		case eventTypeClock:
+			d, errno := s.subscriptionTimeout(sub)
+			if errno != ErrnoSuccess {
+				return errno
+			}
+			if !hasClock || d < timeout {
+				timeout = d
+			}
+			hasClock = true
+		case eventTypeFdRead, eventTypeFdWrite:
+			hasFd = true
+		default:
+			return ErrnoInval
+		}
+	}
+
+	if hasClock && !hasFd && timeout > 0 {
+		time.Sleep(timeout)
+	}
+
+	nevents := uint32(0)
+	for i := uint32(0); i < n; i++ {
+		sub := subs[i*subscriptionSize:]
+		if sub[8] == eventTypeClock {
+			// report the clocks which have been expired only
+			d, _ := s.subscriptionTimeout(sub)
+			if (hasFd && d > 0) || d > timeout {
+				continue
+			}
+		}
+
+		event := events[nevents*eventSize : (nevents+1)*eventSize]
+		for j := range event {
+			event[j] = 0
+		}
+		copy(event[0:8], sub[0:8]) // userdata
+		event[10] = sub[8]         // type
+		if sub[8] != eventTypeClock {
+			fd := binary.LittleEndian.Uint32(sub[16:])
+			if _, errno := s.fd(uint64(fd), rightPollFdReadwrite); errno != ErrnoSuccess {
+				binary.LittleEndian.PutUint16(event[8:], uint16(errno))
+			}
+		}
+		nevents++
+	}
+
+	if !writeUint32(mem, neventsPtr, nevents) {
+		return ErrnoFault
+	}
+
+	return ErrnoSuccess
+}
+
+// subscriptionTimeout returns the duration to wait for the clock subscription
+func (s *System) subscriptionTimeout(sub []byte) (time.Duration, Errno) {
+	id := binary.LittleEndian.Uint32(sub[16:])
+	timeout := binary.LittleEndian.Uint64(sub[24:])
+	flags := binary.LittleEndian.Uint16(sub[40:])
+	if flags&subclockFlagAbstime == 0 {
+		return time.Duration(timeout), ErrnoSuccess
+	}
+
+	now, errno := s.clockTime(uint64(id))
+	if errno != ErrnoSuccess {
+		return 0, errno
+	}
+
+	if timeout <= now {
+		return 0, ErrnoSuccess
+	}
+
+	return time.Duration(timeout - now), ErrnoSuccess
+}
@@ -0,0 +1,609 @@
+package wasi
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+)
+
+// available file types
+const (
+	fileTypeUnknown         = 0
+	fileTypeCharacterDevice = 2
+	fileTypeDirectory       = 3
+	fileTypeRegularFile     = 4
+	fileTypeSymbolicLink    = 7
+)
+
+// available rights of the file descriptors
+const (
+	rightFdDatasync          = 1 << 0
+	rightFdRead              = 1 << 1
+	rightFdSeek              = 1 << 2
+	rightFdSync              = 1 << 4
+	rightFdTell              = 1 << 5
+	rightFdWrite             = 1 << 6
+	rightFdAdvise            = 1 << 7
+	rightFdAllocate          = 1 << 8
+	rightPathCreateDirectory = 1 << 9
+	rightPathCreateFile      = 1 << 10
+	rightPathOpen            = 1 << 13
+	rightFdReaddir           = 1 << 14
+	rightPathRenameSource    = 1 << 16
+	rightPathRenameTarget    = 1 << 17
+	rightPathFilestatGet     = 1 << 18
+	rightFdFilestatGet       = 1 << 21
+	rightFdFilestatSetSize   = 1 << 22
+	rightPathRemoveDirectory = 1 << 25
+	rightPathUnlinkFile      = 1 << 26
+	rightPollFdReadwrite     = 1 << 27
+	rightsAll                = 1<<29 - 1
+	rightsWrite              = rightFdWrite | rightFdAllocate | rightFdFilestatSetSize
+)
+
+// available fdflags
+const (
+	fdflagAppend = 1 << 0
+)
+
+const (
+	fdstatSize   = 24
+	filestatSize = 64
+	prestatSize  = 8
+	direntSize   = 24
+)
+
+// fileDesc is an opened file descriptor
+type fileDesc struct {
+	fileType         uint8
+	flags            uint16
+	rights           uint64
+	rightsInheriting uint64
+
+	reader io.Reader
+	writer io.Writer
+
+	// for the files and directories under a preopened directory
+	mount   int     // index of the preopened directory in System.Preopens
+	preopen bool    // whether it is the preopened directory itself
+	fsys    fs.FS   // FS of the preopened directory
+	path    string  // path of the file in fsys
+	file    fs.File // the opened file, nil for directories
+}
+
+// fd returns the opened fd which has all the rights
+func (s *System) fd(fd uint64, rights uint64) (*fileDesc, Errno) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.fds[uint32(fd)]
+	if !ok {
+		return nil, ErrnoBadf
+	}
+
+	if f.rights&rights != rights {
+		return nil, ErrnoNotcapable
+	}
+
+	return f, ErrnoSuccess
+}
+
+// openFd puts the f into the fd table with the lowest available fd
+func (s *System) openFd(f *fileDesc) uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fd := uint32(3)
+	for s.fds[fd] != nil {
+		fd++
+	}
+	s.fds[fd] = f
+
+	return fd
+}
+
+// errnoOf converts the error returned by the fs.FS into an Errno
+func errnoOf(err error) Errno {
+	switch {
+	case err == nil:
+		return ErrnoSuccess
+	case errors.Is(err, fs.ErrNotExist):
+		return ErrnoNoent
+	case errors.Is(err, fs.ErrExist):
+		return ErrnoExist
+	case errors.Is(err, fs.ErrPermission):
+		return ErrnoAcces
+	case errors.Is(err, fs.ErrInvalid):
+		return ErrnoInval
+	case errors.Is(err, fs.ErrClosed):
+		return ErrnoBadf
+	default:
+		return ErrnoIo
+	}
+}
+
+func fileTypeOf(mode fs.FileMode) uint8 {
+	switch {
+	case mode.IsDir():
+		return fileTypeDirectory
+	case mode.IsRegular():
+		return fileTypeRegularFile
+	case mode&fs.ModeSymlink != 0:
+		return fileTypeSymbolicLink
+	case mode&fs.ModeCharDevice != 0:
+		return fileTypeCharacterDevice
+	default:
+		return fileTypeUnknown
+	}
+}
+
+// writeFilestat writes the filestat of the info into ptr, info can be nil for the stdio
+func writeFilestat(mem []byte, ptr uint32, fileType uint8, info fs.FileInfo) Errno {
+	buf, ok := memRange(mem, ptr, filestatSize)
+	if !ok {
+		return ErrnoFault
+	}
+
+	for i := range buf {
+		buf[i] = 0
+	}
+	buf[16] = fileType
+	binary.LittleEndian.PutUint64(buf[24:], 1) // nlink
+	if info != nil {
+		mtim := uint64(info.ModTime().UnixNano())
+		binary.LittleEndian.PutUint64(buf[32:], uint64(info.Size()))
+		binary.LittleEndian.PutUint64(buf[40:], mtim)
+		binary.LittleEndian.PutUint64(buf[48:], mtim)
+		binary.LittleEndian.PutUint64(buf[56:], mtim)
+	}
+
+	return ErrnoSuccess
+}
+
+func fdAdvise(s *System, _ []byte, args []uint64) Errno {
+	_, errno := s.fd(args[0], rightFdAdvise)
+	return errno
+}
+
+func fdClose(s *System, _ []byte, args []uint64) Errno {
+	f, errno := s.fd(args[0], 0)
+	if errno != ErrnoSuccess {
+		return errno
+	}
+
+	s.mu.Lock()
+	delete(s.fds, uint32(args[0]))
+	s.mu.Unlock()
+
+	if f.file != nil {
+		return errnoOf(f.file.Close())
+	}
+
+	return ErrnoSuccess
+}
+
+func fdDatasync(s *System, _ []byte, args []uint64) Errno {
+	return syncFd(s, args[0], rightFdDatasync)
+}
+
+func fdSync(s *System, _ []byte, args []uint64) Errno {
+	return syncFd(s, args[0], rightFdSync)
+}
+
+func syncFd(s *System, fd uint64, rights uint64) Errno {
+	f, errno := s.fd(fd, rights)
+	if errno != ErrnoSuccess {
+		return errno
+	}
+
+	if syncer, ok := f.file.(interface{ Sync() error }); ok {
+		return errnoOf(syncer.Sync())
+	}
+
+	return ErrnoSuccess
+}
+
+func fdFdstatGet(s *System, mem []byte, args []uint64) Errno {
+	f, errno := s.fd(args[0], 0)
+	if errno != ErrnoSuccess {
+		return errno
+	}
+
+	buf, ok := memRange(mem, uint32(args[1]), fdstatSize)
+	if !ok {
+		return ErrnoFault
+	}
+
+	for i := range buf {
+		buf[i] = 0
+	}
+	buf[0] = f.fileType
+	binary.LittleEndian.PutUint16(buf[2:], f.flags)
+	binary.LittleEndian.PutUint64(buf[8:], f.rights)
+	binary.LittleEndian.PutUint64(buf[16:], f.rightsInheriting)
+
+	return ErrnoSuccess
+}
+
+func fdFilestatGet(s *System, mem []byte, args []uint64) Errno {
+	f, errno := s.fd(args[0], rightFdFilestatGet)
+	if errno != ErrnoSuccess {
+		return errno
+	}
+
+	var info fs.FileInfo
+	var err error
+	switch {
+	case f.file != nil:
+		info, err = f.file.Stat()
+	case f.fsys != nil:
+		info, err = fs.Stat(f.fsys, f.path)
+	}
+	if err != nil {
+		return errnoOf(err)
+	}
+
+	return writeFilestat(mem, uint32(args[1]), f.fileType, info)
+}
+
+func fdFilestatSetSize(s *System, _ []byte, args []uint64) Errno {
+	f, errno := s.fd(args[0], rightFdFilestatSetSize)
+	if errno != ErrnoSuccess {
+		return errno
+	}
+
+	truncater, ok := f.file.(interface{ Truncate(int64) error })
+	if !ok || f.writer == nil {
+		return ErrnoBadf
+	}
+
+	return errnoOf(truncater.Truncate(int64(args[1])))
+}
+
+func fdPrestatGet(s *System, mem []byte, args []uint64) Errno {
+	f, errno := s.fd(args[0], 0)
+	if errno != ErrnoSuccess {
+		return errno
+	}
+
+	if !f.preopen {
+		return ErrnoBadf
+	}
+
+	buf, ok := memRange(mem, uint32(args[1]), prestatSize)
+	if !ok {
+		return ErrnoFault
+	}
+
+	for i := range buf {
+		buf[i] = 0
+	}
+	binary.LittleEndian.PutUint32(buf[4:], uint32(len(s.Preopens[f.mount].GuestPath)))
+
+	return ErrnoSuccess
+}
+
+func fdPrestatDirName(s *System, mem []byte, args []uint64) Errno {
+	f, errno := s.fd(args[0], 0)
+	if errno != ErrnoSuccess {
+		return errno
+	}
+
+	if !f.preopen {
+		return ErrnoBadf
+	}
+
+	name := s.Preopens[f.mount].GuestPath
+	if uint32(args[2]) < uint32(len(name)) {
+		return ErrnoNametoolong
+	}
+
+	buf, ok := memRange(mem, uint32(args[1]), uint32(len(name)))
+	if !ok {
+		return ErrnoFault
+	}
+	copy(buf, name)
+
+	return ErrnoSuccess
+}
+
+func fdRenumber(s *System, _ []byte, args []uint64) Errno {
+	from, errno := s.fd(args[0], 0)
+	if errno != ErrnoSuccess {
+		return errno
+	}
+
+	to, errno := s.fd(args[1], 0)
+	if errno != ErrnoSuccess {
+		return errno
+	}
+
+	if from == to {
+		return ErrnoSuccess
+	}
+
+	s.mu.Lock()
+	s.fds[uint32(args[1])] = from
+	delete(s.fds, uint32(args[0]))
+	s.mu.Unlock()
+
+	if to.file != nil {
+		_ = to.file.Close()
+	}
+
+	return ErrnoSuccess
+}
+
+func fdSeek(s *System, mem []byte, args []uint64) Errno {
+	f, errno := s.fd(args[0], rightFdSeek)
+	if errno != ErrnoSuccess {
+		return errno
+	}
+
+	seeker, ok := f.file.(io.Seeker)
+	if !ok {
+		return ErrnoSpipe
+	}
+
+	whence := int(args[2])
+	if whence != io.SeekStart && whence != io.SeekCurrent && whence != io.SeekEnd {
+		return ErrnoInval
+	}
+
+	pos, err := seeker.Seek(int64(args[1]), whence)
+	if err != nil {
+		return errnoOf(err)
+	}
+
+	if !writeUint64(mem, uint32(args[3]), uint64(pos)) {
+		return ErrnoFault
+	}
+
+	return ErrnoSuccess
+}
+
+func fdTell(s *System, mem []byte, args []uint64) Errno {
+	f, errno := s.fd(args[0], rightFdTell)
+	if errno != ErrnoSuccess {
+		return errno
+	}
+
+	seeker, ok := f.file.(io.Seeker)
+	if !ok {
+		return ErrnoSpipe
+	}
+
+	pos, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return errnoOf(err)
+	}
+
+	if !writeUint64(mem, uint32(args[1]), uint64(pos)) {
+		return ErrnoFault
+	}
+
+	return ErrnoSuccess
+}
+
+func fdRead(s *System, mem []byte, args []uint64) Errno {
+	f, errno := s.fd(args[0], rightFdRead)
+	if errno != ErrnoSuccess {
+		return errno
+	}
+
+	if f.fileType == fileTypeDirectory {
+		return ErrnoIsdir
+	}
+
+	iovs, ok := readIovecs(mem, uint32(args[1]), uint32(args[2]))
+	if !ok {
+		return ErrnoFault
+	}
+
+	nread := uint32(0)
+	if f.reader != nil {
+		for _, iov := range iovs {
+			buf, ok := memRange(mem, iov.buf, iov.bufLen)
+			if !ok {
+				return ErrnoFault
+			}
+
+			n, err := f.reader.Read(buf)
+			nread += uint32(n)
+			if errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				return errnoOf(err)
+			}
+
+			if n < len(buf) {
+				break
+			}
+		}
+	}
+
+	if !writeUint32(mem, uint32(args[3]), nread) {
+		return ErrnoFault
+	}
+
+	return ErrnoSuccess
+}
+
+func fdPread(s *System, mem []byte, args []uint64) Errno {
+	f, errno := s.fd(args[0], rightFdRead|rightFdSeek)
+	if errno != ErrnoSuccess {
+		return errno
+	}
+
+	if f.fileType == fileTypeDirectory {
+		return ErrnoIsdir
+	}
+
+	readerAt, ok := f.file.(io.ReaderAt)
+	if !ok {
+		return ErrnoSpipe
+	}
+
+	iovs, ok := readIovecs(mem, uint32(args[1]), uint32(args[2]))
+	if !ok {
+		return ErrnoFault
+	}
+
+	offset := int64(args[3])
+	nread := uint32(0)
+	for _, iov := range iovs {
+		buf, ok := memRange(mem, iov.buf, iov.bufLen)
+		if !ok {
+			return ErrnoFault
+		}
+
+		n, err := readerAt.ReadAt(buf, offset)
+		nread += uint32(n)
+		offset += int64(n)
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return errnoOf(err)
+		}
+	}
+
+	if !writeUint32(mem, uint32(args[4]), nread) {
+		return ErrnoFault
+	}
+
+	return ErrnoSuccess
+}
+
+func fdWrite(s *System, mem []byte, args []uint64) Errno {
+	f, errno := s.fd(args[0], rightFdWrite)
+	if errno != ErrnoSuccess {
+		return errno
+	}
+
+	if f.fileType == fileTypeDirectory {
+		return ErrnoIsdir
+	}
+
+	iovs, ok := readIovecs(mem, uint32(args[1]), uint32(args[2]))
+	if !ok {
+		return ErrnoFault
+	}
+
+	if f.file != nil && f.writer == nil {
+		return ErrnoBadf
+	}
+
+	nwritten := uint32(0)
+	for _, iov := range iovs {
+		buf, ok := memRange(mem, iov.buf, iov.bufLen)
+		if !ok {
+			return ErrnoFault
+		}
+
+		if f.writer != nil {
+			n, err := f.writer.Write(buf)
+			nwritten += uint32(n)
+			if err != nil {
+				return errnoOf(err)
+			}
+		} else {
+			nwritten += uint32(len(buf))
+		}
+	}
+
+	if !writeUint32(mem, uint32(args[3]), nwritten) {
+		return ErrnoFault
+	}
+
+	return ErrnoSuccess
+}
+
+func fdPwrite(s *System, mem []byte, args []uint64) Errno {
+	f, errno := s.fd(args[0], rightFdWrite|rightFdSeek)
+	if errno != ErrnoSuccess {
+		return errno
+	}
+
+	if f.fileType == fileTypeDirectory {
+		return ErrnoIsdir
+	}
+
+	writerAt, ok := f.file.(io.WriterAt)
+	if !ok || f.writer == nil {
+		return ErrnoSpipe
+	}
+
+	iovs, ok := readIovecs(mem, uint32(args[1]), uint32(args[2]))
+	if !ok {
+		return ErrnoFault
+	}
+
+	offset := int64(args[3])
+	nwritten := uint32(0)
+	for _, iov := range iovs {
+		buf, ok := memRange(mem, iov.buf, iov.bufLen)
+		if !ok {
+			return ErrnoFault
+		}
+
+		n, err := writerAt.WriteAt(buf, offset)
+		nwritten += uint32(n)
+		offset += int64(n)
+		if err != nil {
+			return errnoOf(err)
+		}
+	}
+
+	if !writeUint32(mem, uint32(args[4]), nwritten) {
+		return ErrnoFault
+	}
+
+	return ErrnoSuccess
+}
+
+// fdReaddir writes the entries of the directory starting from the cookie,
+// the last entry is truncated when the buffer is not large enough
+func fdReaddir(s *System, mem []byte, args []uint64) Errno {
+	f, errno := s.fd(args[0], rightFdReaddir)
+	if errno != ErrnoSuccess {
+		return errno
+	}
+
+	if f.fileType != fileTypeDirectory {
+		return ErrnoNotdir
+	}
+
+	buf, ok := memRange(mem, uint32(args[1]), uint32(args[2]))
+	if !ok {
+		return ErrnoFault
+	}
+
+	entries, err := fs.ReadDir(f.fsys, f.path)
+	if err != nil {
+		return errnoOf(err)
+	}
+
+	cookie := args[3]
+	if cookie > uint64(len(entries)) {
+		return ErrnoInval
+	}
+
+	used := 0
+	for i := cookie; i < uint64(len(entries)) && used < len(buf); i++ {
+		name := entries[i].Name()
+		dirent := make([]byte, direntSize+len(name))
+		binary.LittleEndian.PutUint64(dirent[0:], i+1) // d_next
+		binary.LittleEndian.PutUint32(dirent[16:], uint32(len(name)))
+		dirent[20] = fileTypeOf(entries[i].Type())
+		copy(dirent[direntSize:], name)
+
+		used += copy(buf[used:], dirent)
+	}
+
+	if !writeUint32(mem, uint32(args[4]), uint32(used)) {
+		return ErrnoFault
+	}
+
+	return ErrnoSuccess
+}
@@ -0,0 +1,103 @@
+package wasi
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// WritableFS is an fs.FS which can be modified by the guest
+type WritableFS interface {
+	fs.FS
+
+	// OpenFile opens the named file with the flags of os.OpenFile,
+	// the returned file should implement io.Writer when it is opened for writing.
+	OpenFile(name string, flag int, perm fs.FileMode) (fs.File, error)
+	Mkdir(name string, perm fs.FileMode) error
+	Remove(name string) error
+	Rename(oldname, newname string) error
+}
+
+// DirFS returns a WritableFS for the tree of files rooted at the host directory dir.
+// Like os.DirFS, it does not prevent the symbolic links inside dir from referring outside of it.
+func DirFS(dir string) WritableFS {
+	return dirFS(dir)
+}
+
+type dirFS string
+
+func (dir dirFS) join(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	return filepath.Join(string(dir), filepath.FromSlash(name)), nil
+}
+
+func (dir dirFS) Open(name string) (fs.File, error) {
+	p, err := dir.join("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Open(p)
+}
+
+func (dir dirFS) OpenFile(name string, flag int, perm fs.FileMode) (fs.File, error) {
+	p, err := dir.join("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.OpenFile(p, flag, perm)
+}
+
+func (dir dirFS) Stat(name string) (fs.FileInfo, error) {
+	p, err := dir.join("stat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Stat(p)
+}
+
+func (dir dirFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	p, err := dir.join("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.ReadDir(p)
+}
+
+func (dir dirFS) Mkdir(name string, perm fs.FileMode) error {
+	p, err := dir.join("mkdir", name)
+	if err != nil {
+		return err
+	}
+
+	return os.Mkdir(p, perm)
+}
+
+func (dir dirFS) Remove(name string) error {
+	p, err := dir.join("remove", name)
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(p)
+}
+
+func (dir dirFS) Rename(oldname, newname string) error {
+	oldpath, err := dir.join("rename", oldname)
+	if err != nil {
+		return err
+	}
+
+	newpath, err := dir.join("rename", newname)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(oldpath, newpath)
+}
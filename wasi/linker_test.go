@@ -0,0 +1,120 @@
+package wasi_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/hybridgroup/wasman"
+	"github.com/hybridgroup/wasman/config"
+	"github.com/hybridgroup/wasman/expr"
+	"github.com/hybridgroup/wasman/segments"
+	"github.com/hybridgroup/wasman/types"
+	"github.com/hybridgroup/wasman/wasi"
+)
+
+func TestLinker_DefineWASI(t *testing.T) {
+	i32 := types.ValueTypeI32
+	typeIndex := uint32(0)
+
+	// "hello\n" at 0 and its iovec at 16
+	data := make([]byte, 24)
+	copy(data, "hello\n")
+	data[20] = 6
+
+	mod := &wasman.Module{
+		TypeSection: []*types.FuncType{
+			{InputTypes: []types.ValueType{i32, i32, i32, i32}, ReturnTypes: []types.ValueType{i32}},
+			{},
+		},
+		ImportSection: []*segments.ImportSegment{
+			{Module: wasi.ModuleName, Name: "fd_write", Desc: &segments.ImportDesc{Kind: segments.KindFunction, TypeIndexPtr: &typeIndex}},
+		},
+		FunctionSection: []uint32{1},
+		MemorySection:   []*types.MemoryType{{Min: 1}},
+		CodeSection: []*segments.CodeSegment{
+			{Body: []byte{
+				expr.OpCodeI32Const, 0x01, // stdout
+				expr.OpCodeI32Const, 0x10, // iovs
+				expr.OpCodeI32Const, 0x01, // iovs_len
+				expr.OpCodeI32Const, 0x20, // nwritten
+				expr.OpCodeCall, 0x00,
+				expr.OpCodeDrop,
+			}},
+		},
+		DataSection: []*segments.DataSegment{
+			{OffsetExpression: &expr.Expression{OpCode: expr.OpCodeI32Const, Data: []byte{0x00}}, Init: data},
+		},
+		ExportSection: map[string]*segments.ExportSegment{
+			"_start": {Name: "_start", Desc: &segments.ExportDesc{Kind: segments.KindFunction, Index: 1}},
+		},
+	}
+
+	stdout := new(bytes.Buffer)
+	l := wasman.NewLinker(config.LinkerConfig{})
+	if err := l.DefineWASI(config.WASIConfig{Stdout: stdout}); err != nil {
+		t.Fatal(err)
+	}
+
+	ins, err := l.Instantiate(mod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := ins.CallExportedFunc("_start"); err != nil {
+		t.Fatal(err)
+	}
+
+	if stdout.String() != "hello\n" {
+		t.Logf("stdout is %q", stdout.String())
+		t.Fail()
+	}
+}
+
+func TestLinker_DefineWASI_instances(t *testing.T) {
+	i32 := types.ValueTypeI32
+	typeIndex := uint32(0)
+
+	// close returns the errno of the fd_close(1)
+	mod := &wasman.Module{
+		TypeSection: []*types.FuncType{
+			{InputTypes: []types.ValueType{i32}, ReturnTypes: []types.ValueType{i32}},
+			{ReturnTypes: []types.ValueType{i32}},
+		},
+		ImportSection: []*segments.ImportSegment{
+			{Module: wasi.ModuleName, Name: "fd_close", Desc: &segments.ImportDesc{Kind: segments.KindFunction, TypeIndexPtr: &typeIndex}},
+		},
+		FunctionSection: []uint32{1},
+		CodeSection: []*segments.CodeSegment{
+			{Body: []byte{expr.OpCodeI32Const, 0x01, expr.OpCodeCall, 0x00}},
+		},
+		ExportSection: map[string]*segments.ExportSegment{
+			"close": {Name: "close", Desc: &segments.ExportDesc{Kind: segments.KindFunction, Index: 1}},
+		},
+	}
+
+	l := wasman.NewLinker(config.LinkerConfig{})
+	if err := l.DefineWASI(config.WASIConfig{Stdout: new(bytes.Buffer)}); err != nil {
+		t.Fatal(err)
+	}
+
+	var inss [2]*wasman.Instance
+	for i := range inss {
+		ins, err := l.Instantiate(mod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inss[i] = ins
+	}
+
+	// the stdout closed by the first instance is still open on the second one
+	for i, want := range []wasi.Errno{wasi.ErrnoSuccess, wasi.ErrnoBadf} {
+		if ret, _, err := inss[0].CallExportedFunc("close"); err != nil || wasi.Errno(ret[0]) != want {
+			t.Logf("close %d on the first instance: %v %v, want %v", i, ret, err, want)
+			t.Fail()
+		}
+	}
+	if ret, _, err := inss[1].CallExportedFunc("close"); err != nil || wasi.Errno(ret[0]) != wasi.ErrnoSuccess {
+		t.Logf("close on the second instance: %v %v", ret, err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,78 @@
+package wasi
+
+import "encoding/binary"
+
+// memRange returns the size bytes of the guest memory starting at ptr,
+// ok is false when the range is out of bounds
+func memRange(mem []byte, ptr, size uint32) (b []byte, ok bool) {
+	end := uint64(ptr) + uint64(size)
+	if end > uint64(len(mem)) {
+		return nil, false
+	}
+
+	return mem[ptr:end], true
+}
+
+func readUint32(mem []byte, ptr uint32) (uint32, bool) {
+	b, ok := memRange(mem, ptr, 4)
+	if !ok {
+		return 0, false
+	}
+
+	return binary.LittleEndian.Uint32(b), true
+}
+
+func readUint64(mem []byte, ptr uint32) (uint64, bool) {
+	b, ok := memRange(mem, ptr, 8)
+	if !ok {
+		return 0, false
+	}
+
+	return binary.LittleEndian.Uint64(b), true
+}
+
+func writeUint32(mem []byte, ptr uint32, v uint32) bool {
+	b, ok := memRange(mem, ptr, 4)
+	if !ok {
+		return false
+	}
+
+	binary.LittleEndian.PutUint32(b, v)
+	return true
+}
+
+func writeUint64(mem []byte, ptr uint32, v uint64) bool {
+	b, ok := memRange(mem, ptr, 8)
+	if !ok {
+		return false
+	}
+
+	binary.LittleEndian.PutUint64(b, v)
+	return true
+}
+
+// iovec is a region of the guest memory used in scatter/gather io
+type iovec struct {
+	buf    uint32
+	bufLen uint32
+}
+
+// readIovecs reads the iovecs array starting at ptr
+func readIovecs(mem []byte, ptr, n uint32) ([]iovec, bool) {
+	if uint64(n)*8 > uint64(len(mem)) {
+		return nil, false
+	}
+
+	raw, ok := memRange(mem, ptr, n*8)
+	if !ok {
+		return nil, false
+	}
+
+	ret := make([]iovec, n)
+	for i := range ret {
+		ret[i].buf = binary.LittleEndian.Uint32(raw[i*8:])
+		ret[i].bufLen = binary.LittleEndian.Uint32(raw[i*8+4:])
+	}
+
+	return ret, true
+}
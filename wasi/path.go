@@ -0,0 +1,259 @@
+package wasi
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+)
+
+// available oflags of path_open
+const (
+	oflagCreat     = 1 << 0
+	oflagDirectory = 1 << 1
+	oflagExcl      = 1 << 2
+	oflagTrunc     = 1 << 3
+)
+
+// resolvePath returns the directory fd with the rights and the path in its FS of the path relative to it
+func (s *System) resolvePath(mem []byte, fd uint64, rights uint64, ptr, l uint32) (*fileDesc, string, Errno) {
+	dir, errno := s.fd(fd, rights)
+	if errno != ErrnoSuccess {
+		return nil, "", errno
+	}
+
+	if dir.fsys == nil || dir.fileType != fileTypeDirectory {
+		return nil, "", ErrnoNotdir
+	}
+
+	b, ok := memRange(mem, ptr, l)
+	if !ok {
+		return nil, "", ErrnoFault
+	}
+
+	p := string(b)
+	if path.IsAbs(p) {
+		return nil, "", ErrnoNotcapable
+	}
+
+	// the path should not escape from the preopened directory
+	name := path.Join(dir.path, p)
+	if !fs.ValidPath(name) {
+		return nil, "", ErrnoNotcapable
+	}
+
+	return dir, name, ErrnoSuccess
+}
+
+func pathOpen(s *System, mem []byte, args []uint64) Errno {
+	oflags, rights, rightsInheriting, fdflags := uint16(args[4]), args[5], args[6], uint16(args[7])
+
+	dirRights := uint64(rightPathOpen)
+	if oflags&oflagCreat != 0 {
+		dirRights |= rightPathCreateFile
+	}
+
+	dir, name, errno := s.resolvePath(mem, args[0], dirRights, uint32(args[2]), uint32(args[3]))
+	if errno != ErrnoSuccess {
+		return errno
+	}
+
+	// the rights of the new fd are limited by the inheriting rights of the directory
+	rights &= dir.rightsInheriting
+	rightsInheriting &= dir.rightsInheriting
+
+	// the write is required by the oflags and fdflags only, as the guests request
+	// the write right even for the files they only read, the file is opened for write
+	// with the right when the FS is writable, and read only without the right otherwise
+	read := rights&rightFdRead != 0
+	write := oflags&(oflagCreat|oflagTrunc) != 0 || fdflags&fdflagAppend != 0
+	wfs, writable := dir.fsys.(WritableFS)
+
+	info, err := fs.Stat(dir.fsys, name)
+	if err == nil && oflags&oflagCreat != 0 && oflags&oflagExcl != 0 {
+		return ErrnoExist
+	}
+
+	f := &fileDesc{
+		flags:            fdflags,
+		rights:           rights,
+		rightsInheriting: rightsInheriting,
+		mount:            dir.mount,
+		fsys:             dir.fsys,
+		path:             name,
+	}
+
+	switch {
+	case err == nil && info.IsDir():
+		if write {
+			return ErrnoIsdir
+		}
+		f.fileType = fileTypeDirectory
+	case oflags&oflagDirectory != 0:
+		if err != nil {
+			return errnoOf(err)
+		}
+		return ErrnoNotdir
+	case write && !writable:
+		return ErrnoRofs
+	case write || (writable && rights&rightFdWrite != 0):
+
+		flag := os.O_WRONLY
+		if read {
+			flag = os.O_RDWR
+		}
+		if oflags&oflagCreat != 0 {
+			flag |= os.O_CREATE
+		}
+		if oflags&oflagExcl != 0 {
+			flag |= os.O_EXCL
+		}
+		if oflags&oflagTrunc != 0 {
+			flag |= os.O_TRUNC
+		}
+		if fdflags&fdflagAppend != 0 {
+			flag |= os.O_APPEND
+		}
+
+		f.file, err = wfs.OpenFile(name, flag, 0o644)
+		if err != nil {
+			return errnoOf(err)
+		}
+
+		w, ok := f.file.(io.Writer)
+		if !ok {
+			_ = f.file.Close()
+			return ErrnoRofs
+		}
+		f.writer = w
+		f.fileType = fileTypeRegularFile
+	default:
+		if err != nil {
+			return errnoOf(err)
+		}
+
+		f.file, err = dir.fsys.Open(name)
+		if err != nil {
+			return errnoOf(err)
+		}
+		f.fileType = fileTypeOf(info.Mode())
+		f.rights &^= rightsWrite
+	}
+
+	if read && f.file != nil {
+		f.reader = f.file
+	}
+
+	fd := s.openFd(f)
+	if !writeUint32(mem, uint32(args[8]), fd) {
+		s.mu.Lock()
+		delete(s.fds, fd)
+		s.mu.Unlock()
+		if f.file != nil {
+			_ = f.file.Close()
+		}
+		return ErrnoFault
+	}
+
+	return ErrnoSuccess
+}
+
+func pathFilestatGet(s *System, mem []byte, args []uint64) Errno {
+	dir, name, errno := s.resolvePath(mem, args[0], rightPathFilestatGet, uint32(args[2]), uint32(args[3]))
+	if errno != ErrnoSuccess {
+		return errno
+	}
+
+	info, err := fs.Stat(dir.fsys, name)
+	if err != nil {
+		return errnoOf(err)
+	}
+
+	return writeFilestat(mem, uint32(args[4]), fileTypeOf(info.Mode()), info)
+}
+
+func pathCreateDirectory(s *System, mem []byte, args []uint64) Errno {
+	dir, name, errno := s.resolvePath(mem, args[0], rightPathCreateDirectory, uint32(args[1]), uint32(args[2]))
+	if errno != ErrnoSuccess {
+		return errno
+	}
+
+	wfs, ok := dir.fsys.(WritableFS)
+	if !ok {
+		return ErrnoRofs
+	}
+
+	return errnoOf(wfs.Mkdir(name, 0o755))
+}
+
+func pathRemoveDirectory(s *System, mem []byte, args []uint64) Errno {
+	dir, name, errno := s.resolvePath(mem, args[0], rightPathRemoveDirectory, uint32(args[1]), uint32(args[2]))
+	if errno != ErrnoSuccess {
+		return errno
+	}
+
+	wfs, ok := dir.fsys.(WritableFS)
+	if !ok {
+		return ErrnoRofs
+	}
+
+	entries, err := fs.ReadDir(wfs, name)
+	if err != nil {
+		if info, statErr := fs.Stat(wfs, name); statErr == nil && !info.IsDir() {
+			return ErrnoNotdir
+		}
+		return errnoOf(err)
+	}
+
+	if len(entries) > 0 {
+		return ErrnoNotempty
+	}
+
+	return errnoOf(wfs.Remove(name))
+}
+
+func pathUnlinkFile(s *System, mem []byte, args []uint64) Errno {
+	dir, name, errno := s.resolvePath(mem, args[0], rightPathUnlinkFile, uint32(args[1]), uint32(args[2]))
+	if errno != ErrnoSuccess {
+		return errno
+	}
+
+	wfs, ok := dir.fsys.(WritableFS)
+	if !ok {
+		return ErrnoRofs
+	}
+
+	info, err := fs.Stat(wfs, name)
+	if err != nil {
+		return errnoOf(err)
+	}
+
+	if info.IsDir() {
+		return ErrnoIsdir
+	}
+
+	return errnoOf(wfs.Remove(name))
+}
+
+func pathRename(s *System, mem []byte, args []uint64) Errno {
+	oldDir, oldName, errno := s.resolvePath(mem, args[0], rightPathRenameSource, uint32(args[1]), uint32(args[2]))
+	if errno != ErrnoSuccess {
+		return errno
+	}
+
+	newDir, newName, errno := s.resolvePath(mem, args[3], rightPathRenameTarget, uint32(args[4]), uint32(args[5]))
+	if errno != ErrnoSuccess {
+		return errno
+	}
+
+	if oldDir.mount != newDir.mount {
+		return ErrnoXdev
+	}
+
+	wfs, ok := oldDir.fsys.(WritableFS)
+	if !ok {
+		return ErrnoRofs
+	}
+
+	return errnoOf(wfs.Rename(oldName, newName))
+}
@@ -0,0 +1,215 @@
+package wasi
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/hybridgroup/wasman/config"
+)
+
+// putString puts the str into the mem at ptr, returning its length
+func putString(mem []byte, ptr uint32, str string) uint64 {
+	copy(mem[ptr:], str)
+	return uint64(len(str))
+}
+
+func TestPreopenedFS(t *testing.T) {
+	s := NewSystem(config.WASIConfig{
+		Preopens: []config.WASIPreopen{{
+			GuestPath: "/data",
+			FS: fstest.MapFS{
+				"a.txt":     {Data: []byte("hello")},
+				"dir/b.txt": {Data: []byte("b")},
+			},
+		}},
+	})
+	mem := make([]byte, 512)
+
+	if call(t, s, mem, "fd_prestat_get", 3, 0) != ErrnoSuccess {
+		t.Fail()
+	}
+	if binary.LittleEndian.Uint32(mem[4:]) != 5 {
+		t.Fail()
+	}
+	if call(t, s, mem, "fd_prestat_dir_name", 3, 16, 5) != ErrnoSuccess || string(mem[16:21]) != "/data" {
+		t.Fail()
+	}
+
+	// open and read a.txt
+	l := putString(mem, 32, "a.txt")
+	if call(t, s, mem, "path_open", 3, 0, 32, l, 0, rightFdRead|rightFdSeek|rightFdFilestatGet, 0, 0, 64) != ErrnoSuccess {
+		t.FailNow()
+	}
+	fd := uint64(binary.LittleEndian.Uint32(mem[64:]))
+	if fd != 4 {
+		t.Fail()
+	}
+
+	binary.LittleEndian.PutUint32(mem[96:], 128) // iovec
+	binary.LittleEndian.PutUint32(mem[100:], 16)
+	if call(t, s, mem, "fd_read", fd, 96, 1, 104) != ErrnoSuccess {
+		t.Fail()
+	}
+	if binary.LittleEndian.Uint32(mem[104:]) != 5 || string(mem[128:133]) != "hello" {
+		t.Fail()
+	}
+
+	if call(t, s, mem, "fd_seek", fd, 1, 0, 104) != ErrnoSuccess || binary.LittleEndian.Uint64(mem[104:]) != 1 {
+		t.Fail()
+	}
+	if call(t, s, mem, "fd_read", fd, 96, 1, 104) != ErrnoSuccess || string(mem[128:132]) != "ello" {
+		t.Fail()
+	}
+
+	if call(t, s, mem, "fd_filestat_get", fd, 192) != ErrnoSuccess {
+		t.Fail()
+	}
+	if mem[192+16] != fileTypeRegularFile || binary.LittleEndian.Uint64(mem[192+32:]) != 5 {
+		t.Fail()
+	}
+
+	if call(t, s, mem, "fd_write", fd, 96, 1, 104) != ErrnoNotcapable {
+		t.Fail()
+	}
+	if call(t, s, mem, "fd_tell", fd, 104) != ErrnoNotcapable {
+		t.Fail()
+	}
+	if call(t, s, mem, "fd_close", fd) != ErrnoSuccess {
+		t.Fail()
+	}
+
+	// read-only fs
+	if call(t, s, mem, "path_open", 3, 0, 32, l, oflagCreat, rightFdWrite, 0, 0, 64) != ErrnoRofs {
+		t.Fail()
+	}
+
+	// all the rights are requested, the file is opened to read without the write right
+	if call(t, s, mem, "path_open", 3, 0, 32, l, 0, rightsAll, rightsAll, 0, 64) != ErrnoSuccess {
+		t.FailNow()
+	}
+	fd = uint64(binary.LittleEndian.Uint32(mem[64:]))
+	if call(t, s, mem, "fd_read", fd, 96, 1, 104) != ErrnoSuccess || string(mem[128:133]) != "hello" {
+		t.Fail()
+	}
+	if call(t, s, mem, "fd_write", fd, 96, 1, 104) != ErrnoNotcapable {
+		t.Fail()
+	}
+	if call(t, s, mem, "fd_fdstat_get", fd, 192) != ErrnoSuccess || binary.LittleEndian.Uint64(mem[192+8:])&rightFdWrite != 0 {
+		t.Fail()
+	}
+	if call(t, s, mem, "fd_close", fd) != ErrnoSuccess {
+		t.Fail()
+	}
+
+	// a directory is opened with all the rights
+	l = putString(mem, 32, "dir")
+	if call(t, s, mem, "path_open", 3, 0, 32, l, oflagDirectory, rightsAll, rightsAll, 0, 64) != ErrnoSuccess {
+		t.Fail()
+	}
+
+	// the directory opened without the rights of the path ops
+	if call(t, s, mem, "path_open", 3, 0, 32, l, oflagDirectory, rightFdReaddir, 0, 0, 64) != ErrnoSuccess {
+		t.FailNow()
+	}
+	fd = uint64(binary.LittleEndian.Uint32(mem[64:]))
+	l = putString(mem, 32, "b.txt")
+	if call(t, s, mem, "path_open", fd, 0, 32, l, 0, rightFdRead, 0, 0, 64) != ErrnoNotcapable {
+		t.Fail()
+	}
+	if call(t, s, mem, "path_filestat_get", fd, 0, 32, l, 192) != ErrnoNotcapable {
+		t.Fail()
+	}
+
+	// escape from the preopened directory
+	l = putString(mem, 32, "../a.txt")
+	if call(t, s, mem, "path_open", 3, 0, 32, l, 0, rightFdRead, 0, 0, 64) != ErrnoNotcapable {
+		t.Fail()
+	}
+
+	l = putString(mem, 32, "none.txt")
+	if call(t, s, mem, "path_open", 3, 0, 32, l, 0, rightFdRead, 0, 0, 64) != ErrnoNoent {
+		t.Fail()
+	}
+
+	// list the directory
+	if call(t, s, mem, "fd_readdir", 3, 256, 256, 0, 104) != ErrnoSuccess {
+		t.Fail()
+	}
+	if int(binary.LittleEndian.Uint32(mem[104:])) != direntSize*2+len("a.txt")+len("dir") {
+		t.Fail()
+	}
+	if string(mem[256+direntSize:256+direntSize+5]) != "a.txt" || mem[256+20] != fileTypeRegularFile {
+		t.Fail()
+	}
+
+	if call(t, s, mem, "fd_readdir", 3, 256, 256, 1, 104) != ErrnoSuccess {
+		t.Fail()
+	}
+	if string(mem[256+direntSize:256+direntSize+3]) != "dir" || mem[256+20] != fileTypeDirectory {
+		t.Fail()
+	}
+}
+
+func TestDirFS(t *testing.T) {
+	root := t.TempDir()
+	s := NewSystem(config.WASIConfig{
+		Preopens: []config.WASIPreopen{{GuestPath: "/", FS: DirFS(root)}},
+	})
+	mem := make([]byte, 512)
+
+	l := putString(mem, 32, "sub")
+	if call(t, s, mem, "path_create_directory", 3, 32, l) != ErrnoSuccess {
+		t.Fail()
+	}
+	if call(t, s, mem, "path_create_directory", 3, 32, l) != ErrnoExist {
+		t.Fail()
+	}
+
+	l = putString(mem, 32, "sub/f.txt")
+	if call(t, s, mem, "path_open", 3, 0, 32, l, oflagCreat, rightFdWrite, 0, 0, 64) != ErrnoSuccess {
+		t.FailNow()
+	}
+	fd := uint64(binary.LittleEndian.Uint32(mem[64:]))
+
+	copy(mem[128:], "data")
+	binary.LittleEndian.PutUint32(mem[96:], 128)
+	binary.LittleEndian.PutUint32(mem[100:], 4)
+	if call(t, s, mem, "fd_write", fd, 96, 1, 104) != ErrnoSuccess {
+		t.Fail()
+	}
+	if call(t, s, mem, "fd_close", fd) != ErrnoSuccess {
+		t.Fail()
+	}
+
+	b, err := os.ReadFile(filepath.Join(root, "sub", "f.txt"))
+	if err != nil || string(b) != "data" {
+		t.Fail()
+	}
+
+	putString(mem, 160, "sub/g.txt")
+	if call(t, s, mem, "path_rename", 3, 32, l, 3, 160, l) != ErrnoSuccess {
+		t.Fail()
+	}
+
+	l = putString(mem, 32, "sub")
+	if call(t, s, mem, "path_remove_directory", 3, 32, l) != ErrnoNotempty {
+		t.Fail()
+	}
+	if call(t, s, mem, "path_unlink_file", 3, 32, l) != ErrnoIsdir {
+		t.Fail()
+	}
+
+	if call(t, s, mem, "path_unlink_file", 3, 160, 9) != ErrnoSuccess {
+		t.Fail()
+	}
+	if call(t, s, mem, "path_remove_directory", 3, 32, l) != ErrnoSuccess {
+		t.Fail()
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "sub")); !os.IsNotExist(err) {
+		t.Fail()
+	}
+}
@@ -0,0 +1,242 @@
+package wasi
+
+import (
+	"crypto/rand"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/hybridgroup/wasman/config"
+	"github.com/hybridgroup/wasman/types"
+	"github.com/hybridgroup/wasman/wasm"
+)
+
+// ModuleName is the name of the module which the WASI preview1 functions are imported from
+const ModuleName = "wasi_snapshot_preview1"
+
+// System is the host environment seen by the guest through the WASI functions
+type System struct {
+	config.WASIConfig
+
+	start time.Time
+
+	mu  sync.Mutex // guards fds
+	fds map[uint32]*fileDesc
+}
+
+// NewSystem creates a new System from the config
+func NewSystem(conf config.WASIConfig) *System {
+	if conf.Random == nil {
+		conf.Random = rand.Reader
+	}
+	if conf.Now == nil {
+		conf.Now = time.Now
+	}
+
+	s := &System{
+		WASIConfig: conf,
+		start:      conf.Now(),
+		fds: map[uint32]*fileDesc{
+			0: {fileType: fileTypeCharacterDevice, rights: rightFdRead | rightFdFilestatGet | rightPollFdReadwrite, reader: conf.Stdin},
+			1: {fileType: fileTypeCharacterDevice, rights: rightFdWrite | rightFdFilestatGet | rightPollFdReadwrite, writer: conf.Stdout},
+			2: {fileType: fileTypeCharacterDevice, rights: rightFdWrite | rightFdFilestatGet | rightPollFdReadwrite, writer: conf.Stderr},
+		},
+	}
+
+	for i, preopen := range conf.Preopens {
+		s.fds[uint32(3+i)] = &fileDesc{
+			fileType:         fileTypeDirectory,
+			rights:           rightsAll,
+			rightsInheriting: rightsAll,
+			mount:            i,
+			preopen:          true,
+			fsys:             preopen.FS,
+			path:             ".",
+		}
+	}
+
+	return s
+}
+
+// function is the implementation of one WASI function,
+// taking the guest memory and the raw args
+type function struct {
+	params  []types.ValueType
+	results []types.ValueType
+	fn      func(s *System, mem []byte, args []uint64) Errno
+}
+
+var (
+	i32 = types.ValueTypeI32
+	i64 = types.ValueTypeI64
+
+	errnoResult = []types.ValueType{i32}
+)
+
+// functions are the functions of wasi_snapshot_preview1
+var functions = map[string]function{
+	"args_get":                {[]types.ValueType{i32, i32}, errnoResult, argsGet},
+	"args_sizes_get":          {[]types.ValueType{i32, i32}, errnoResult, argsSizesGet},
+	"environ_get":             {[]types.ValueType{i32, i32}, errnoResult, environGet},
+	"environ_sizes_get":       {[]types.ValueType{i32, i32}, errnoResult, environSizesGet},
+	"clock_res_get":           {[]types.ValueType{i32, i32}, errnoResult, clockResGet},
+	"clock_time_get":          {[]types.ValueType{i32, i64, i32}, errnoResult, clockTimeGet},
+	"fd_advise":               {[]types.ValueType{i32, i64, i64, i32}, errnoResult, fdAdvise},
+	"fd_allocate":             {[]types.ValueType{i32, i64, i64}, errnoResult, nosys},
+	"fd_close":                {[]types.ValueType{i32}, errnoResult, fdClose},
+	"fd_datasync":             {[]types.ValueType{i32}, errnoResult, fdDatasync},
+	"fd_fdstat_get":           {[]types.ValueType{i32, i32}, errnoResult, fdFdstatGet},
+	"fd_fdstat_set_flags":     {[]types.ValueType{i32, i32}, errnoResult, nosys},
+	"fd_fdstat_set_rights":    {[]types.ValueType{i32, i64, i64}, errnoResult, nosys},
+	"fd_filestat_get":         {[]types.ValueType{i32, i32}, errnoResult, fdFilestatGet},
+	"fd_filestat_set_size":    {[]types.ValueType{i32, i64}, errnoResult, fdFilestatSetSize},
+	"fd_filestat_set_times":   {[]types.ValueType{i32, i64, i64, i32}, errnoResult, nosys},
+	"fd_pread":                {[]types.ValueType{i32, i32, i32, i64, i32}, errnoResult, fdPread},
+	"fd_prestat_get":          {[]types.ValueType{i32, i32}, errnoResult, fdPrestatGet},
+	"fd_prestat_dir_name":     {[]types.ValueType{i32, i32, i32}, errnoResult, fdPrestatDirName},
+	"fd_pwrite":               {[]types.ValueType{i32, i32, i32, i64, i32}, errnoResult, fdPwrite},
+	"fd_read":                 {[]types.ValueType{i32, i32, i32, i32}, errnoResult, fdRead},
+	"fd_readdir":              {[]types.ValueType{i32, i32, i32, i64, i32}, errnoResult, fdReaddir},
+	"fd_renumber":             {[]types.ValueType{i32, i32}, errnoResult, fdRenumber},
+	"fd_seek":                 {[]types.ValueType{i32, i64, i32, i32}, errnoResult, fdSeek},
+	"fd_sync":                 {[]types.ValueType{i32}, errnoResult, fdSync},
+	"fd_tell":                 {[]types.ValueType{i32, i32}, errnoResult, fdTell},
+	"fd_write":                {[]types.ValueType{i32, i32, i32, i32}, errnoResult, fdWrite},
+	"path_create_directory":   {[]types.ValueType{i32, i32, i32}, errnoResult, pathCreateDirectory},
+	"path_filestat_get":       {[]types.ValueType{i32, i32, i32, i32, i32}, errnoResult, pathFilestatGet},
+	"path_filestat_set_times": {[]types.ValueType{i32, i32, i32, i32, i64, i64, i32}, errnoResult, nosys},
+	"path_link":               {[]types.ValueType{i32, i32, i32, i32, i32, i32, i32}, errnoResult, nosys},
+	"path_open":               {[]types.ValueType{i32, i32, i32, i32, i32, i64, i64, i32, i32}, errnoResult, pathOpen},
+	"path_readlink":           {[]types.ValueType{i32, i32, i32, i32, i32, i32}, errnoResult, nosys},
+	"path_remove_directory":   {[]types.ValueType{i32, i32, i32}, errnoResult, pathRemoveDirectory},
+	"path_rename":             {[]types.ValueType{i32, i32, i32, i32, i32, i32}, errnoResult, pathRename},
+	"path_symlink":            {[]types.ValueType{i32, i32, i32, i32, i32}, errnoResult, nosys},
+	"path_unlink_file":        {[]types.ValueType{i32, i32, i32}, errnoResult, pathUnlinkFile},
+	"poll_oneoff":             {[]types.ValueType{i32, i32, i32, i32}, errnoResult, pollOneoff},
+	"proc_exit":               {[]types.ValueType{i32}, []types.ValueType{}, procExit},
+	"proc_raise":              {[]types.ValueType{i32}, errnoResult, nosys},
+	"sched_yield":             {[]types.ValueType{}, errnoResult, schedYield},
+	"random_get":              {[]types.ValueType{i32, i32}, errnoResult, randomGet},
+	"sock_accept":             {[]types.ValueType{i32, i32, i32}, errnoResult, nosys},
+	"sock_recv":               {[]types.ValueType{i32, i32, i32, i32, i32, i32}, errnoResult, nosys},
+	"sock_send":               {[]types.ValueType{i32, i32, i32, i32, i32}, errnoResult, nosys},
+	"sock_shutdown":           {[]types.ValueType{i32, i32}, errnoResult, nosys},
+}
+
+// HostFuncs returns all the functions of the wasi_snapshot_preview1 module, keyed by their names,
+// a System of the conf is created for each instance importing them so that the instances don't share the fds
+func HostFuncs(conf config.WASIConfig) map[string]*wasm.HostFunc {
+	key := &conf // the System of the instance is kept with the key unique to the call
+	return hostFuncs(func(ins *wasm.Instance) *System {
+		return ins.HostValue(key, func() interface{} { return NewSystem(conf) }).(*System)
+	})
+}
+
+// HostFuncs returns all the functions of the wasi_snapshot_preview1 module on the System, keyed by their names,
+// the System is shared by all the instances importing them
+func (s *System) HostFuncs() map[string]*wasm.HostFunc {
+	return hostFuncs(func(*wasm.Instance) *System { return s })
+}
+
+// hostFuncs returns the functions running on the System of the instance
+func hostFuncs(system func(ins *wasm.Instance) *System) map[string]*wasm.HostFunc {
+	ret := make(map[string]*wasm.HostFunc, len(functions))
+	for name, f := range functions {
+		f := f
+		ret[name] = &wasm.HostFunc{
+			Signature: &types.FuncType{
+				InputTypes:  f.params,
+				ReturnTypes: f.results,
+			},
+			Generator: func(ins *wasm.Instance) wasm.RawHostFunc {
+				s := system(ins)
+				return func(args []uint64) []uint64 {
+					var mem []byte
+					if ins.Memory != nil {
+						mem = ins.Memory.Value
+					}
+
+					errno := f.fn(s, mem, args)
+					if len(f.results) == 0 {
+						return []uint64{}
+					}
+
+					return []uint64{uint64(errno)}
+				}
+			},
+		}
+	}
+
+	return ret
+}
+
+func nosys(_ *System, _ []byte, _ []uint64) Errno {
+	return ErrnoNosys
+}
+
+// writeStrings writes the strings as null-terminated ones into the buf,
+// with the pointers to them into the ptrs
+func writeStrings(mem []byte, strs []string, ptrs, buf uint32) Errno {
+	for i, str := range strs {
+		if !writeUint32(mem, ptrs+uint32(i)*4, buf) {
+			return ErrnoFault
+		}
+
+		b, ok := memRange(mem, buf, uint32(len(str))+1)
+		if !ok {
+			return ErrnoFault
+		}
+		copy(b, str)
+		b[len(str)] = 0
+		buf += uint32(len(b))
+	}
+
+	return ErrnoSuccess
+}
+
+// writeStringsSizes writes the number of strings to countPtr and the size of the buffer holding them to sizePtr
+func writeStringsSizes(mem []byte, strs []string, countPtr, sizePtr uint32) Errno {
+	size := 0
+	for _, str := range strs {
+		size += len(str) + 1
+	}
+
+	if !writeUint32(mem, countPtr, uint32(len(strs))) || !writeUint32(mem, sizePtr, uint32(size)) {
+		return ErrnoFault
+	}
+
+	return ErrnoSuccess
+}
+
+func argsGet(s *System, mem []byte, args []uint64) Errno {
+	return writeStrings(mem, s.Args, uint32(args[0]), uint32(args[1]))
+}
+
+func argsSizesGet(s *System, mem []byte, args []uint64) Errno {
+	return writeStringsSizes(mem, s.Args, uint32(args[0]), uint32(args[1]))
+}
+
+func environGet(s *System, mem []byte, args []uint64) Errno {
+	return writeStrings(mem, s.Env, uint32(args[0]), uint32(args[1]))
+}
+
+func environSizesGet(s *System, mem []byte, args []uint64) Errno {
+	return writeStringsSizes(mem, s.Env, uint32(args[0]), uint32(args[1]))
+}
+
+func randomGet(s *System, mem []byte, args []uint64) Errno {
+	b, ok := memRange(mem, uint32(args[0]), uint32(args[1]))
+	if !ok {
+		return ErrnoFault
+	}
+
+	if _, err := io.ReadFull(s.Random, b); err != nil {
+		return ErrnoIo
+	}
+
+	return ErrnoSuccess
+}
+
+func procExit(_ *System, _ []byte, args []uint64) Errno {
+	panic(&wasm.ExitError{Code: uint32(args[0])})
+}
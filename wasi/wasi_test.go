@@ -0,0 +1,210 @@
+package wasi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hybridgroup/wasman/config"
+	"github.com/hybridgroup/wasman/wasm"
+)
+
+// call calls the WASI function name on the given guest memory
+func call(t *testing.T, s *System, mem []byte, name string, args ...uint64) Errno {
+	f, ok := s.HostFuncs()[name]
+	if !ok {
+		t.Fatalf("%s not found", name)
+	}
+
+	ins := &wasm.Instance{Memory: &wasm.Memory{Value: mem}}
+	ret := f.Generator(ins)(args)
+	if len(ret) != 1 {
+		t.Fatalf("invalid results of %s: %v", name, ret)
+	}
+
+	return Errno(ret[0])
+}
+
+func TestArgsAndEnviron(t *testing.T) {
+	s := NewSystem(config.WASIConfig{
+		Args: []string{"prog", "-v"},
+		Env:  []string{"A=1"},
+	})
+	mem := make([]byte, 128)
+
+	if call(t, s, mem, "args_sizes_get", 0, 4) != ErrnoSuccess {
+		t.Fail()
+	}
+	if binary.LittleEndian.Uint32(mem[0:]) != 2 || binary.LittleEndian.Uint32(mem[4:]) != 8 {
+		t.Fail()
+	}
+
+	if call(t, s, mem, "args_get", 16, 32) != ErrnoSuccess {
+		t.Fail()
+	}
+	if binary.LittleEndian.Uint32(mem[16:]) != 32 || binary.LittleEndian.Uint32(mem[20:]) != 37 {
+		t.Fail()
+	}
+	if string(mem[32:40]) != "prog\x00-v\x00" {
+		t.Logf("args are %q", mem[32:40])
+		t.Fail()
+	}
+
+	if call(t, s, mem, "environ_sizes_get", 0, 4) != ErrnoSuccess {
+		t.Fail()
+	}
+	if binary.LittleEndian.Uint32(mem[0:]) != 1 || binary.LittleEndian.Uint32(mem[4:]) != 4 {
+		t.Fail()
+	}
+
+	if call(t, s, mem, "environ_get", 64, 80) != ErrnoSuccess {
+		t.Fail()
+	}
+	if string(mem[80:84]) != "A=1\x00" {
+		t.Fail()
+	}
+
+	if call(t, s, mem, "args_get", 126, 32) != ErrnoFault {
+		t.Fail()
+	}
+}
+
+func TestFdWriteAndRead(t *testing.T) {
+	stdout := new(bytes.Buffer)
+	s := NewSystem(config.WASIConfig{
+		Stdin:  strings.NewReader("input"),
+		Stdout: stdout,
+	})
+	mem := make([]byte, 128)
+	copy(mem, "hello ")
+	copy(mem[8:], "world")
+	// iovecs
+	binary.LittleEndian.PutUint32(mem[32:], 0)
+	binary.LittleEndian.PutUint32(mem[36:], 6)
+	binary.LittleEndian.PutUint32(mem[40:], 8)
+	binary.LittleEndian.PutUint32(mem[44:], 5)
+
+	if call(t, s, mem, "fd_write", 1, 32, 2, 48) != ErrnoSuccess {
+		t.Fail()
+	}
+	if stdout.String() != "hello world" || binary.LittleEndian.Uint32(mem[48:]) != 11 {
+		t.Logf("stdout is %q", stdout.String())
+		t.Fail()
+	}
+
+	if call(t, s, mem, "fd_write", 2, 32, 2, 48) != ErrnoSuccess {
+		t.Fail()
+	}
+
+	if call(t, s, mem, "fd_write", 5, 32, 2, 48) != ErrnoBadf {
+		t.Fail()
+	}
+
+	// stdin is not writable, and stdout is not readable
+	if call(t, s, mem, "fd_write", 0, 32, 2, 48) != ErrnoNotcapable {
+		t.Fail()
+	}
+	if call(t, s, mem, "fd_read", 1, 32, 1, 48) != ErrnoNotcapable {
+		t.Fail()
+	}
+
+	// read into the first iovec only
+	if call(t, s, mem, "fd_read", 0, 32, 1, 48) != ErrnoSuccess {
+		t.Fail()
+	}
+	if string(mem[:5]) != "input" || binary.LittleEndian.Uint32(mem[48:]) != 5 {
+		t.Fail()
+	}
+
+	if call(t, s, mem, "fd_read", 0, 126, 1, 48) != ErrnoFault {
+		t.Fail()
+	}
+
+	if call(t, s, mem, "fd_prestat_get", 3, 0) != ErrnoBadf {
+		t.Fail()
+	}
+
+	if call(t, s, mem, "fd_close", 1) != ErrnoSuccess || call(t, s, mem, "fd_close", 1) != ErrnoBadf {
+		t.Fail()
+	}
+}
+
+func TestClockAndRandom(t *testing.T) {
+	now := time.Unix(10, 0)
+	s := NewSystem(config.WASIConfig{
+		Random: bytes.NewReader([]byte{1, 2, 3, 4}),
+		Now: func() time.Time {
+			return now
+		},
+	})
+	mem := make([]byte, 64)
+
+	if call(t, s, mem, "clock_time_get", clockRealtime, 1, 0) != ErrnoSuccess {
+		t.Fail()
+	}
+	if binary.LittleEndian.Uint64(mem) != uint64(10*time.Second) {
+		t.Fail()
+	}
+
+	now = now.Add(time.Second)
+	if call(t, s, mem, "clock_time_get", clockMonotonic, 1, 0) != ErrnoSuccess {
+		t.Fail()
+	}
+	if binary.LittleEndian.Uint64(mem) != uint64(time.Second) {
+		t.Fail()
+	}
+
+	if call(t, s, mem, "clock_time_get", 10, 1, 0) != ErrnoInval {
+		t.Fail()
+	}
+
+	if call(t, s, mem, "random_get", 8, 4) != ErrnoSuccess {
+		t.Fail()
+	}
+	if !bytes.Equal(mem[8:12], []byte{1, 2, 3, 4}) {
+		t.Fail()
+	}
+
+	if call(t, s, mem, "random_get", 8, 4) != ErrnoIo {
+		t.Fail()
+	}
+}
+
+func TestPollOneoff(t *testing.T) {
+	s := NewSystem(config.WASIConfig{})
+	mem := make([]byte, 256)
+
+	// one relative clock subscription
+	binary.LittleEndian.PutUint64(mem[0:], 42)                        // userdata
+	mem[8] = eventTypeClock                                           // tag
+	binary.LittleEndian.PutUint32(mem[16:], clockMonotonic)           // id
+	binary.LittleEndian.PutUint64(mem[24:], uint64(time.Millisecond)) // timeout
+
+	if call(t, s, mem, "poll_oneoff", 0, 64, 1, 128) != ErrnoSuccess {
+		t.Fail()
+	}
+	if binary.LittleEndian.Uint32(mem[128:]) != 1 {
+		t.Fail()
+	}
+	if binary.LittleEndian.Uint64(mem[64:]) != 42 || mem[64+10] != eventTypeClock {
+		t.Fail()
+	}
+
+	if call(t, s, mem, "poll_oneoff", 0, 64, 0, 128) != ErrnoInval {
+		t.Fail()
+	}
+}
+
+func TestProcExit(t *testing.T) {
+	defer func() {
+		if v, ok := recover().(*wasm.ExitError); !ok || v.Code != 3 {
+			t.Logf("recovered %v", v)
+			t.Fail()
+		}
+	}()
+
+	s := NewSystem(config.WASIConfig{})
+	s.HostFuncs()["proc_exit"].Generator(&wasm.Instance{})([]uint64{3})
+}
@@ -0,0 +1,43 @@
+package wasm
+
+import (
+	"context"
+
+	"github.com/hybridgroup/wasman/types"
+)
+
+// Caller is the context of a host func call, which gives access to the calling Instance
+type Caller struct {
+	Instance *Instance
+}
+
+// Context returns the context of the running CallExportedFuncContext,
+// the background context when the instance is being initialized
+func (c *Caller) Context() context.Context {
+	if c.Instance.ctx == nil {
+		return context.Background()
+	}
+
+	return c.Instance.ctx
+}
+
+// Memory returns the memory of the calling instance, nil if it has no memory
+func (c *Caller) Memory() *Memory {
+	return c.Instance.Memory
+}
+
+// Frame returns the frame of the wasm func calling the host func,
+// nil if the host func is called directly by the CallExportedFunc
+func (c *Caller) Frame() *Frame {
+	return c.Instance.Active
+}
+
+// Global returns the value of the global exported with the name
+func (c *Caller) Global(name string) (types.Value, bool) {
+	return c.Instance.ExportedGlobal(name)
+}
+
+// SetGlobal sets the value of the mutable global exported with the name
+func (c *Caller) SetGlobal(name string, val types.Value) bool {
+	return c.Instance.SetExportedGlobal(name, val)
+}
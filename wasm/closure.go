@@ -0,0 +1,555 @@
+package wasm
+
+import (
+	"math/bits"
+	"sync/atomic"
+
+	"github.com/hybridgroup/wasman/expr"
+)
+
+// closureNext is returned by the run of the closureStep going on with the next step
+const closureNext = -1
+
+// closureStep is a run of the compiled instrs executed by one closure of the closure engine,
+// the instrs of the prefix are the pure operands fused into the last one
+type closureStep struct {
+	run func(ins *Instance, fr *Frame) (jump int, err error) // jump is the index of the step run next, or closureNext
+
+	last    uint64   // the index of the last instr, which may jump or trap
+	prefix  []uint64 // the indexes of the fused instrs before the last one
+	heights []int    // the height of the operand stack after each prefix instr, relative to the start of the step
+	peak    int      // the max of the heights
+}
+
+func (s *closureStep) first() uint64 {
+	if len(s.prefix) > 0 {
+		return s.prefix[0]
+	}
+
+	return s.last
+}
+
+// closureNode is a pure value computed by a tree of the fused instrs, not pushed to the operand stack
+type closureNode struct {
+	eval    func(ins *Instance, fr *Frame) uint64
+	pcs     []uint64 // the indexes of the instrs in the order of the body, the root is the last one
+	heights []int    // the height of the operand stack after each instr, relative to the start of the tree
+
+	// the operands bound to the fast nodes
+	local  *uint32 // the index of the local got by the node
+	konst  *uint64 // the value of the const node
+	binary func(v1, v2 uint64) uint64
+	args   []*closureNode
+}
+
+// closureCompiler compiles the instrs of a wasmFunc into the closureSteps
+type closureCompiler struct {
+	ins      *Instance
+	code     []instruction
+	toll     bool // whether the nop like instrs have their own steps to be charged
+	steps    []closureStep
+	pending  []*closureNode // the values of the operand stack not pushed yet
+	isTarget []bool         // whether the instr at the index is the next one run after a jump
+	fixups   []closureFixup
+}
+
+// closureFixup is a jump of the closures to be resolved when all steps are compiled
+type closureFixup struct {
+	step   *int
+	target uint64 // the index of the instr jumped to, the execution goes on with the next one
+}
+
+// compileClosures compiles the body of the func for the closure engine
+func (ins *Instance) compileClosures(f *wasmFunc) {
+	c := &closureCompiler{
+		ins:      ins,
+		code:     f.code,
+		toll:     ins.TollStation != nil,
+		steps:    make([]closureStep, 0, len(f.code)),
+		isTarget: make([]bool, len(f.code)+1),
+	}
+
+	for _, in := range f.code {
+		for _, t := range in.Targets {
+			c.isTarget[t+1] = true
+		}
+	}
+
+	for pc := range f.code {
+		if c.isTarget[pc] {
+			c.flush(0)
+		}
+		c.compile(uint64(pc))
+	}
+	c.flush(0)
+
+	// entries are the steps starting at or after the instrs
+	entries := make([]int, len(f.code)+1)
+	for pc, i := len(f.code), len(c.steps); pc >= 0; pc-- {
+		for i > 0 && c.steps[i-1].first() >= uint64(pc) {
+			i--
+		}
+		entries[pc] = i
+	}
+	for _, fix := range c.fixups {
+		*fix.step = entries[fix.target+1]
+	}
+
+	f.steps = c.steps
+}
+
+func (c *closureCompiler) compile(pc uint64) {
+	in := &c.code[pc]
+	switch op := in.OpCode; op {
+	case expr.OpCodeNop, expr.OpCodeBlock, expr.OpCodeLoop, expr.OpCodeEnd:
+		if c.toll {
+			c.flush(0)
+			c.emit(pc, nil, func(*Instance, *Frame) (int, error) { return closureNext, nil })
+		}
+	case expr.OpCodeElse, expr.OpCodeBr:
+		c.flush(0)
+		target := c.jump(in.Targets[0])
+		c.emit(pc, nil, func(*Instance, *Frame) (int, error) { return *target, nil })
+	case expr.OpCodeReturn:
+		c.flush(0)
+		target := c.jump(uint64(len(c.code)) - 1) // the end of the func
+		c.emit(pc, nil, func(*Instance, *Frame) (int, error) { return *target, nil })
+	case expr.OpCodeIf, expr.OpCodeBrIf:
+		// the br_if jumps when the condition is true, and the if jumps to its else or end when false
+		target := c.jump(in.Targets[0])
+		next := closureNext
+		onTrue, onFalse := target, &next
+		if op == expr.OpCodeIf {
+			onTrue, onFalse = &next, target
+		}
+
+		cond := c.operand()
+		if cond == nil {
+			c.emit(pc, nil, func(ins *Instance, _ *Frame) (int, error) {
+				if ins.OperandStack.Pop() != 0 {
+					return *onTrue, nil
+				}
+				return *onFalse, nil
+			})
+		} else if cond.binary != nil {
+			// the fused compare and branch
+			cmp, v1, v2 := cond.binary, cond.args[0].eval, cond.args[1].eval
+			c.emit(pc, []*closureNode{cond}, func(ins *Instance, fr *Frame) (int, error) {
+				if cmp(v1(ins, fr), v2(ins, fr)) != 0 {
+					return *onTrue, nil
+				}
+				return *onFalse, nil
+			})
+		} else {
+			eval := cond.eval
+			c.emit(pc, []*closureNode{cond}, func(ins *Instance, fr *Frame) (int, error) {
+				if eval(ins, fr) != 0 {
+					return *onTrue, nil
+				}
+				return *onFalse, nil
+			})
+		}
+	case expr.OpCodeBrTable:
+		targets := make([]*int, len(in.Targets))
+		for i, t := range in.Targets {
+			targets[i] = c.jump(t)
+		}
+		pop := func(ins *Instance, _ *Frame) uint64 { return ins.OperandStack.Pop() }
+		var operands []*closureNode
+		if v := c.operand(); v != nil {
+			pop, operands = v.eval, []*closureNode{v}
+		}
+		c.emit(pc, operands, func(ins *Instance, fr *Frame) (int, error) {
+			i := uint32(pop(ins, fr))
+			if uint64(i) < uint64(len(targets)-1) {
+				return *targets[i], nil
+			}
+			return *targets[len(targets)-1], nil // the default one
+		})
+	case expr.OpCodeLocalGet:
+		id := uint32(in.Immediates[0])
+		c.push(pc, &closureNode{
+			eval:  func(_ *Instance, fr *Frame) uint64 { return fr.Locals[id] },
+			local: &id,
+		})
+	case expr.OpCodeGlobalGet:
+		id := in.Immediates[0]
+		c.push(pc, &closureNode{eval: func(ins *Instance, _ *Frame) uint64 { return ins.Globals[id] }})
+	case expr.OpCodeI32Const, expr.OpCodeI64Const, expr.OpCodeF32Const, expr.OpCodeF64Const:
+		v := in.Immediates[0]
+		if op == expr.OpCodeI32Const {
+			v = uint64(int32(v)) // sign extended as the i32Const
+		}
+		c.push(pc, &closureNode{eval: func(*Instance, *Frame) uint64 { return v }, konst: &v})
+	case expr.OpCodeLocalSet, expr.OpCodeLocalTee:
+		id := in.Immediates[0]
+		v := c.operand()
+		if v == nil {
+			c.emitInstruction(pc)
+			return
+		}
+		eval := v.eval
+		if op == expr.OpCodeLocalSet {
+			c.emit(pc, []*closureNode{v}, func(ins *Instance, fr *Frame) (int, error) {
+				fr.Locals[id] = eval(ins, fr)
+				return closureNext, nil
+			})
+		} else {
+			c.emit(pc, []*closureNode{v}, func(ins *Instance, fr *Frame) (int, error) {
+				v := eval(ins, fr)
+				fr.Locals[id] = v
+				ins.OperandStack.Push(v)
+				return closureNext, nil
+			})
+		}
+	case expr.OpCodeGlobalSet:
+		id := in.Immediates[0]
+		v := c.operand()
+		if v == nil {
+			c.emitInstruction(pc)
+			return
+		}
+		eval := v.eval
+		c.emit(pc, []*closureNode{v}, func(ins *Instance, fr *Frame) (int, error) {
+			ins.Globals[id] = eval(ins, fr)
+			return closureNext, nil
+		})
+	case expr.OpCodeDrop:
+		v := c.operand()
+		if v == nil {
+			c.emitInstruction(pc)
+			return
+		}
+		// the pure value is not computed
+		c.emit(pc, []*closureNode{v}, func(*Instance, *Frame) (int, error) { return closureNext, nil })
+	case expr.OpCodeCall:
+		// the callee is looked up when called, it may be replaced by the SetFunc
+		idx := in.Immediates[0]
+		n := len(c.ins.Functions[idx].getType().InputTypes)
+		c.emitOperands(pc, n, func(ins *Instance, fr *Frame) (int, error) {
+			return closureNext, ins.Functions[idx].call(ins)
+		})
+	default:
+		n, pure := c.arity(in)
+		if pure && len(c.pending) >= n {
+			c.push(pc, c.node(in, c.pop(n)))
+			return
+		}
+
+		if n < 0 {
+			c.emitInstruction(pc)
+			return
+		}
+
+		handler := instructions[op]
+		c.emitOperands(pc, n, func(ins *Instance, _ *Frame) (int, error) {
+			return closureNext, handler(ins)
+		})
+	}
+}
+
+// arity returns the number of the operands of the instr, or -1 if it is not known,
+// and whether the instr is pure, which can be fused into a closureNode
+func (c *closureCompiler) arity(in *instruction) (int, bool) {
+	switch op := in.OpCode; op {
+	case expr.OpCodeSelect:
+		return 3, false
+	case expr.OpCodeMemoryGrow:
+		return 1, false
+	case expr.OpCodeCallIndirect:
+		return len(c.ins.TypeSection[in.Immediates[0]].InputTypes) + 1, false
+	}
+
+	if _, _, store, ok := memoryAccess(in.OpCode); ok {
+		if store {
+			return 2, false
+		}
+		return 1, false
+	}
+
+	params, _, ok := numericSignature(in.OpCode)
+	if !ok || instructions[in.OpCode] == nil {
+		return -1, false
+	}
+
+	switch op := in.OpCode; {
+	case expr.OpCodeI32DivS <= op && op <= expr.OpCodeI32RemU,
+		expr.OpCodeI64DivS <= op && op <= expr.OpCodeI64RemU: // may trap with the ErrUndefined
+		return len(params), false
+	}
+
+	return len(params), true
+}
+
+// node fuses the pure instr and its operands into a closureNode
+func (c *closureCompiler) node(in *instruction, args []*closureNode) *closureNode {
+	n := &closureNode{args: args}
+	if f, ok := closureBinaryOps[in.OpCode]; ok {
+		n.binary = f
+		v1, v2 := args[0], args[1]
+		switch {
+		case v1.local != nil && v2.konst != nil:
+			x, k := *v1.local, *v2.konst
+			n.eval = func(_ *Instance, fr *Frame) uint64 { return f(fr.Locals[x], k) }
+		case v1.local != nil && v2.local != nil:
+			x, y := *v1.local, *v2.local
+			n.eval = func(_ *Instance, fr *Frame) uint64 { return f(fr.Locals[x], fr.Locals[y]) }
+		default:
+			e1, e2 := v1.eval, v2.eval
+			n.eval = func(ins *Instance, fr *Frame) uint64 { return f(e1(ins, fr), e2(ins, fr)) }
+		}
+		return n
+	}
+
+	// the others are computed by their instr on the operand stack
+	handler := instructions[in.OpCode]
+	n.eval = func(ins *Instance, fr *Frame) uint64 {
+		for _, arg := range args {
+			ins.OperandStack.Push(arg.eval(ins, fr))
+		}
+		_ = handler(ins)
+		return ins.OperandStack.Pop()
+	}
+
+	return n
+}
+
+// push adds the node of the instrs ending at the pc to the pending values
+func (c *closureCompiler) push(pc uint64, n *closureNode) {
+	for i, arg := range n.args {
+		n.pcs = append(n.pcs, arg.pcs...)
+		for _, h := range arg.heights {
+			n.heights = append(n.heights, h+i)
+		}
+	}
+	n.pcs = append(n.pcs, pc)
+	n.heights = append(n.heights, 1)
+
+	c.pending = append(c.pending, n)
+}
+
+// pop removes the n values on the top of the pending ones
+func (c *closureCompiler) pop(n int) []*closureNode {
+	args := make([]*closureNode, n)
+	copy(args, c.pending[len(c.pending)-n:])
+	c.pending = c.pending[:len(c.pending)-n]
+
+	return args
+}
+
+// operand returns the pending value on the top to be used by the instr,
+// the other pending ones are pushed, nil if it is on the operand stack
+func (c *closureCompiler) operand() *closureNode {
+	if len(c.pending) == 0 {
+		return nil
+	}
+
+	v := c.pop(1)[0]
+	c.flush(0)
+
+	return v
+}
+
+// flush pushes the pending values except the n ones on the top to the operand stack
+func (c *closureCompiler) flush(n int) {
+	values := c.pending[:len(c.pending)-n]
+	for _, v := range values {
+		last := len(v.pcs) - 1
+		eval := v.eval
+		c.steps = append(c.steps, closureStep{
+			run: func(ins *Instance, fr *Frame) (int, error) {
+				ins.OperandStack.Push(eval(ins, fr))
+				return closureNext, nil
+			},
+			last:    v.pcs[last],
+			prefix:  v.pcs[:last],
+			heights: v.heights[:last],
+			peak:    peak(v.heights[:last]),
+		})
+	}
+
+	c.pending = append(c.pending[:0], c.pending[len(values):]...)
+}
+
+// emit adds the step of the instr at the pc consuming the pending operands
+func (c *closureCompiler) emit(pc uint64, operands []*closureNode, run func(ins *Instance, fr *Frame) (int, error)) {
+	s := closureStep{run: run, last: pc}
+	for i, v := range operands {
+		s.prefix = append(s.prefix, v.pcs...)
+		for _, h := range v.heights {
+			s.heights = append(s.heights, h+i)
+		}
+	}
+	s.peak = peak(s.heights)
+
+	c.steps = append(c.steps, s)
+}
+
+// emitOperands adds the step of the instr taking n operands,
+// which are pushed before the run if they are pending
+func (c *closureCompiler) emitOperands(pc uint64, n int, run func(ins *Instance, fr *Frame) (int, error)) {
+	if n == 0 || len(c.pending) < n {
+		c.flush(0)
+		c.emit(pc, nil, run)
+		return
+	}
+
+	c.flush(n)
+	operands := c.pop(n)
+	c.emit(pc, operands, func(ins *Instance, fr *Frame) (int, error) {
+		for _, v := range operands {
+			ins.OperandStack.Push(v.eval(ins, fr))
+		}
+		return run(ins, fr)
+	})
+}
+
+// emitInstruction adds the step running the instr at the pc as the interpreter does
+func (c *closureCompiler) emitInstruction(pc uint64) {
+	c.flush(0)
+	handler := instructions[c.code[pc].OpCode]
+	c.emit(pc, nil, func(ins *Instance, _ *Frame) (int, error) {
+		return closureNext, handler(ins)
+	})
+}
+
+// jump returns the step to be resolved for the jump to the target
+func (c *closureCompiler) jump(target uint64) *int {
+	step := new(int)
+	c.fixups = append(c.fixups, closureFixup{step: step, target: target})
+
+	return step
+}
+
+func peak(heights []int) (ret int) {
+	for _, h := range heights {
+		if h > ret {
+			ret = h
+		}
+	}
+
+	return ret
+}
+
+// execClosures runs the closure steps of the active frame,
+// the tolls, limits and traps are same to the ones of the execFunc per instr
+func (ins *Instance) execClosures() error {
+	fr := ins.Active
+	steps := fr.Func.steps
+	for i := 0; i < len(steps); {
+		if atomic.LoadUint32(&ins.interrupted) != 0 {
+			return ErrInterrupted
+		}
+
+		s := &steps[i]
+		if len(s.prefix) > 0 && (ins.TollStation != nil || ins.OperandStackLimit != nil) {
+			if err := ins.chargePrefix(s); err != nil {
+				return err
+			}
+		}
+
+		fr.PC = s.last
+		op := fr.Func.code[s.last].OpCode
+		jump, err := s.run(ins, fr)
+		if err != nil {
+			return ins.trapOf(err, op)
+		}
+
+		if ins.OperandStackLimit != nil && uint64(ins.OperandStack.Ptr+1) > *ins.OperandStackLimit {
+			return ins.newTrap(ErrOperandStackExhausted, op)
+		}
+
+		if ins.TollStation != nil {
+			err := ins.TollStation.AddToll(ins.TollStation.GetOpPrice(op))
+			if err != nil {
+				return err
+			}
+		}
+
+		if jump == closureNext {
+			i++
+		} else {
+			i = jump
+		}
+	}
+
+	return nil
+}
+
+// chargePrefix checks the operand stack limit and charges the toll of the fused instrs
+// in the order of the interpreter, before the step is run
+func (ins *Instance) chargePrefix(s *closureStep) error {
+	height := ins.OperandStack.Ptr + 1
+	if ins.TollStation == nil && uint64(height+s.peak) <= *ins.OperandStackLimit {
+		return nil
+	}
+
+	fr := ins.Active
+	for j, pc := range s.prefix {
+		op := fr.Func.code[pc].OpCode
+		if ins.OperandStackLimit != nil && uint64(height+s.heights[j]) > *ins.OperandStackLimit {
+			fr.PC = pc
+			return ins.newTrap(ErrOperandStackExhausted, op)
+		}
+
+		if ins.TollStation != nil {
+			err := ins.TollStation.AddToll(ins.TollStation.GetOpPrice(op))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func boolToUint64(b bool) uint64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// closureBinaryOps are the binary instrs computed without the operand stack,
+// which are same to their instructions
+var closureBinaryOps = map[expr.OpCode]func(v1, v2 uint64) uint64{
+	expr.OpCodeI32Eq:   func(v1, v2 uint64) uint64 { return boolToUint64(v1 == v2) },
+	expr.OpCodeI32Ne:   func(v1, v2 uint64) uint64 { return boolToUint64(v1 != v2) },
+	expr.OpCodeI32LtS:  func(v1, v2 uint64) uint64 { return boolToUint64(int32(v1) < int32(v2)) },
+	expr.OpCodeI32LtU:  func(v1, v2 uint64) uint64 { return boolToUint64(uint32(v1) < uint32(v2)) },
+	expr.OpCodeI32GtS:  func(v1, v2 uint64) uint64 { return boolToUint64(int32(v1) > int32(v2)) },
+	expr.OpCodeI32GtU:  func(v1, v2 uint64) uint64 { return boolToUint64(uint32(v1) > uint32(v2)) },
+	expr.OpCodeI32LeS:  func(v1, v2 uint64) uint64 { return boolToUint64(int32(v1) <= int32(v2)) },
+	expr.OpCodeI32LeU:  func(v1, v2 uint64) uint64 { return boolToUint64(uint32(v1) <= uint32(v2)) },
+	expr.OpCodeI32GeS:  func(v1, v2 uint64) uint64 { return boolToUint64(int32(v1) >= int32(v2)) },
+	expr.OpCodeI32GeU:  func(v1, v2 uint64) uint64 { return boolToUint64(uint32(v1) >= uint32(v2)) },
+	expr.OpCodeI32Add:  func(v1, v2 uint64) uint64 { return uint64(int32(v1) + int32(v2)) },
+	expr.OpCodeI32Sub:  func(v1, v2 uint64) uint64 { return uint64(int32(v1) - int32(v2)) },
+	expr.OpCodeI32Mul:  func(v1, v2 uint64) uint64 { return uint64(int32(v1) * int32(v2)) },
+	expr.OpCodeI32And:  func(v1, v2 uint64) uint64 { return uint64(uint32(v1) & uint32(v2)) },
+	expr.OpCodeI32Or:   func(v1, v2 uint64) uint64 { return uint64(uint32(v1) | uint32(v2)) },
+	expr.OpCodeI32Xor:  func(v1, v2 uint64) uint64 { return uint64(uint32(v1) ^ uint32(v2)) },
+	expr.OpCodeI32Shl:  func(v1, v2 uint64) uint64 { return uint64(uint32(v1) << (uint32(v2) % 32)) },
+	expr.OpCodeI32ShrS: func(v1, v2 uint64) uint64 { return uint64(int32(v1) >> (uint32(v2) % 32)) },
+	expr.OpCodeI32ShrU: func(v1, v2 uint64) uint64 { return uint64(uint32(v1) >> (uint32(v2) % 32)) },
+	expr.OpCodeI32RotL: func(v1, v2 uint64) uint64 { return uint64(bits.RotateLeft32(uint32(v1), int(v2))) },
+	expr.OpCodeI64Eq:   func(v1, v2 uint64) uint64 { return boolToUint64(v1 == v2) },
+	expr.OpCodeI64Ne:   func(v1, v2 uint64) uint64 { return boolToUint64(v1 != v2) },
+	expr.OpCodeI64LtS:  func(v1, v2 uint64) uint64 { return boolToUint64(int64(v1) < int64(v2)) },
+	expr.OpCodeI64LtU:  func(v1, v2 uint64) uint64 { return boolToUint64(v1 < v2) },
+	expr.OpCodeI64GtS:  func(v1, v2 uint64) uint64 { return boolToUint64(int64(v1) > int64(v2)) },
+	expr.OpCodeI64LeS:  func(v1, v2 uint64) uint64 { return boolToUint64(int64(v1) <= int64(v2)) },
+	expr.OpCodeI64LeU:  func(v1, v2 uint64) uint64 { return boolToUint64(v1 <= v2) },
+	expr.OpCodeI64GeS:  func(v1, v2 uint64) uint64 { return boolToUint64(int64(v1) >= int64(v2)) },
+	expr.OpCodeI64GeU:  func(v1, v2 uint64) uint64 { return boolToUint64(v1 >= v2) },
+	expr.OpCodeI64Add:  func(v1, v2 uint64) uint64 { return v1 + v2 },
+	expr.OpCodeI64Sub:  func(v1, v2 uint64) uint64 { return v1 - v2 },
+	expr.OpCodeI64Mul:  func(v1, v2 uint64) uint64 { return v1 * v2 },
+	expr.OpCodeI64And:  func(v1, v2 uint64) uint64 { return v1 & v2 },
+	expr.OpCodeI64Or:   func(v1, v2 uint64) uint64 { return v1 | v2 },
+	expr.OpCodeI64Xor:  func(v1, v2 uint64) uint64 { return v1 ^ v2 },
+	expr.OpCodeI64Shl:  func(v1, v2 uint64) uint64 { return v1 << (v2 % 64) },
+	expr.OpCodeI64ShrS: func(v1, v2 uint64) uint64 { return uint64(int64(v1) >> (v2 % 64)) },
+	expr.OpCodeI64ShrU: func(v1, v2 uint64) uint64 { return v1 >> (v2 % 64) },
+}
@@ -0,0 +1,174 @@
+package wasm
+
+import (
+	"testing"
+
+	"github.com/hybridgroup/wasman/config"
+	"github.com/hybridgroup/wasman/expr"
+	"github.com/hybridgroup/wasman/segments"
+	"github.com/hybridgroup/wasman/stacks"
+	"github.com/hybridgroup/wasman/tollstation"
+	"github.com/hybridgroup/wasman/types"
+)
+
+func Test_closureBinaryOps(t *testing.T) {
+	values := []uint64{0, 1, 2, 31, 32, 63, 64, 0x7fffffff, 0x80000000, 0xffffffff,
+		0xffffffff80000000, 0x7fffffffffffffff, 0x8000000000000000, 0xffffffffffffffff}
+
+	ins := &Instance{OperandStack: stacks.NewOperandStack()}
+	for op, f := range closureBinaryOps {
+		for _, v1 := range values {
+			for _, v2 := range values {
+				ins.OperandStack.Push(v1)
+				ins.OperandStack.Push(v2)
+				if err := instructions[op](ins); err != nil {
+					t.Fatal(err)
+				}
+				if want, got := ins.OperandStack.Pop(), f(v1, v2); got != want {
+					t.Logf("%s(%#x, %#x) = %#x, want %#x", expr.GetOpCodeName(op), v1, v2, got, want)
+					t.Fail()
+				}
+			}
+		}
+	}
+}
+
+// engineModule returns the module running the same funcs on the engine
+func engineModule(engine config.Engine, stackLimit *uint64, maxToll uint64) *Module {
+	un := &types.FuncType{InputTypes: []types.ValueType{types.ValueTypeI32}, ReturnTypes: []types.ValueType{types.ValueTypeI32}}
+	bin := &types.FuncType{InputTypes: []types.ValueType{types.ValueTypeI32, types.ValueTypeI32}, ReturnTypes: []types.ValueType{types.ValueTypeI32}}
+	export := func(name string, idx uint32) *segments.ExportSegment {
+		return &segments.ExportSegment{Name: name, Desc: &segments.ExportDesc{Kind: segments.KindFunction, Index: idx}}
+	}
+
+	mod := &Module{
+		TypeSection:     []*types.FuncType{un, bin},
+		FunctionSection: []uint32{0, 0, 0, 1, 0, 1, 1},
+		CodeSection: []*segments.CodeSegment{
+			// sum: the loop adding the n, n-1 ... 1 to the local
+			{NumLocals: 1, LocalTypes: []types.ValueType{types.ValueTypeI32}, Body: []byte{
+				0x02, 0x40, 0x03, 0x40, 0x20, 0x00, 0x45, 0x0d, 0x01,
+				0x20, 0x01, 0x20, 0x00, 0x6a, 0x21, 0x01,
+				0x20, 0x00, 0x41, 0x01, 0x6b, 0x21, 0x00, 0x0c, 0x00, 0x0b, 0x0b,
+				0x20, 0x01, 0x0b,
+			}},
+			// fib: the recursive calls in the if else
+			{Body: []byte{
+				0x20, 0x00, 0x41, 0x02, 0x48, 0x04, 0x7f, 0x20, 0x00, 0x05,
+				0x20, 0x00, 0x41, 0x01, 0x6b, 0x10, 0x01,
+				0x20, 0x00, 0x41, 0x02, 0x6b, 0x10, 0x01, 0x6a, 0x0b, 0x0b,
+			}},
+			// switch: the br_table returning 10, 20 or 30 by default
+			{Body: []byte{
+				0x02, 0x40, 0x02, 0x40, 0x02, 0x40, 0x20, 0x00, 0x0e, 0x02, 0x00, 0x01, 0x02, 0x0b,
+				0x41, 0x0a, 0x0f, 0x0b, 0x41, 0x14, 0x0f, 0x0b, 0x41, 0x1e, 0x0b,
+			}},
+			// div: x / y + 1
+			{Body: []byte{0x20, 0x00, 0x20, 0x01, 0x6d, 0x41, 0x01, 0x6a, 0x0b}},
+			// deep: x + (1 + (2 + 3)) with 4 values on the stack
+			{Body: []byte{0x20, 0x00, 0x41, 0x01, 0x41, 0x02, 0x41, 0x03, 0x6a, 0x6a, 0x6a, 0x0b}},
+			// remu: x %u y + 1
+			{Body: []byte{0x20, 0x00, 0x20, 0x01, 0x70, 0x41, 0x01, 0x6a, 0x0b}},
+			// shrs: i32.wrap(i64(x) >> i64(y))
+			{Body: []byte{0x20, 0x00, 0xac, 0x20, 0x01, 0xac, 0x87, 0xa7, 0x0b}},
+		},
+		ExportSection: map[string]*segments.ExportSegment{
+			"sum": export("sum", 0), "fib": export("fib", 1), "switch": export("switch", 2),
+			"div": export("div", 3), "deep": export("deep", 4), "remu": export("remu", 5), "shrs": export("shrs", 6),
+		},
+	}
+	mod.Engine = engine
+	mod.OperandStackLimit = stackLimit
+	mod.TollStation = tollstation.NewSimpleTollStation(maxToll)
+
+	return mod
+}
+
+func TestInstance_CallExportedFunc_engines(t *testing.T) {
+	stackLimit := uint64(3)
+	for _, c := range []struct {
+		name       string
+		args       []uint64
+		stackLimit *uint64
+		maxToll    uint64
+		want       uint64
+	}{
+		{name: "sum", args: []uint64{100}, want: 5050},
+		{name: "sum", args: []uint64{100}, maxToll: 500},
+		{name: "fib", args: []uint64{15}, want: 610},
+		{name: "switch", args: []uint64{0}, want: 10},
+		{name: "switch", args: []uint64{1}, want: 20},
+		{name: "switch", args: []uint64{7}, want: 30},
+		{name: "div", args: []uint64{7, 2}, want: 4},
+		{name: "div", args: []uint64{7, 0}},
+		{name: "deep", args: []uint64{1}, want: 7},
+		{name: "deep", args: []uint64{1}, stackLimit: &stackLimit},
+		{name: "remu", args: []uint64{7, 3}, want: 2},
+		{name: "remu", args: []uint64{7, 0}},
+		{name: "shrs", args: []uint64{64, 2}, want: 16},
+		{name: "shrs", args: []uint64{0xfffffff8, 0xffffffff}, want: 0xffffffff}, // the negative count is taken modulo 64
+	} {
+		var results [2][]uint64
+		var errs [2]string
+		var tolls [2]uint64
+		for i, engine := range []config.Engine{config.EngineInterpreter, config.EngineClosure} {
+			mod := engineModule(engine, c.stackLimit, c.maxToll)
+			vm, err := NewInstance(mod, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			results[i], _, err = vm.CallExportedFunc(c.name, c.args...)
+			if err != nil {
+				errs[i] = err.Error()
+			}
+			tolls[i] = mod.TollStation.GetToll()
+
+			if vm.OperandStack.Ptr != -1 || vm.FrameStack.Ptr != -1 {
+				t.Logf("%s%v on engine %d: operand stack %v, frame stack %v", c.name, c.args, engine, vm.OperandStack.Ptr, vm.FrameStack.Ptr)
+				t.Fail()
+			}
+		}
+
+		if errs[0] != errs[1] || tolls[0] != tolls[1] {
+			t.Logf("%s%v: errors %q, tolls %v", c.name, c.args, errs, tolls)
+			t.Fail()
+		}
+		if errs[0] == "" && (len(results[0]) != 1 || len(results[1]) != 1 || results[0][0] != c.want || results[1][0] != c.want) {
+			t.Logf("%s%v: results %v, want %v", c.name, c.args, results, c.want)
+			t.Fail()
+		}
+		if errs[0] == "" && c.want == 0 {
+			t.Logf("%s%v: no error", c.name, c.args)
+			t.Fail()
+		}
+	}
+}
+
+func BenchmarkInstance_engines(b *testing.B) {
+	for _, c := range []struct {
+		name string
+		arg  uint64
+	}{{"fib", 20}, {"sum", 100000}} {
+		for _, engine := range []struct {
+			name   string
+			engine config.Engine
+		}{{"interpreter", config.EngineInterpreter}, {"closure", config.EngineClosure}} {
+			b.Run(c.name+"/"+engine.name, func(b *testing.B) {
+				mod := engineModule(engine.engine, nil, 0)
+				mod.TollStation = nil
+				vm, err := NewInstance(mod, nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, _, err := vm.CallExportedFunc(c.name, c.arg); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
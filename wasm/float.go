@@ -0,0 +1,98 @@
+package wasm
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hybridgroup/wasman/expr"
+	"github.com/hybridgroup/wasman/segments"
+	"github.com/hybridgroup/wasman/types"
+)
+
+// ErrFloatPointDisabled will be throw when the module uses the float point with the ModuleConfig.DisableFloatPoint
+var ErrFloatPointDisabled = errors.New("float point is disabled")
+
+// checkFloatPoint returns ErrFloatPointDisabled when any signature, global, local or instruction of the module uses the float point
+func (m *Module) checkFloatPoint() error {
+	for i, ft := range m.TypeSection {
+		if hasFloatType(ft.InputTypes) || hasFloatType(ft.ReturnTypes) {
+			return fmt.Errorf("%w: type[%d]", ErrFloatPointDisabled, i)
+		}
+	}
+
+	numImportedFuncs := uint32(0)
+	for _, imp := range m.ImportSection {
+		switch imp.Desc.Kind {
+		case segments.KindFunction:
+			numImportedFuncs++
+		case segments.KindGlobal:
+			if isFloatType(imp.Desc.GlobalTypePtr.ValType) {
+				return fmt.Errorf("%w: global %s.%s", ErrFloatPointDisabled, imp.Module, imp.Name)
+			}
+		}
+	}
+
+	for i, g := range m.GlobalSection {
+		if isFloatType(g.Type.ValType) || g.Init.OpCode == expr.OpCodeF32Const || g.Init.OpCode == expr.OpCodeF64Const {
+			return fmt.Errorf("%w: global[%d]", ErrFloatPointDisabled, i)
+		}
+	}
+
+	for i, c := range m.CodeSection {
+		idx := numImportedFuncs + uint32(i)
+		name, _ := m.FunctionName(idx)
+		if hasFloatType(c.LocalTypes) {
+			return fmt.Errorf("%w: locals of %s", ErrFloatPointDisabled, funcString(idx, name))
+		}
+
+		for pc := uint64(0); pc < uint64(len(c.Body)); {
+			in, err := decodeInstruction(c.Body, pc)
+			if err != nil {
+				return fmt.Errorf("%s: %w", funcString(idx, name), err)
+			}
+
+			if in.usesFloat() {
+				return fmt.Errorf("%w: %s at %#x of %s", ErrFloatPointDisabled, expr.GetOpCodeName(in.OpCode), pc, funcString(idx, name))
+			}
+
+			pc = in.Next
+		}
+	}
+
+	return nil
+}
+
+// usesFloat reports whether the instruction takes or returns a float
+func (in *instruction) usesFloat() bool {
+	switch op := in.OpCode; {
+	case op == expr.OpCodeF32Load || op == expr.OpCodeF64Load ||
+		op == expr.OpCodeF32Store || op == expr.OpCodeF64Store ||
+		op == expr.OpCodeF32Const || op == expr.OpCodeF64Const:
+		return true
+	case expr.OpCodeF32Eq <= op && op <= expr.OpCodeF64Ge, // comparisons
+		expr.OpCodeF32Abs <= op && op <= expr.OpCodeF64CopySign, // arithmetics
+		expr.OpCodeI32TruncF32S <= op && op <= expr.OpCodeI32truncF64U,
+		expr.OpCodeI64TruncF32S <= op && op <= expr.OpCodeF64ReinterpretI64: // conversions
+		return true
+	case op == expr.OpCodeBulkMemory:
+		return in.SubCode <= 0x07 // trunc_sat
+	case op == expr.OpCodeBlock || op == expr.OpCodeLoop || op == expr.OpCodeIf:
+		return in.BlockType == -3 || in.BlockType == -4 // f32, f64
+	}
+
+	return false
+}
+
+func isFloatType(vt types.ValueType) bool {
+	return vt == types.ValueTypeF32 || vt == types.ValueTypeF64
+}
+
+func hasFloatType(vts []types.ValueType) bool {
+	for _, vt := range vts {
+		if isFloatType(vt) {
+			return true
+		}
+	}
+
+	return false
+}
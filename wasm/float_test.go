@@ -0,0 +1,59 @@
+package wasm
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/hybridgroup/wasman/expr"
+	"github.com/hybridgroup/wasman/segments"
+	"github.com/hybridgroup/wasman/types"
+	"github.com/hybridgroup/wasman/utils"
+)
+
+func TestModule_checkFloatPoint(t *testing.T) {
+	i32, f32 := types.ValueTypeI32, types.ValueTypeF32
+	body := func(b ...byte) []*segments.CodeSegment {
+		return []*segments.CodeSegment{{Body: b}}
+	}
+
+	ok := &Module{
+		TypeSection:     []*types.FuncType{{InputTypes: []types.ValueType{i32}}},
+		FunctionSection: []uint32{0},
+		// the f32 opcodes in the immediates are not the instructions
+		CodeSection: body(expr.OpCodeI32Const, expr.OpCodeF32Add, expr.OpCodeDrop, expr.OpCodeBlock, 0x40, expr.OpCodeEnd),
+	}
+	if err := ok.checkFloatPoint(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, c := range []struct {
+		m   *Module
+		msg string
+	}{
+		{m: &Module{TypeSection: []*types.FuncType{{ReturnTypes: []types.ValueType{f32}}}}, msg: "type[0]"},
+		{m: &Module{GlobalSection: []*segments.GlobalSegment{
+			{Type: &types.GlobalType{ValType: f32}, Init: &expr.Expression{OpCode: expr.OpCodeF32Const}},
+		}}, msg: "global[0]"},
+		{m: &Module{ImportSection: []*segments.ImportSegment{
+			{Module: "env", Name: "g", Desc: &segments.ImportDesc{Kind: segments.KindGlobal, GlobalTypePtr: &types.GlobalType{ValType: f32}}},
+		}}, msg: "global env.g"},
+		{m: &Module{CodeSection: []*segments.CodeSegment{{NumLocals: 1, LocalTypes: []types.ValueType{f32}}}}, msg: "locals of func[0]"},
+		{m: &Module{
+			ImportSection: []*segments.ImportSegment{
+				{Module: "env", Name: "f", Desc: &segments.ImportDesc{Kind: segments.KindFunction, TypeIndexPtr: utils.Uint32Ptr(0)}},
+			},
+			CodeSection: body(expr.OpCodeI32Const, 0x01, expr.OpCodeF32ConvertI32S, expr.OpCodeDrop),
+		}, msg: "F32ConvertI32S at 0x2 of func[1]"},
+		{m: &Module{CodeSection: body(expr.OpCodeBlock, 0x7d, expr.OpCodeUnreachable, expr.OpCodeEnd)}, msg: "Block at 0x0 of func[0]"},
+		{m: &Module{CodeSection: body(expr.OpCodeBulkMemory, 0x00)}, msg: "at 0x0 of func[0]"},
+	} {
+		t.Run(utils.IntToString(i), func(t *testing.T) {
+			err := c.m.checkFloatPoint()
+			if !errors.Is(err, ErrFloatPointDisabled) || !strings.Contains(err.Error(), c.msg) {
+				t.Logf("err: %v", err)
+				t.Fail()
+			}
+		})
+	}
+}
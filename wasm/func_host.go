@@ -10,6 +10,10 @@ import (
 // as the expected Go types.
 type RawHostFunc = func([]uint64) []uint64
 
+// RawHostFuncWithError is a RawHostFunc which can fail,
+// the returned error aborts the execution of the guest.
+type RawHostFuncWithError = func([]uint64) ([]uint64, error)
+
 // HostFunc is an implement of wasm.Fn,
 // which represents all the functions defined under host(golang) environment
 type HostFunc struct {
@@ -19,20 +23,51 @@ type HostFunc struct {
 	// (generate when NewInstance's func initializing
 	Generator func(ins *Instance) RawHostFunc
 
+	// GeneratorWithError is same to the Generator but generates a RawHostFuncWithError,
+	// it is used when the Generator is nil
+	GeneratorWithError func(ins *Instance) RawHostFuncWithError
+
 	// function is the generated func from Generator, should be set at the time of wasm instance creation
-	function RawHostFunc
+	function RawHostFuncWithError
+}
+
+// generate sets the function generated for the instance
+func (f *HostFunc) generate(ins *Instance) {
+	if f.Generator == nil {
+		f.function = f.GeneratorWithError(ins)
+		return
+	}
+
+	raw := f.Generator(ins)
+	f.function = func(args []uint64) ([]uint64, error) {
+		return raw(args), nil
+	}
 }
 
 func (f *HostFunc) getType() *types.FuncType {
 	return f.Signature
 }
 
-func (f *HostFunc) call(ins *Instance) error {
+func (f *HostFunc) call(ins *Instance) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			exit, ok := v.(*ExitError)
+			if !ok {
+				panic(v)
+			}
+			err = exit
+		}
+	}()
+
 	args := make([]uint64, len(f.Signature.InputTypes))
 	for i := len(args) - 1; i >= 0; i-- {
 		args[i] = ins.OperandStack.Pop()
 	}
-	results := f.function(args)
+	results, err := f.function(args)
+	if err != nil {
+		return err
+	}
+
 	for _, val := range results {
 		ins.OperandStack.Push(val)
 	}
@@ -1,18 +1,24 @@
 package wasm
 
 import (
+	"context"
+	"errors"
+	"runtime"
 	"testing"
+	"time"
 
 	"github.com/hybridgroup/wasman/expr"
+	"github.com/hybridgroup/wasman/segments"
 	"github.com/hybridgroup/wasman/stacks"
 	"github.com/hybridgroup/wasman/types"
+	"github.com/hybridgroup/wasman/utils"
 )
 
 func TestHostFunction_Call(t *testing.T) {
 	var cnt uint64
-	f := func(in []uint64) []uint64 {
+	f := func(in []uint64) ([]uint64, error) {
 		cnt += in[0]
-		return []uint64{1, 2, 3, 4}
+		return []uint64{1, 2, 3, 4}, nil
 	}
 	hf := &HostFunc{
 		function: f,
@@ -57,9 +63,9 @@ func TestHostFunction_Call(t *testing.T) {
 func TestNativeFunction_Call(t *testing.T) {
 	n := &wasmFunc{
 		signature: &types.FuncType{},
-		body: []byte{
+		code: compiled(
 			byte(expr.OpCodeI64Const), 0x05, byte(expr.OpCodeReturn),
-		},
+		),
 	}
 	vm := &Instance{
 		Module:       new(Module),
@@ -86,11 +92,11 @@ func TestNativeFunction_Call(t *testing.T) {
 func TestVirtualMachine_execNativeFunction(t *testing.T) {
 	n := &wasmFunc{
 		signature: &types.FuncType{},
-		body: []byte{
+		code: compiled(
 			byte(expr.OpCodeI64Const), 0x05,
 			byte(expr.OpCodeI64Const), 0x01,
 			byte(expr.OpCodeReturn),
-		},
+		),
 	}
 	vm := &Instance{
 		Module:       new(Module),
@@ -103,7 +109,7 @@ func TestVirtualMachine_execNativeFunction(t *testing.T) {
 	if vm.execFunc() != nil {
 		t.Fail()
 	}
-	if vm.Active.PC != 4 {
+	if vm.Active.PC != 2 {
 		t.Fail()
 	}
 	if vm.OperandStack.Pop() != 0x01 {
@@ -113,3 +119,312 @@ func TestVirtualMachine_execNativeFunction(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestInstance_CallExportedFunc_exit(t *testing.T) {
+	exit := &HostFunc{
+		Signature: &types.FuncType{InputTypes: []types.ValueType{types.ValueTypeI32}},
+		function: func(in []uint64) ([]uint64, error) {
+			panic(&ExitError{Code: uint32(in[0])})
+		},
+	}
+	main := &wasmFunc{
+		signature: &types.FuncType{},
+		code: compiled(
+			byte(expr.OpCodeI32Const), 0x07, byte(expr.OpCodeI32Const), 0x03, byte(expr.OpCodeCall), 0x00,
+		),
+	}
+	vm := &Instance{
+		Module: &Module{
+			ExportSection: map[string]*segments.ExportSegment{
+				"main": {Name: "main", Desc: &segments.ExportDesc{Kind: segments.KindFunction, Index: 1}},
+			},
+		},
+		Functions:    []fn{exit, main},
+		OperandStack: stacks.NewOperandStack(),
+		FrameStack: &stacks.Stack[*Frame]{
+			Ptr:    -1,
+			Values: make([]*Frame, stacks.InitialLabelStackHeight),
+		},
+	}
+
+	_, _, err := vm.CallExportedFunc("main")
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.Code != 3 {
+		t.Logf("call error: %v", err)
+		t.Fail()
+	}
+
+	// every frame should be unwound
+	if vm.OperandStack.Ptr != -1 || vm.FrameStack.Ptr != -1 || vm.Active != nil {
+		t.Logf("operand stack %v, frame stack %v", vm.OperandStack.Ptr, vm.FrameStack.Ptr)
+		t.Fail()
+	}
+}
+
+func TestInstance_CallExportedFuncContext(t *testing.T) {
+	mod := &Module{
+		TypeSection:     []*types.FuncType{{}},
+		FunctionSection: []uint32{0},
+		CodeSection: []*segments.CodeSegment{
+			{Body: []byte{
+				byte(expr.OpCodeLoop), 0x40,
+				byte(expr.OpCodeBr), 0x00,
+				byte(expr.OpCodeEnd),
+				byte(expr.OpCodeEnd),
+			}},
+		},
+		ExportSection: map[string]*segments.ExportSegment{
+			"loop": {Name: "loop", Desc: &segments.ExportDesc{Kind: segments.KindFunction, Index: 0}},
+		},
+	}
+	vm, err := NewInstance(mod, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, _, err = vm.CallExportedFuncContext(ctx, "loop")
+	if !errors.Is(err, ErrInterrupted) {
+		t.Logf("call error: %v", err)
+		t.Fail()
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		vm.Interrupt()
+	}()
+	_, _, err = vm.CallExportedFunc("loop")
+	if err != ErrInterrupted {
+		t.Logf("call error: %v", err)
+		t.Fail()
+	}
+
+	if vm.OperandStack.Ptr != -1 || vm.FrameStack.Ptr != -1 {
+		t.Logf("operand stack %v, frame stack %v", vm.OperandStack.Ptr, vm.FrameStack.Ptr)
+		t.Fail()
+	}
+}
+
+func TestInstance_CallExportedFuncContext_nested(t *testing.T) {
+	mod := &Module{
+		TypeSection:     []*types.FuncType{{}, {ReturnTypes: []types.ValueType{types.ValueTypeI32}}},
+		FunctionSection: []uint32{1, 0, 0},
+		CodeSection: []*segments.CodeSegment{
+			{Body: []byte{byte(expr.OpCodeCall), 0x01, byte(expr.OpCodeI32Const), 0x07, byte(expr.OpCodeEnd)}},
+			{Body: []byte{byte(expr.OpCodeEnd)}},
+			{Body: []byte{
+				byte(expr.OpCodeLoop), 0x40,
+				byte(expr.OpCodeBr), 0x00,
+				byte(expr.OpCodeEnd),
+				byte(expr.OpCodeEnd),
+			}},
+		},
+		ExportSection: map[string]*segments.ExportSegment{
+			"main": {Name: "main", Desc: &segments.ExportDesc{Kind: segments.KindFunction, Index: 0}},
+			"loop": {Name: "loop", Desc: &segments.ExportDesc{Kind: segments.KindFunction, Index: 2}},
+		},
+	}
+	vm, err := NewInstance(mod, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the nested call timed out does not stop the outer one
+	err = vm.SetFunc(1, func([]uint64) ([]uint64, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if _, _, err := vm.CallExportedFuncContext(ctx, "loop"); !errors.Is(err, ErrInterrupted) {
+			t.Logf("nested call error: %v", err)
+			t.Fail()
+		}
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ret, _, err := vm.CallExportedFuncContext(ctx, "main")
+	if err != nil || len(ret) != 1 || ret[0] != 7 {
+		t.Logf("call: %v, %v", ret, err)
+		t.Fail()
+	}
+}
+
+func TestInstance_CallExportedFunc_limits(t *testing.T) {
+	depthLimit, stackLimit := uint64(100), uint64(3)
+	mod := &Module{
+		TypeSection:     []*types.FuncType{{}},
+		FunctionSection: []uint32{0, 0},
+		CodeSection: []*segments.CodeSegment{
+			{Body: []byte{byte(expr.OpCodeCall), 0x00, byte(expr.OpCodeEnd)}},
+			{Body: []byte{
+				byte(expr.OpCodeI32Const), 0x01,
+				byte(expr.OpCodeI32Const), 0x02,
+				byte(expr.OpCodeI32Const), 0x03,
+				byte(expr.OpCodeI32Const), 0x04,
+				byte(expr.OpCodeEnd),
+			}},
+		},
+		ExportSection: map[string]*segments.ExportSegment{
+			"recurse": {Name: "recurse", Desc: &segments.ExportDesc{Kind: segments.KindFunction, Index: 0}},
+			"push":    {Name: "push", Desc: &segments.ExportDesc{Kind: segments.KindFunction, Index: 1}},
+		},
+	}
+	mod.CallDepthLimit = &depthLimit
+	mod.OperandStackLimit = &stackLimit
+
+	vm, err := NewInstance(mod, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err = vm.CallExportedFunc("recurse"); !errors.Is(err, ErrCallStackExhausted) {
+		t.Logf("call error: %v", err)
+		t.Fail()
+	}
+
+	if _, _, err = vm.CallExportedFunc("push"); !errors.Is(err, ErrOperandStackExhausted) {
+		t.Logf("call error: %v", err)
+		t.Fail()
+	}
+
+	if vm.OperandStack.Ptr != -1 || vm.FrameStack.Ptr != -1 {
+		t.Logf("operand stack %v, frame stack %v", vm.OperandStack.Ptr, vm.FrameStack.Ptr)
+		t.Fail()
+	}
+}
+
+func TestInstance_CallExportedFunc_trap(t *testing.T) {
+	mod := &Module{
+		TypeSection:     []*types.FuncType{{}},
+		FunctionSection: []uint32{0, 0},
+		CodeSection: []*segments.CodeSegment{
+			{Body: []byte{byte(expr.OpCodeNop), byte(expr.OpCodeCall), 0x01, byte(expr.OpCodeEnd)}},
+			{Body: []byte{byte(expr.OpCodeNop), byte(expr.OpCodeUnreachable), byte(expr.OpCodeEnd)}},
+		},
+		ExportSection: map[string]*segments.ExportSegment{
+			"main": {Name: "main", Desc: &segments.ExportDesc{Kind: segments.KindFunction, Index: 0}},
+		},
+		NameSection: &segments.NameSection{FunctionNames: map[uint32]string{1: "crash"}},
+	}
+	vm, err := NewInstance(mod, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = vm.CallExportedFunc("main")
+	if !errors.Is(err, ErrUnreachable) {
+		t.Logf("call error: %v", err)
+		t.Fail()
+	}
+
+	var trap *Trap
+	if !errors.As(err, &trap) {
+		t.FailNow()
+	}
+	if trap.OpCode != expr.OpCodeUnreachable || trap.FuncIndex != 1 || trap.FuncName != "crash" || trap.PC != 1 {
+		t.Logf("trap: %v", trap)
+		t.Fail()
+	}
+	if len(trap.Frames) != 2 || trap.Frames[0].String() != "crash[1] at pc 1" || trap.Frames[1].String() != "func[0] at pc 1" {
+		t.Logf("frames: %v", trap.Frames)
+		t.Fail()
+	}
+}
+
+func TestInstance_CallExportedFunc_recover(t *testing.T) {
+	mod := &Module{
+		TypeSection:     []*types.FuncType{{}},
+		FunctionSection: []uint32{0, 0, 0},
+		CodeSection: []*segments.CodeSegment{
+			{Body: []byte{byte(expr.OpCodeNop), byte(expr.OpCodeCall), 0x01, byte(expr.OpCodeEnd)}},
+			{Body: []byte{byte(expr.OpCodeNop), byte(expr.OpCodeNop), byte(expr.OpCodeCall), 0x02, byte(expr.OpCodeEnd)}},
+			{Body: []byte{byte(expr.OpCodeEnd)}},
+		},
+		ExportSection: map[string]*segments.ExportSegment{
+			"main": {Name: "main", Desc: &segments.ExportDesc{Kind: segments.KindFunction, Index: 0}},
+		},
+	}
+	mod.Recover = true
+
+	vm, err := NewInstance(mod, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the panic of the host func is trapped at the call of the func[1]
+	err = vm.SetFunc(2, func(args []uint64) ([]uint64, error) {
+		return args[:1], nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = vm.CallExportedFunc("main")
+	var trap *Trap
+	var runtimeErr runtime.Error
+	if !errors.As(err, &trap) || !errors.As(err, &runtimeErr) {
+		t.Fatalf("call error: %v", err)
+	}
+	if trap.OpCode != expr.OpCodeCall || trap.FuncIndex != 1 || trap.PC != 2 {
+		t.Logf("trap: %v", trap)
+		t.Fail()
+	}
+	if len(trap.Frames) != 2 || trap.Frames[0].String() != "func[1] at pc 2" || trap.Frames[1].String() != "func[0] at pc 1" {
+		t.Logf("frames: %v", trap.Frames)
+		t.Fail()
+	}
+
+	if vm.OperandStack.Ptr != -1 || vm.FrameStack.Ptr != -1 || vm.Active != nil {
+		t.Logf("operand stack %v, frame stack %v", vm.OperandStack.Ptr, vm.FrameStack.Ptr)
+		t.Fail()
+	}
+}
+
+func TestInstance_CallExportedFunc_importedTrap(t *testing.T) {
+	lib := &Module{
+		TypeSection:     []*types.FuncType{{}},
+		FunctionSection: []uint32{0},
+		CodeSection:     []*segments.CodeSegment{{Body: []byte{byte(expr.OpCodeUnreachable), byte(expr.OpCodeEnd)}}},
+		ExportSection: map[string]*segments.ExportSegment{
+			"crash": {Name: "crash", Desc: &segments.ExportDesc{Kind: segments.KindFunction, Index: 0}},
+		},
+		NameSection: &segments.NameSection{FunctionNames: map[uint32]string{0: "lib_crash"}},
+	}
+	libIns, err := NewInstance(lib, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mod := &Module{
+		TypeSection: []*types.FuncType{{}},
+		ImportSection: []*segments.ImportSegment{
+			{Module: "lib", Name: "crash", Desc: &segments.ImportDesc{Kind: segments.KindFunction, TypeIndexPtr: utils.Uint32Ptr(0)}},
+		},
+		FunctionSection: []uint32{0},
+		CodeSection:     []*segments.CodeSegment{{Body: []byte{byte(expr.OpCodeCall), 0x00, byte(expr.OpCodeEnd)}}},
+		ExportSection: map[string]*segments.ExportSegment{
+			"main": {Name: "main", Desc: &segments.ExportDesc{Kind: segments.KindFunction, Index: 1}},
+		},
+		NameSection: &segments.NameSection{FunctionNames: map[uint32]string{0: "imported", 1: "main"}},
+	}
+	vm, err := NewInstance(mod, map[string]*Module{"lib": {ExportSection: lib.ExportSection, IndexSpace: libIns.IndexSpace}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the imported func is named by the lib
+	_, _, err = vm.CallExportedFunc("main")
+	var trap *Trap
+	if !errors.As(err, &trap) {
+		t.Fatalf("call error: %v", err)
+	}
+	if trap.FuncName != "lib_crash" || len(trap.Frames) != 2 ||
+		trap.Frames[0].String() != "lib_crash[0] at pc 0" || trap.Frames[1].String() != "main[1] at pc 0" {
+		t.Logf("trap: %v, frames: %v", trap, trap.Frames)
+		t.Fail()
+	}
+}
@@ -3,31 +3,38 @@ package wasm
 import (
 	"fmt"
 
-	"github.com/hybridgroup/wasman/stacks"
+	"github.com/hybridgroup/wasman/expr"
 	"github.com/hybridgroup/wasman/types"
 )
 
 type wasmFunc struct {
-	signature *types.FuncType       // the shape of func (defined by inputs and outputs)
-	NumLocal  uint32                // index id in local
-	body      []byte                // body
-	Blocks    map[uint64]*funcBlock // instr blocks inside the func
-}
-
-type funcBlock struct {
-	StartAt uint64
-	ElseAt  uint64
-	EndAt   uint64
-
-	BlockType      *types.FuncType
-	BlockTypeBytes uint64
+	signature *types.FuncType // the shape of func (defined by inputs and outputs)
+	index     uint32          // index id in the function index space
+	name      string          // the name in the "name" section of the module defining the func, if any
+	NumLocal  uint32          // index id in local
+	body      []byte          // body
+	code      []instruction   // the body compiled at the instantiation
+	steps     []closureStep   // the code compiled by the closure engine, nil if not used
 }
 
 func (f *wasmFunc) getType() *types.FuncType {
 	return f.signature
 }
 
+// offset returns the offset in the body of the compiled instr at the pc
+func (f *wasmFunc) offset(pc uint64) uint64 {
+	if pc < uint64(len(f.code)) {
+		return f.code[pc].Offset
+	}
+
+	return uint64(len(f.body))
+}
+
 func (f *wasmFunc) call(ins *Instance) (err error) {
+	if ins.CallDepthLimit != nil && uint64(ins.FrameStack.Ptr+1) >= *ins.CallDepthLimit {
+		return ErrCallStackExhausted
+	}
+
 	al := len(f.signature.InputTypes)
 	locals := make([]uint64, f.NumLocal+uint32(al))
 	for i := 0; i < al; i++ {
@@ -35,30 +42,42 @@ func (f *wasmFunc) call(ins *Instance) (err error) {
 	}
 
 	prevPtr := ins.FrameStack.Ptr
+	prev := ins.Active
+	frame := &Frame{
+		Func:   f,
+		Locals: locals,
+	}
+	ins.FrameStack.Push(frame)
+	defer ins.FrameStack.Pop()
+	ins.Active = frame
+
 	if ins.Recover {
+		// the panic is trapped at the faulting instr of this frame before the frame is popped,
+		// the callees recover their own panics
 		defer func() {
 			if v := recover(); v != nil {
-				ins.FrameStack.Ptr = prevPtr
-				var ok bool
-				err, ok = v.(error)
+				ins.FrameStack.Ptr = prevPtr + 1
+				ins.Active = frame
+
+				perr, ok := v.(error)
 				if !ok {
-					err = fmt.Errorf("runtime error: %v", v)
+					perr = fmt.Errorf("runtime error: %v", v)
+				}
+
+				op := expr.OpCodeEnd
+				if frame.PC < uint64(len(f.code)) {
+					op = f.code[frame.PC].OpCode
 				}
+				err = ins.trapOf(perr, op)
 			}
 		}()
 	}
 
-	prev := ins.Active
-	frame := &Frame{
-		Func:       f,
-		Locals:     locals,
-		LabelStack: stacks.NewLabelStack(),
+	if f.steps != nil {
+		err = ins.execClosures()
+	} else {
+		err = ins.execFunc()
 	}
-	ins.FrameStack.Push(frame)
-	defer ins.FrameStack.Pop()
-	ins.Active = frame
-
-	err = ins.execFunc()
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,921 @@
+package wasm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/hybridgroup/wasman/config"
+	"github.com/hybridgroup/wasman/expr"
+	"github.com/hybridgroup/wasman/segments"
+	"github.com/hybridgroup/wasman/types"
+)
+
+// GenerateGo writes the Go source of the package pkg running the module encoded in the binary,
+// whose funcs are translated to the Go funcs ahead of time.
+// The generated Instantiate instantiates the module with the wasman.Linker as usual,
+// so the memory, tables, globals and imports are the ones of the instance,
+// and then replaces the funcs defined by the module with the Go ones by the SetFunc.
+// The funcs using the instrs not translated yet are left to the interpreter.
+// The Go funcs are not charged by the TollStation, and the OperandStackLimit does not apply to them.
+// The source is not formatted, e.g. the wasm2go command formats it with the go/format.
+func GenerateGo(w io.Writer, pkg string, binary []byte) error {
+	m, err := NewModule(config.ModuleConfig{}, bytes.NewReader(binary))
+	if err != nil {
+		return err
+	}
+
+	if err := m.Validate(); err != nil {
+		return err
+	}
+
+	ctx, err := m.newValidationContext()
+	if err != nil {
+		return err
+	}
+
+	g := &goGenerator{validationContext: ctx, translated: map[uint32]bool{}}
+	codes := make([][]instruction, len(m.CodeSection))
+	var skipped []string
+	for i, c := range m.CodeSection {
+		idx := ctx.numImportedFuncs + uint32(i)
+		codes[i], err = (&Instance{Module: m}).compile(c.Body)
+		if err != nil {
+			return fmt.Errorf("%s: %w", g.funcString(idx), err)
+		}
+
+		if in := goUnsupported(codes[i]); in != nil {
+			skipped = append(skipped, fmt.Sprintf("%s is run by the interpreter, %s at %#x is not translated",
+				g.funcString(idx), expr.GetOpCodeName(in.OpCode), in.Offset))
+			continue
+		}
+		g.translated[idx] = true
+	}
+
+	var funcs bytes.Buffer
+	for i := range m.CodeSection {
+		idx := ctx.numImportedFuncs + uint32(i)
+		if g.translated[idx] {
+			g.generateFunc(&funcs, idx, codes[i])
+		}
+	}
+
+	imports := []string{"fmt", "math"}
+	if bytes.Contains(funcs.Bytes(), []byte("bits.")) {
+		imports = append(imports, "math/bits")
+	}
+	imports = append(imports, "", "github.com/hybridgroup/wasman", "github.com/hybridgroup/wasman/config", "github.com/hybridgroup/wasman/wasm")
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by wasm2go. DO NOT EDIT.\n\npackage %s\n\nimport (\n", pkg)
+	for _, path := range imports {
+		if path == "" {
+			b.WriteString("\n")
+			continue
+		}
+		fmt.Fprintf(&b, "%q\n", path)
+	}
+	fmt.Fprintf(&b, ")\n\n// wasmBinary is the module translated\nvar wasmBinary = []byte(%q)\n", binary)
+	b.WriteString(goPrelude)
+
+	b.WriteString("\n// funcs returns the funcs replacing the ones of the instance\n")
+	for _, s := range skipped {
+		b.WriteString("// " + s + "\n")
+	}
+	b.WriteString("func (m *module) funcs() map[uint32]wasm.RawHostFuncWithError {\nreturn map[uint32]wasm.RawHostFuncWithError{\n")
+	for i := range m.CodeSection {
+		idx := ctx.numImportedFuncs + uint32(i)
+		if g.translated[idx] {
+			g.generateEntry(&b, idx)
+		}
+	}
+	b.WriteString("}\n}\n")
+	b.Write(funcs.Bytes())
+
+	_, err = w.Write(b.Bytes())
+	return err
+}
+
+// goPrelude is the part of the generated source shared by the modules
+const goPrelude = `
+// Instantiate instantiates the module with the linker,
+// the funcs defined by the module are run as the generated Go code
+func Instantiate(l *wasman.Linker, conf config.ModuleConfig) (*wasman.Instance, error) {
+	mod, err := wasman.NewModuleFromBytes(conf, wasmBinary)
+	if err != nil {
+		return nil, err
+	}
+
+	ins, err := l.Instantiate(mod)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &module{ins: ins, mem: ins.Memory, globals: ins.Globals, limit: math.MaxUint64}
+	if ins.CallDepthLimit != nil {
+		m.limit = *ins.CallDepthLimit
+	}
+
+	for idx, f := range m.funcs() {
+		if err := ins.SetFunc(idx, f); err != nil {
+			return nil, err
+		}
+	}
+
+	return ins, nil
+}
+
+// module runs the funcs of the instance as the Go code
+type module struct {
+	ins     *wasman.Instance
+	mem     *wasman.Memory
+	globals []uint64
+	depth   uint64 // the number of the frames of the running call
+	limit   uint64 // the CallDepthLimit of the instance
+}
+
+// fault is the panic of the generated funcs, which is returned as the error of the call
+type fault struct {
+	err error
+}
+
+// recover returns the fault as the err of the call and restores the depth,
+// the other panics are handled as the interpreter does
+func (m *module) recover(err *error, depth uint64) {
+	m.depth = depth
+
+	v := recover()
+	if v == nil {
+		return
+	}
+
+	if f, ok := v.(fault); ok {
+		*err = f.err
+		return
+	}
+
+	if !m.ins.Recover {
+		panic(v)
+	}
+
+	var ok bool
+	*err, ok = v.(error)
+	if !ok {
+		*err = fmt.Errorf("runtime error: %v", v)
+	}
+}
+
+// enter checks the limits before the generated func runs
+func (m *module) enter() {
+	if m.depth >= m.limit {
+		panic(fault{wasm.ErrCallStackExhausted})
+	}
+	m.poll()
+	m.depth++
+}
+
+// poll stops the interrupted call
+func (m *module) poll() {
+	if m.ins.Interrupted() {
+		panic(fault{wasm.ErrInterrupted})
+	}
+}
+
+// trap returns the fault of the trap raised by the instr at the pc of the func
+func (m *module) trap(err error, idx uint32, pc uint64, op byte) fault {
+	name, _ := m.ins.FunctionName(idx)
+	return fault{&wasm.Trap{Err: err, OpCode: op, FuncIndex: idx, FuncName: name, PC: pc}}
+}
+
+// call calls the func run by the instance, e.g. the imported ones
+func (m *module) call(idx uint32, args ...uint64) []uint64 {
+	ret, err := m.ins.CallFunc(idx, args...)
+	if err != nil {
+		panic(fault{err})
+	}
+
+	return ret
+}
+
+// indirect returns the func called by the call_indirect at the pc of the func
+func (m *module) indirect(typeIndex uint32, elem uint64, idx uint32, pc uint64) uint32 {
+	f, err := m.ins.IndirectFunc(typeIndex, elem)
+	if err != nil {
+		panic(m.trap(err, idx, pc, 0x11))
+	}
+
+	return f
+}
+
+func addr(base, offset uint64) (uint32, error) {
+	a := offset + uint64(uint32(base))
+	if a > math.MaxUint32 {
+		return 0, wasm.ErrPtrOutOfBounds
+	}
+
+	return uint32(a), nil
+}
+
+func (m *module) load8(base, offset uint64) (uint64, error) {
+	a, err := addr(base, offset)
+	if err != nil {
+		return 0, err
+	}
+
+	v, err := m.mem.ReadUint8(a)
+	return uint64(v), err
+}
+
+func (m *module) load16(base, offset uint64) (uint64, error) {
+	a, err := addr(base, offset)
+	if err != nil {
+		return 0, err
+	}
+
+	v, err := m.mem.ReadUint16(a)
+	return uint64(v), err
+}
+
+func (m *module) load32(base, offset uint64) (uint64, error) {
+	a, err := addr(base, offset)
+	if err != nil {
+		return 0, err
+	}
+
+	v, err := m.mem.ReadUint32(a)
+	return uint64(v), err
+}
+
+func (m *module) load64(base, offset uint64) (uint64, error) {
+	a, err := addr(base, offset)
+	if err != nil {
+		return 0, err
+	}
+
+	return m.mem.ReadUint64(a)
+}
+
+func (m *module) store8(base, offset, v uint64) error {
+	a, err := addr(base, offset)
+	if err != nil {
+		return err
+	}
+
+	return m.mem.WriteUint8(a, uint8(v))
+}
+
+func (m *module) store16(base, offset, v uint64) error {
+	a, err := addr(base, offset)
+	if err != nil {
+		return err
+	}
+
+	return m.mem.WriteUint16(a, uint16(v))
+}
+
+func (m *module) store32(base, offset, v uint64) error {
+	a, err := addr(base, offset)
+	if err != nil {
+		return err
+	}
+
+	return m.mem.WriteUint32(a, uint32(v))
+}
+
+func (m *module) store64(base, offset, v uint64) error {
+	a, err := addr(base, offset)
+	if err != nil {
+		return err
+	}
+
+	return m.mem.WriteUint64(a, v)
+}
+
+func b2u(b bool) uint64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+func f32(v uint64) float32 {
+	return math.Float32frombits(uint32(v))
+}
+
+func f64(v uint64) float64 {
+	return math.Float64frombits(v)
+}
+`
+
+// goGenerator translates the funcs of the module to Go
+type goGenerator struct {
+	*validationContext
+
+	translated map[uint32]bool // the funcs translated to Go
+}
+
+func (g *goGenerator) funcString(idx uint32) string {
+	name, ok := g.FunctionName(idx)
+	if !ok {
+		for _, exp := range g.ExportSection {
+			if exp.Desc.Kind == segments.KindFunction && exp.Desc.Index == idx {
+				name = exp.Name
+			}
+		}
+	}
+
+	return funcString(idx, name)
+}
+
+// generateEntry writes the entry of the Go func called by the instance
+func (g *goGenerator) generateEntry(b *bytes.Buffer, idx uint32) {
+	ft := g.funcs[idx]
+	args := make([]string, len(ft.InputTypes))
+	for i := range args {
+		args[i] = fmt.Sprintf("args[%d]", i)
+	}
+	rets := make([]string, len(ft.ReturnTypes))
+	for i := range rets {
+		rets[i] = fmt.Sprintf("r%d", i)
+	}
+
+	fmt.Fprintf(b, "%d: func(args []uint64) (_ []uint64, err error) {\n", idx)
+	b.WriteString("defer m.recover(&err, m.depth)\nm.depth = uint64(m.ins.FrameStack.Ptr + 1)\n")
+	call := fmt.Sprintf("m.f%d(%s)", idx, strings.Join(args, ", "))
+	if len(rets) == 0 {
+		fmt.Fprintf(b, "%s\nreturn nil, nil\n},\n", call)
+		return
+	}
+	fmt.Fprintf(b, "%s := %s\nreturn []uint64{%s}, nil\n},\n", strings.Join(rets, ", "), call, strings.Join(rets, ", "))
+}
+
+// goBlock is a block of the func translated to the Go code between the labels
+type goBlock struct {
+	at              uint64 // the index of the block instr, naming its labels
+	op              expr.OpCode
+	height          int // the height of the operand stack at the start, excluding the params
+	params, results int
+
+	used        bool // whether the label of the block is jumped to
+	loopLine    int  // the line of the label of the loop
+	unreachable bool // the rest of the block is unreachable
+	dead        bool // the block is in the unreachable code, which is not translated
+}
+
+// goFunc translates a func, the operand stack at the height h is the var s{h} and the locals are the vars l{i}
+type goFunc struct {
+	*goGenerator
+
+	idx      uint32
+	code     []instruction
+	lines    []string
+	height   int
+	blocks   []*goBlock
+	vars     map[string]bool // the vars used by the func, true if they are read
+	varNames []string        // the vars declared by the func in the order of the use
+	results  bool            // whether the r is used for the results of the calls
+}
+
+func (g *goGenerator) generateFunc(b *bytes.Buffer, idx uint32, code []instruction) {
+	ft := g.funcs[idx]
+	f := &goFunc{
+		goGenerator: g,
+		idx:         idx,
+		code:        code,
+		vars:        map[string]bool{},
+		blocks:      []*goBlock{{results: len(ft.ReturnTypes)}}, // the body of the func
+	}
+
+	// the params are declared by the func
+	params := make([]string, len(ft.InputTypes))
+	for i := range params {
+		params[i] = local(uint64(i))
+		f.vars[params[i]] = true
+	}
+
+	for pc := range code {
+		f.translate(uint64(pc), &code[pc])
+	}
+	f.end(f.blocks[0]) // the end of the body is not kept by the CodeSegment
+
+	sign := ""
+	if n := len(ft.ReturnTypes); n == 1 {
+		sign = " uint64"
+	} else if n > 1 {
+		sign = " (" + strings.TrimSuffix(strings.Repeat("uint64, ", n), ", ") + ")"
+	}
+	if len(params) > 0 {
+		params[len(params)-1] += " uint64"
+	}
+
+	fmt.Fprintf(b, "\n// f%d runs the %s\nfunc (m *module) f%d(%s)%s {\nm.enter()\n", idx, g.funcString(idx), idx, strings.Join(params, ", "), sign)
+	var unread []string
+	for _, name := range f.varNames {
+		fmt.Fprintf(b, "var %s uint64\n", name)
+		if !f.vars[name] {
+			unread = append(unread, name)
+		}
+	}
+	if f.results {
+		b.WriteString("var r []uint64\n")
+	}
+	for _, name := range unread {
+		fmt.Fprintf(b, "_ = %s\n", name)
+	}
+	for _, line := range f.lines {
+		if line != "" {
+			b.WriteString(line + "\n")
+		}
+	}
+	b.WriteString("}\n")
+}
+
+func (f *goFunc) emit(format string, args ...interface{}) {
+	f.lines = append(f.lines, fmt.Sprintf(format, args...))
+}
+
+// get returns the var read by the code
+func (f *goFunc) get(name string) string {
+	f.set(name)
+	f.vars[name] = true
+	return name
+}
+
+// set returns the var assigned by the code, which is declared at the start of the func
+func (f *goFunc) set(name string) string {
+	if _, ok := f.vars[name]; !ok {
+		f.vars[name] = false
+		f.varNames = append(f.varNames, name)
+	}
+	return name
+}
+
+func slot(h int) string {
+	return fmt.Sprintf("s%d", h)
+}
+
+func local(i uint64) string {
+	return fmt.Sprintf("l%d", i)
+}
+
+// operands returns the vars of the n operands on the top of the stack, which are popped
+func (f *goFunc) operands(n int) []string {
+	f.height -= n
+	vars := make([]string, n)
+	for i := range vars {
+		vars[i] = f.get(slot(f.height + i))
+	}
+
+	return vars
+}
+
+// trap returns the panic of the trap raised by the instr at the pc
+func (f *goFunc) trap(err string, pc uint64) string {
+	return fmt.Sprintf("panic(m.trap(%s, %d, %#x, %#x))", err, f.idx, f.code[pc].Offset, f.code[pc].OpCode)
+}
+
+func (f *goFunc) setUnreachable() {
+	f.blocks[len(f.blocks)-1].unreachable = true
+}
+
+func (f *goFunc) blockType(raw int64) *types.FuncType {
+	bt, _ := (&funcValidator{validationContext: f.validationContext}).blockType(raw) // checked by the Validate
+	return bt
+}
+
+func (f *goFunc) translate(pc uint64, in *instruction) {
+	top := f.blocks[len(f.blocks)-1]
+	if top.unreachable {
+		// only the blocks are followed
+		switch in.OpCode {
+		case expr.OpCodeBlock, expr.OpCodeLoop, expr.OpCodeIf:
+			f.blocks = append(f.blocks, &goBlock{dead: true, unreachable: true})
+		case expr.OpCodeElse:
+			if !top.dead {
+				f.elseOp(top)
+			}
+		case expr.OpCodeEnd:
+			if top.dead {
+				f.blocks = f.blocks[:len(f.blocks)-1]
+			} else {
+				f.end(top)
+			}
+		}
+		return
+	}
+
+	switch op := in.OpCode; op {
+	case expr.OpCodeUnreachable:
+		f.emit("%s", f.trap("wasm.ErrUnreachable", pc))
+		f.setUnreachable()
+	case expr.OpCodeNop:
+	case expr.OpCodeBlock, expr.OpCodeLoop:
+		bt := f.blockType(in.BlockType)
+		b := &goBlock{at: pc, op: op, height: f.height - len(bt.InputTypes), params: len(bt.InputTypes), results: len(bt.ReturnTypes)}
+		if op == expr.OpCodeLoop {
+			b.loopLine = len(f.lines)
+			f.emit("") // the label is set when the loop is jumped to
+		}
+		f.blocks = append(f.blocks, b)
+	case expr.OpCodeIf:
+		cond := f.operands(1)[0]
+		bt := f.blockType(in.BlockType)
+		b := &goBlock{at: pc, op: op, height: f.height - len(bt.InputTypes), params: len(bt.InputTypes), results: len(bt.ReturnTypes)}
+		if f.code[in.Targets[0]].OpCode == expr.OpCodeElse {
+			f.emit("if %s == 0 {\ngoto E%d\n}", cond, pc)
+		} else {
+			b.used = true
+			f.emit("if %s == 0 {\ngoto L%d\n}", cond, pc)
+		}
+		f.blocks = append(f.blocks, b)
+	case expr.OpCodeElse:
+		f.elseOp(top)
+	case expr.OpCodeEnd:
+		f.end(top)
+	case expr.OpCodeBr:
+		f.lines = append(f.lines, f.branch(in.Immediates[0])...)
+		f.setUnreachable()
+	case expr.OpCodeBrIf:
+		cond := f.operands(1)[0]
+		f.emit("if %s != 0 {\n%s\n}", cond, strings.Join(f.branch(in.Immediates[0]), "\n"))
+	case expr.OpCodeBrTable:
+		i := f.operands(1)[0]
+		f.emit("switch uint32(%s) {", i)
+		n := len(in.Immediates) - 1
+		for j, depth := range in.Immediates[:n] {
+			f.emit("case %d:\n%s", j, strings.Join(f.branch(depth), "\n"))
+		}
+		f.emit("default:\n%s\n}", strings.Join(f.branch(in.Immediates[n]), "\n"))
+		f.setUnreachable()
+	case expr.OpCodeReturn:
+		f.lines = append(f.lines, f.branch(uint64(len(f.blocks)-1))...)
+		f.setUnreachable()
+	case expr.OpCodeCall:
+		idx := uint32(in.Immediates[0])
+		ft := f.funcs[idx]
+		args := f.operands(len(ft.InputTypes))
+		if f.translated[idx] {
+			f.lines = append(f.lines, f.assign(len(ft.ReturnTypes), fmt.Sprintf("m.f%d(%s)", idx, strings.Join(args, ", ")), false)...)
+		} else {
+			f.lines = append(f.lines, f.assign(len(ft.ReturnTypes), fmt.Sprintf("m.call(%s)", strings.Join(append([]string{fmt.Sprint(idx)}, args...), ", ")), true)...)
+		}
+		f.height += len(ft.ReturnTypes)
+	case expr.OpCodeCallIndirect:
+		ft := f.TypeSection[in.Immediates[0]]
+		elem := f.operands(1)[0]
+		args := f.operands(len(ft.InputTypes))
+		f.emit("switch fn := m.indirect(%d, %s, %d, %#x); fn {", in.Immediates[0], elem, f.idx, in.Offset)
+		for idx, callee := range f.funcs {
+			if f.translated[uint32(idx)] && types.HasSameSignature(callee.InputTypes, ft.InputTypes) &&
+				types.HasSameSignature(callee.ReturnTypes, ft.ReturnTypes) {
+				call := fmt.Sprintf("m.f%d(%s)", idx, strings.Join(args, ", "))
+				f.emit("case %d:\n%s", idx, strings.Join(f.assign(len(ft.ReturnTypes), call, false), "\n"))
+			}
+		}
+		call := fmt.Sprintf("m.call(%s)", strings.Join(append([]string{"fn"}, args...), ", "))
+		f.emit("default:\n%s\n}", strings.Join(f.assign(len(ft.ReturnTypes), call, true), "\n"))
+		f.height += len(ft.ReturnTypes)
+	case expr.OpCodeDrop:
+		f.height--
+	case expr.OpCodeSelect:
+		v := f.operands(3)
+		f.emit("if %s == 0 {\n%s = %s\n}", v[2], f.set(slot(f.height)), v[1])
+		f.height++
+	case expr.OpCodeLocalGet:
+		f.emit("%s = %s", f.set(slot(f.height)), f.get(local(in.Immediates[0])))
+		f.height++
+	case expr.OpCodeLocalSet:
+		v := f.operands(1)[0]
+		f.emit("%s = %s", f.set(local(in.Immediates[0])), v)
+	case expr.OpCodeLocalTee:
+		f.emit("%s = %s", f.set(local(in.Immediates[0])), f.get(slot(f.height-1)))
+	case expr.OpCodeGlobalGet:
+		f.emit("%s = m.globals[%d]", f.set(slot(f.height)), in.Immediates[0])
+		f.height++
+	case expr.OpCodeGlobalSet:
+		f.emit("m.globals[%d] = %s", in.Immediates[0], f.operands(1)[0])
+	case expr.OpCodeMemorySize:
+		f.emit("%s = uint64(m.mem.PageSize())", f.set(slot(f.height)))
+		f.height++
+	case expr.OpCodeMemoryGrow:
+		v := f.operands(1)[0]
+		f.emit("%s = uint64(int32(m.mem.Grow(uint32(%s))))", v, v)
+		f.height++
+	case expr.OpCodeI32Const:
+		f.emit("%s = %#x", f.set(slot(f.height)), uint64(int32(in.Immediates[0]))) // sign extended as the i32Const
+		f.height++
+	case expr.OpCodeI64Const, expr.OpCodeF32Const, expr.OpCodeF64Const:
+		f.emit("%s = %#x", f.set(slot(f.height)), in.Immediates[0])
+		f.height++
+	default:
+		if _, width, store, ok := memoryAccess(op); ok {
+			f.memoryAccess(pc, in, width*8, store)
+			return
+		}
+
+		params, _, _ := numericSignature(op)
+		args := make([]interface{}, len(params))
+		for i, v := range f.operands(len(params)) {
+			args[i] = v
+		}
+		if cond, ok := goTrapOps[op]; ok {
+			f.emit("if %s {\n%s\n}", fmt.Sprintf(cond, args...), f.trap("wasm.ErrUndefined", pc))
+		}
+		f.emit("%s = %s", f.set(slot(f.height)), fmt.Sprintf(goNumericOps[op], args...))
+		f.height++
+	}
+}
+
+func (f *goFunc) memoryAccess(pc uint64, in *instruction, bits uint64, store bool) {
+	offset := in.Immediates[1] // ignore align
+	if store {
+		v := f.operands(2)
+		f.emit("if err := m.store%d(%s, %#x, %s); err != nil {\n%s\n}", bits, v[0], offset, v[1], f.trap("err", pc))
+		return
+	}
+
+	v := "v"
+	if ext, ok := goSignedLoads[in.OpCode]; ok {
+		v = ext
+	}
+
+	base := f.operands(1)[0]
+	f.emit("if v, err := m.load%d(%s, %#x); err != nil {\n%s\n} else {\n%s = %s\n}", bits, base, offset, f.trap("err", pc), f.set(base), v)
+	f.height++
+}
+
+func (f *goFunc) elseOp(b *goBlock) {
+	if !b.unreachable {
+		b.used = true
+		f.emit("goto L%d", b.at)
+	}
+	f.emit("E%d:", b.at)
+
+	b.unreachable = false
+	f.height = b.height + b.params
+}
+
+func (f *goFunc) end(b *goBlock) {
+	if len(f.blocks) == 1 {
+		// the end of the func
+		if !b.unreachable {
+			f.lines = append(f.lines, f.branch(0)...)
+		}
+		return
+	}
+
+	f.blocks = f.blocks[:len(f.blocks)-1]
+	reachable := !b.unreachable
+	if b.used {
+		if b.op == expr.OpCodeLoop {
+			f.lines[b.loopLine] = fmt.Sprintf("L%d:\nm.poll()", b.at)
+		} else {
+			f.emit("L%d:", b.at)
+			reachable = true
+		}
+	}
+
+	f.height = b.height + b.results
+	if !reachable {
+		f.setUnreachable()
+	}
+}
+
+// branch returns the lines of the branch to the label at the depth,
+// the operands taken by the label are moved to the bottom of the block
+func (f *goFunc) branch(depth uint64) []string {
+	b := f.blocks[len(f.blocks)-1-int(depth)]
+	n := b.results
+	if b.op == expr.OpCodeLoop {
+		n = b.params
+	}
+
+	src := make([]string, n)
+	dst := make([]string, n)
+	for i := range src {
+		src[i] = f.get(slot(f.height - n + i))
+		dst[i] = slot(b.height + i)
+	}
+
+	if b == f.blocks[0] {
+		return []string{"m.depth--", strings.TrimSpace("return " + strings.Join(src, ", "))}
+	}
+
+	var lines []string
+	if n > 0 && f.height-n != b.height {
+		for i := range dst {
+			f.set(dst[i])
+		}
+		lines = append(lines, strings.Join(dst, ", ")+" = "+strings.Join(src, ", "))
+	}
+	b.used = true
+
+	return append(lines, fmt.Sprintf("goto L%d", b.at))
+}
+
+// assign returns the lines assigning the results of the call to the top of the stack,
+// the results are returned as a slice if slice is set
+func (f *goFunc) assign(n int, call string, slice bool) []string {
+	dst := make([]string, n)
+	for i := range dst {
+		dst[i] = f.set(slot(f.height + i))
+	}
+
+	switch {
+	case n == 0:
+		return []string{call}
+	case !slice:
+		return []string{strings.Join(dst, ", ") + " = " + call}
+	case n == 1:
+		return []string{dst[0] + " = " + call + "[0]"}
+	}
+
+	f.results = true
+	src := make([]string, n)
+	for i := range src {
+		src[i] = fmt.Sprintf("r[%d]", i)
+	}
+	return []string{"r = " + call, strings.Join(dst, ", ") + " = " + strings.Join(src, ", ")}
+}
+
+// goUnsupported returns the first instr of the code which is not translated to Go, nil if none
+func goUnsupported(code []instruction) *instruction {
+	for i := range code {
+		switch op := code[i].OpCode; op {
+		case expr.OpCodeUnreachable, expr.OpCodeNop, expr.OpCodeBlock, expr.OpCodeLoop, expr.OpCodeIf,
+			expr.OpCodeElse, expr.OpCodeEnd, expr.OpCodeBr, expr.OpCodeBrIf, expr.OpCodeBrTable,
+			expr.OpCodeReturn, expr.OpCodeCall, expr.OpCodeCallIndirect, expr.OpCodeDrop, expr.OpCodeSelect,
+			expr.OpCodeLocalGet, expr.OpCodeLocalSet, expr.OpCodeLocalTee, expr.OpCodeGlobalGet, expr.OpCodeGlobalSet,
+			expr.OpCodeMemorySize, expr.OpCodeMemoryGrow,
+			expr.OpCodeI32Const, expr.OpCodeI64Const, expr.OpCodeF32Const, expr.OpCodeF64Const:
+		default:
+			if _, _, _, ok := memoryAccess(op); ok {
+				continue
+			}
+			if _, ok := goNumericOps[op]; !ok || instructions[op] == nil {
+				return &code[i]
+			}
+		}
+	}
+
+	return nil
+}
+
+// goTrapOps are the conditions of the numeric instrs raising the ErrUndefined
+var goTrapOps = map[expr.OpCode]string{
+	expr.OpCodeI32DivS: "int32(%[2]s) == 0 || (int32(%[1]s) == math.MinInt32 && int32(%[2]s) == -1)",
+	expr.OpCodeI32DivU: "uint32(%[2]s) == 0",
+	expr.OpCodeI32RemS: "uint32(%[2]s) == 0",
+	expr.OpCodeI32RemU: "uint32(%[2]s) == 0",
+	expr.OpCodeI64DivS: "int64(%[2]s) == 0 || (int64(%[1]s) == math.MinInt64 && int64(%[2]s) == -1)",
+	expr.OpCodeI64DivU: "%[2]s == 0",
+	expr.OpCodeI64RemS: "%[2]s == 0",
+	expr.OpCodeI64RemU: "%[2]s == 0",
+}
+
+// goNumericOps are the Go expressions of the numeric instrs taking the operands %[1]s and %[2]s,
+// which compute the same values as their instructions
+var goNumericOps = map[expr.OpCode]string{
+	expr.OpCodeI32Eqz: "b2u(%[1]s == 0)",
+	expr.OpCodeI32Eq:  "b2u(%[1]s == %[2]s)",
+	expr.OpCodeI32Ne:  "b2u(%[1]s != %[2]s)",
+	expr.OpCodeI32LtS: "b2u(int32(%[1]s) < int32(%[2]s))",
+	expr.OpCodeI32LtU: "b2u(uint32(%[1]s) < uint32(%[2]s))",
+	expr.OpCodeI32GtS: "b2u(int32(%[1]s) > int32(%[2]s))",
+	expr.OpCodeI32GtU: "b2u(uint32(%[1]s) > uint32(%[2]s))",
+	expr.OpCodeI32LeS: "b2u(int32(%[1]s) <= int32(%[2]s))",
+	expr.OpCodeI32LeU: "b2u(uint32(%[1]s) <= uint32(%[2]s))",
+	expr.OpCodeI32GeS: "b2u(int32(%[1]s) >= int32(%[2]s))",
+	expr.OpCodeI32GeU: "b2u(uint32(%[1]s) >= uint32(%[2]s))",
+	expr.OpCodeI64Eqz: "b2u(%[1]s == 0)",
+	expr.OpCodeI64Eq:  "b2u(%[1]s == %[2]s)",
+	expr.OpCodeI64Ne:  "b2u(%[1]s != %[2]s)",
+	expr.OpCodeI64LtS: "b2u(int64(%[1]s) < int64(%[2]s))",
+	expr.OpCodeI64LtU: "b2u(%[1]s < %[2]s)",
+	expr.OpCodeI64GtS: "b2u(int64(%[1]s) > int64(%[2]s))",
+	expr.OpCodeI64GtU: "b2u(%[1]s > %[2]s)",
+	expr.OpCodeI64LeS: "b2u(int64(%[1]s) <= int64(%[2]s))",
+	expr.OpCodeI64LeU: "b2u(%[1]s <= %[2]s)",
+	expr.OpCodeI64GeS: "b2u(int64(%[1]s) >= int64(%[2]s))",
+	expr.OpCodeI64GeU: "b2u(%[1]s >= %[2]s)",
+	expr.OpCodeF32Eq:  "b2u(f32(%[1]s) == f32(%[2]s))",
+	expr.OpCodeF32Ne:  "b2u(f32(%[1]s) != f32(%[2]s))",
+	expr.OpCodeF32Lt:  "b2u(f32(%[1]s) < f32(%[2]s))",
+	expr.OpCodeF32Gt:  "b2u(f32(%[1]s) > f32(%[2]s))",
+	expr.OpCodeF32Le:  "b2u(f32(%[1]s) <= f32(%[2]s))",
+	expr.OpCodeF32Ge:  "b2u(f32(%[1]s) >= f32(%[2]s))",
+	expr.OpCodeF64Eq:  "b2u(f64(%[1]s) == f64(%[2]s))",
+	expr.OpCodeF64Ne:  "b2u(f64(%[1]s) != f64(%[2]s))",
+	expr.OpCodeF64Lt:  "b2u(f64(%[1]s) < f64(%[2]s))",
+	expr.OpCodeF64Gt:  "b2u(f64(%[1]s) > f64(%[2]s))",
+	expr.OpCodeF64Le:  "b2u(f64(%[1]s) <= f64(%[2]s))",
+	expr.OpCodeF64Ge:  "b2u(f64(%[1]s) >= f64(%[2]s))",
+
+	expr.OpCodeI32Clz:    "uint64(bits.LeadingZeros32(uint32(%[1]s)))",
+	expr.OpCodeI32Ctz:    "uint64(bits.TrailingZeros32(uint32(%[1]s)))",
+	expr.OpCodeI32PopCnt: "uint64(bits.OnesCount32(uint32(%[1]s)))",
+	expr.OpCodeI32Add:    "uint64(int32(%[1]s) + int32(%[2]s))",
+	expr.OpCodeI32Sub:    "uint64(int32(%[1]s) - int32(%[2]s))",
+	expr.OpCodeI32Mul:    "uint64(int32(%[1]s) * int32(%[2]s))",
+	expr.OpCodeI32DivS:   "uint64(int32(%[1]s) / int32(%[2]s))",
+	expr.OpCodeI32DivU:   "uint64(uint32(%[1]s) / uint32(%[2]s))",
+	expr.OpCodeI32RemS:   "uint64(int32(%[1]s) %% int32(%[2]s))",
+	expr.OpCodeI32RemU:   "uint64(uint32(%[1]s) %% uint32(%[2]s))",
+	expr.OpCodeI32And:    "uint64(uint32(%[1]s) & uint32(%[2]s))",
+	expr.OpCodeI32Or:     "uint64(uint32(%[1]s) | uint32(%[2]s))",
+	expr.OpCodeI32Xor:    "uint64(uint32(%[1]s) ^ uint32(%[2]s))",
+	expr.OpCodeI32Shl:    "uint64(uint32(%[1]s) << (uint32(%[2]s) %% 32))",
+	expr.OpCodeI32ShrS:   "uint64(int32(%[1]s) >> (uint32(%[2]s) %% 32))",
+	expr.OpCodeI32ShrU:   "uint64(uint32(%[1]s) >> (uint32(%[2]s) %% 32))",
+	expr.OpCodeI32RotL:   "uint64(bits.RotateLeft32(uint32(%[1]s), int(%[2]s)))",
+	expr.OpCodeI32RotR:   "uint64(bits.RotateLeft32(uint32(%[1]s), -int(%[2]s)))",
+	expr.OpCodeI64Clz:    "uint64(bits.LeadingZeros64(%[1]s))",
+	expr.OpCodeI64Ctz:    "uint64(bits.TrailingZeros64(%[1]s))",
+	expr.OpCodeI64PopCnt: "uint64(bits.OnesCount64(%[1]s))",
+	expr.OpCodeI64Add:    "%[1]s + %[2]s",
+	expr.OpCodeI64Sub:    "%[1]s - %[2]s",
+	expr.OpCodeI64Mul:    "%[1]s * %[2]s",
+	expr.OpCodeI64DivS:   "uint64(int64(%[1]s) / int64(%[2]s))",
+	expr.OpCodeI64DivU:   "%[1]s / %[2]s",
+	expr.OpCodeI64RemS:   "uint64(int64(%[1]s) %% int64(%[2]s))",
+	expr.OpCodeI64RemU:   "%[1]s %% %[2]s",
+	expr.OpCodeI64And:    "%[1]s & %[2]s",
+	expr.OpCodeI64Or:     "%[1]s | %[2]s",
+	expr.OpCodeI64Xor:    "%[1]s ^ %[2]s",
+	expr.OpCodeI64Shl:    "%[1]s << (%[2]s %% 64)",
+	expr.OpCodeI64ShrS:   "uint64(int64(%[1]s) >> (%[2]s %% 64))",
+	expr.OpCodeI64ShrU:   "%[1]s >> (%[2]s %% 64)",
+	expr.OpCodeI64RotL:   "bits.RotateLeft64(%[1]s, int(%[2]s))",
+	expr.OpCodeI64RotR:   "bits.RotateLeft64(%[1]s, -int(%[2]s))",
+
+	expr.OpCodeF32Abs:      "uint64(uint32(%[1]s) &^ (1 << 31))",
+	expr.OpCodeF32Neg:      "uint64(math.Float32bits(-f32(%[1]s)))",
+	expr.OpCodeF32Ceil:     "uint64(math.Float32bits(float32(math.Ceil(float64(f32(%[1]s))))))",
+	expr.OpCodeF32Floor:    "uint64(math.Float32bits(float32(math.Floor(float64(f32(%[1]s))))))",
+	expr.OpCodeF32Trunc:    "uint64(math.Float32bits(float32(math.Trunc(float64(f32(%[1]s))))))",
+	expr.OpCodeF32Nearest:  "uint64(math.Float32bits(float32(math.RoundToEven(float64(f32(%[1]s))))))",
+	expr.OpCodeF32Sqrt:     "uint64(math.Float32bits(float32(math.Sqrt(float64(f32(%[1]s))))))",
+	expr.OpCodeF32Add:      "uint64(math.Float32bits(f32(%[2]s) + f32(%[1]s)))",
+	expr.OpCodeF32Sub:      "uint64(math.Float32bits(f32(%[1]s) - f32(%[2]s)))",
+	expr.OpCodeF32Mul:      "uint64(math.Float32bits(f32(%[2]s) * f32(%[1]s)))",
+	expr.OpCodeF32Div:      "uint64(math.Float32bits(f32(%[1]s) / f32(%[2]s)))",
+	expr.OpCodeF32Min:      "uint64(math.Float32bits(float32(math.Min(float64(f32(%[1]s)), float64(f32(%[2]s))))))",
+	expr.OpCodeF32Max:      "uint64(math.Float32bits(float32(math.Max(float64(f32(%[1]s)), float64(f32(%[2]s))))))",
+	expr.OpCodeF32CopySign: "uint64(math.Float32bits(float32(math.Copysign(float64(f32(%[1]s)), float64(f32(%[2]s))))))",
+	expr.OpCodeF64Abs:      "%[1]s &^ (1 << 63)",
+	expr.OpCodeF64Neg:      "math.Float64bits(-f64(%[1]s))",
+	expr.OpCodeF64Ceil:     "math.Float64bits(math.Ceil(f64(%[1]s)))",
+	expr.OpCodeF64Floor:    "math.Float64bits(math.Floor(f64(%[1]s)))",
+	expr.OpCodeF64Trunc:    "math.Float64bits(math.Trunc(f64(%[1]s)))",
+	expr.OpCodeF64Nearest:  "math.Float64bits(math.RoundToEven(f64(%[1]s)))",
+	expr.OpCodeF64Sqrt:     "math.Float64bits(math.Sqrt(f64(%[1]s)))",
+	expr.OpCodeF64Add:      "math.Float64bits(f64(%[2]s) + f64(%[1]s))",
+	expr.OpCodeF64Sub:      "math.Float64bits(f64(%[1]s) - f64(%[2]s))",
+	expr.OpCodeF64Mul:      "math.Float64bits(f64(%[2]s) * f64(%[1]s))",
+	expr.OpCodeF64Div:      "math.Float64bits(f64(%[1]s) / f64(%[2]s))",
+	expr.OpCodeF64Min:      "math.Float64bits(math.Min(f64(%[1]s), f64(%[2]s)))",
+	expr.OpCodeF64Max:      "math.Float64bits(math.Max(f64(%[1]s), f64(%[2]s)))",
+	expr.OpCodeF64CopySign: "math.Float64bits(math.Copysign(f64(%[1]s), f64(%[2]s)))",
+
+	expr.OpCodeI32WrapI64:        "uint64(uint32(%[1]s))",
+	expr.OpCodeI32TruncF32S:      "uint64(int32(math.Trunc(float64(f32(%[1]s)))))",
+	expr.OpCodeI32TruncF32U:      "uint64(uint32(math.Trunc(float64(f32(%[1]s)))))",
+	expr.OpCodeI32truncF64S:      "uint64(int32(math.Trunc(f64(%[1]s))))",
+	expr.OpCodeI32truncF64U:      "uint64(uint32(math.Trunc(f64(%[1]s))))",
+	expr.OpCodeI64ExtendI32S:     "uint64(int64(int32(%[1]s)))",
+	expr.OpCodeI64ExtendI32U:     "uint64(uint32(%[1]s))",
+	expr.OpCodeI64TruncF32S:      "uint64(int64(math.Trunc(float64(f32(%[1]s)))))",
+	expr.OpCodeI64TruncF32U:      "uint64(math.Trunc(float64(f32(%[1]s))))",
+	expr.OpCodeI64TruncF64S:      "uint64(int64(math.Trunc(f64(%[1]s))))",
+	expr.OpCodeI64TruncF64U:      "uint64(math.Trunc(f64(%[1]s)))",
+	expr.OpCodeF32ConvertI32S:    "uint64(math.Float32bits(float32(int32(%[1]s))))",
+	expr.OpCodeF32ConvertI32U:    "uint64(math.Float32bits(float32(uint32(%[1]s))))",
+	expr.OpCodeF32ConvertI64S:    "uint64(math.Float32bits(float32(int64(%[1]s))))",
+	expr.OpCodeF32ConvertI64U:    "uint64(math.Float32bits(float32(%[1]s)))",
+	expr.OpCodeF32DemoteF64:      "uint64(math.Float32bits(float32(f64(%[1]s))))",
+	expr.OpCodeF64ConvertI32S:    "math.Float64bits(float64(int32(%[1]s)))",
+	expr.OpCodeF64ConvertI32U:    "math.Float64bits(float64(uint32(%[1]s)))",
+	expr.OpCodeF64ConvertI64S:    "math.Float64bits(float64(int64(%[1]s)))",
+	expr.OpCodeF64ConvertI64U:    "math.Float64bits(float64(%[1]s))",
+	expr.OpCodeF64PromoteF32:     "math.Float64bits(float64(f32(%[1]s)))",
+	expr.OpCodeI32ReinterpretF32: "%[1]s",
+	expr.OpCodeI64ReinterpretF64: "%[1]s",
+	expr.OpCodeF32ReinterpretI32: "%[1]s",
+	expr.OpCodeF64ReinterpretI64: "%[1]s",
+	expr.OpCodeI32Extend8S:       "uint64(uint32(int8(%[1]s)))",
+	expr.OpCodeI32Extend16S:      "uint64(uint32(int16(%[1]s)))",
+	expr.OpCodeI64Extend8S:       "uint64(int8(%[1]s))",
+	expr.OpCodeI64Extend16S:      "uint64(int16(%[1]s))",
+	expr.OpCodeI64Extend32S:      "uint64(int32(%[1]s))",
+}
+
+// goSignedLoads are the Go expressions sign extending the value v loaded by the instrs
+var goSignedLoads = map[expr.OpCode]string{
+	expr.OpCodeI32Load8s:  "uint64(uint32(int8(v)))",
+	expr.OpCodeI32Load16s: "uint64(uint32(int16(v)))",
+	expr.OpCodeI64Load8s:  "uint64(int8(v))",
+	expr.OpCodeI64Load16s: "uint64(int16(v))",
+	expr.OpCodeI64Load32s: "uint64(int32(v))",
+}
@@ -0,0 +1,298 @@
+package wasm
+
+import (
+	"bytes"
+	"go/format"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// generatedModule returns the binary of the module translated by the tests
+func generatedModule() []byte {
+	leb := func(v int) []byte {
+		var b []byte
+		for {
+			c := byte(v & 0x7f)
+			v >>= 7
+			if v == 0 {
+				return append(b, c)
+			}
+			b = append(b, c|0x80)
+		}
+	}
+	vec := func(entries ...[]byte) []byte {
+		b := leb(len(entries))
+		for _, e := range entries {
+			b = append(b, e...)
+		}
+		return b
+	}
+	section := func(id byte, entries ...[]byte) []byte {
+		content := vec(entries...)
+		return append(append([]byte{id}, leb(len(content))...), content...)
+	}
+	name := func(s string) []byte {
+		return append(leb(len(s)), s...)
+	}
+	body := func(code ...byte) []byte {
+		return append(leb(len(code)), code...)
+	}
+
+	funcs := []struct {
+		name    string
+		typeIdx byte
+		body    []byte
+	}{
+		{name: "sum", body: []byte{0x01, 0x01, 0x7f, // a local
+			0x02, 0x40, 0x03, 0x40, 0x20, 0x00, 0x45, 0x0d, 0x01, 0x20, 0x01, 0x20, 0x00, 0x6a, 0x21, 0x01,
+			0x20, 0x00, 0x41, 0x01, 0x6b, 0x21, 0x00, 0x0c, 0x00, 0x0b, 0x0b, 0x20, 0x01, 0x0b}},
+		{name: "fib", body: []byte{0x00,
+			0x20, 0x00, 0x41, 0x02, 0x48, 0x04, 0x7f, 0x20, 0x00, 0x05,
+			0x20, 0x00, 0x41, 0x01, 0x6b, 0x10, 0x02, 0x20, 0x00, 0x41, 0x02, 0x6b, 0x10, 0x02, 0x6a, 0x0b, 0x0b}},
+		{name: "switch", body: []byte{0x00,
+			0x02, 0x40, 0x02, 0x40, 0x02, 0x40, 0x20, 0x00, 0x0e, 0x02, 0x00, 0x01, 0x02, 0x0b,
+			0x41, 0x0a, 0x0f, 0x0b, 0x41, 0x14, 0x0f, 0x0b, 0x41, 0x1e, 0x0b}},
+		// x / y + 1
+		{name: "div", typeIdx: 1, body: []byte{0x00, 0x20, 0x00, 0x20, 0x01, 0x6d, 0x41, 0x01, 0x6a, 0x0b}},
+		// stores x at y+4, and adds the byte loaded from there to the global
+		{name: "mem", typeIdx: 1, body: []byte{0x00,
+			0x20, 0x01, 0x20, 0x00, 0x36, 0x02, 0x04, 0x20, 0x01, 0x2d, 0x00, 0x04,
+			0x23, 0x00, 0x6a, 0x24, 0x00, 0x23, 0x00, 0x3f, 0x00, 0x6a, 0x0b}},
+		// calls the table[y] with x
+		{name: "indirect", typeIdx: 1, body: []byte{0x00, 0x20, 0x00, 0x20, 0x01, 0x11, 0x00, 0x00, 0x0b}},
+		// i64.trunc(sqrt(f64(x) / f64(y))) + i32.trunc(floor(f32(x) * 1.5))
+		{name: "float", typeIdx: 1, body: []byte{0x00,
+			0x20, 0x00, 0xb7, 0x20, 0x01, 0xb7, 0xa3, 0x9f, 0xb0, 0xa7,
+			0x20, 0x00, 0xb2, 0x43, 0x00, 0x00, 0xc0, 0x3f, 0x94, 0x8e, 0xa8, 0x6a, 0x0b}},
+		// logs x, and selects 7 if x != 0 else 9
+		{name: "log", body: []byte{0x00, 0x20, 0x00, 0x10, 0x00, 0x41, 0x07, 0x41, 0x09, 0x20, 0x00, 0x1b, 0x0b}},
+		// fills 4 bytes at x with 0x41, the memory.fill is not translated
+		{name: "fill", body: []byte{0x00,
+			0x20, 0x00, 0x41, 0xc1, 0x00, 0x41, 0x04, 0xfc, 0x0b, 0x00, 0x20, 0x00, 0x28, 0x02, 0x00, 0x0b}},
+		{name: "callfill", body: []byte{0x00, 0x20, 0x00, 0x10, 0x09, 0x0b}},
+		// the br_if taking the value of the block
+		{name: "blockval", body: []byte{0x00,
+			0x02, 0x7f, 0x41, 0x05, 0x20, 0x00, 0x0d, 0x00, 0x1a, 0x41, 0x06, 0x0b, 0x0b}},
+		{name: "unreach", body: []byte{0x00, 0x20, 0x00, 0x04, 0x40, 0x00, 0x0b, 0x20, 0x00, 0x0b}},
+		{name: "deep", body: []byte{0x00, 0x20, 0x00, 0x10, 0x0d, 0x0b}},
+		// i32.wrap(i64.clz(rotl(i64(x) * u64(y), i64(y))))
+		{name: "i64", typeIdx: 1, body: []byte{0x00,
+			0x20, 0x00, 0xac, 0x20, 0x01, 0xad, 0x7e, 0x20, 0x01, 0xac, 0x89, 0x79, 0xa7, 0x0b}},
+		// (i64.gt_u(i64(x), u64(y)) << 1) | f32.gt(f32(x), f32(y))
+		{name: "cmp", typeIdx: 1, body: []byte{0x00,
+			0x20, 0x00, 0xac, 0x20, 0x01, 0xad, 0x56, 0x41, 0x01, 0x74,
+			0x20, 0x00, 0xb2, 0x20, 0x01, 0xb2, 0x5e, 0x72, 0x0b}},
+		// i32.trunc(f32.max(f32.nearest(f32(x) / 2), f32(y))) + i32.trunc(f64.max(f64.nearest(f64(x) / 2), f64(y)))
+		{name: "fmax", typeIdx: 1, body: []byte{0x00,
+			0x20, 0x00, 0xb2, 0x43, 0x00, 0x00, 0x00, 0x40, 0x95, 0x90, 0x20, 0x01, 0xb2, 0x97, 0xa8,
+			0x20, 0x00, 0xb7, 0x44, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x40, 0xa3, 0x9e, 0x20, 0x01, 0xb7, 0xa5, 0xaa,
+			0x6a, 0x0b}},
+		// stores x at y, and adds the signed loads from there and the sign extensions of x
+		{name: "sload", typeIdx: 1, body: []byte{0x00,
+			0x20, 0x01, 0x20, 0x00, 0x36, 0x02, 0x00,
+			0x20, 0x01, 0x2c, 0x00, 0x00, 0x20, 0x01, 0x2e, 0x01, 0x00, 0x73,
+			0x20, 0x01, 0x30, 0x00, 0x00, 0x20, 0x01, 0x32, 0x01, 0x00, 0x85, 0x20, 0x01, 0x34, 0x02, 0x00, 0x7c,
+			0x42, 0x20, 0x88, 0xa7, 0x6a,
+			0x20, 0x00, 0xc0, 0x6a,
+			0x20, 0x00, 0xad, 0xc3, 0xa7, 0x6a, 0x0b}},
+		// (x %u y) + (x %s y) + (x /u y) + i32.wrap((i64(x) >> i64(y)) + (u64(x) %u u64(y)) + (i64(x) %s i64(y)) + (u64(x) /u u64(y)))
+		{name: "rem", typeIdx: 1, body: []byte{0x00,
+			0x20, 0x00, 0x20, 0x01, 0x70, 0x20, 0x00, 0x20, 0x01, 0x6f, 0x6a, 0x20, 0x00, 0x20, 0x01, 0x6e, 0x6a,
+			0x20, 0x00, 0xac, 0x20, 0x01, 0xac, 0x87,
+			0x20, 0x00, 0xad, 0x20, 0x01, 0xad, 0x82, 0x7c,
+			0x20, 0x00, 0xac, 0x20, 0x01, 0xac, 0x81, 0x7c,
+			0x20, 0x00, 0xad, 0x20, 0x01, 0xad, 0x80, 0x7c,
+			0xa7, 0x6a, 0x0b}},
+	}
+
+	var typeIdxs, exports, bodies [][]byte
+	for i, f := range funcs {
+		typeIdxs = append(typeIdxs, []byte{f.typeIdx})
+		exports = append(exports, append(name(f.name), 0x00, byte(i+1)))
+		bodies = append(bodies, body(f.body...))
+	}
+
+	bin := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	bin = append(bin, section(0x01,
+		[]byte{0x60, 0x01, 0x7f, 0x01, 0x7f},
+		[]byte{0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7f},
+		[]byte{0x60, 0x01, 0x7f, 0x00},
+	)...)
+	bin = append(bin, section(0x02, append(append(name("env"), name("log")...), 0x00, 0x02))...)
+	bin = append(bin, section(0x03, typeIdxs...)...)
+	bin = append(bin, section(0x04, []byte{0x70, 0x00, 0x03})...)
+	bin = append(bin, section(0x05, []byte{0x00, 0x01})...)
+	bin = append(bin, section(0x06, []byte{0x7f, 0x01, 0x41, 0x00, 0x0b})...)
+	bin = append(bin, section(0x07, exports...)...)
+	bin = append(bin, section(0x09, []byte{0x00, 0x41, 0x00, 0x0b, 0x02, 0x02, 0x01})...) // fib, sum
+	bin = append(bin, section(0x0a, bodies...)...)
+
+	return bin
+}
+
+func TestGenerateGo(t *testing.T) {
+	var buf bytes.Buffer
+	if err := GenerateGo(&buf, "plugin", generatedModule()); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		t.Logf("source:\n%s", buf.String())
+		t.Fatal(err)
+	}
+
+	for _, s := range []string{
+		"package plugin",
+		"func Instantiate(l *wasman.Linker, conf config.ModuleConfig) (*wasman.Instance, error)",
+		"func (m *module) f2(l0 uint64) uint64 {",
+		"fill[9] is run by the interpreter, BulkMemory at 0x7 is not translated",
+		"s0 = m.call(9, s0)[0]",
+		"= uint64(int32(v))", // i64.load32_s
+	} {
+		if !bytes.Contains(src, []byte(s)) {
+			t.Logf("%q is not generated", s)
+			t.Fail()
+		}
+	}
+	if bytes.Contains(src, []byte("func (m *module) f9(")) {
+		t.Log("fill[9] is translated")
+		t.Fail()
+	}
+
+	if err := GenerateGo(&buf, "plugin", []byte{0x00, 0x61, 0x73, 0x6d}); err == nil {
+		t.Log("invalid module is translated")
+		t.Fail()
+	}
+}
+
+// harness runs the funcs of the module on the interpreter and the generated Go code
+const harness = `package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+
+	"github.com/hybridgroup/wasman"
+	"github.com/hybridgroup/wasman/config"
+	"github.com/hybridgroup/wasman/tollstation"
+	"github.com/hybridgroup/wasman/wasm"
+)
+
+func root(err error) error {
+	var trap *wasman.Trap
+	if errors.As(err, &trap) {
+		return trap.Err
+	}
+	return err
+}
+
+func main() {
+	l := wasman.NewLinker(config.LinkerConfig{})
+	if err := wasman.DefineFunc10(l, "env", "log", func(int32) {}); err != nil {
+		panic(err)
+	}
+
+	limit := uint64(50)
+	mod, err := wasman.NewModuleFromBytes(config.ModuleConfig{CallDepthLimit: &limit}, wasmBinary)
+	if err != nil {
+		panic(err)
+	}
+	interp, err := l.Instantiate(mod)
+	if err != nil {
+		panic(err)
+	}
+
+	toll := tollstation.NewSimpleTollStation(0)
+	gen, err := Instantiate(l, config.ModuleConfig{CallDepthLimit: &limit, TollStation: toll})
+	if err != nil {
+		panic(err)
+	}
+
+	failed := false
+	for _, c := range []struct {
+		name string
+		args []uint64
+	}{
+		{"sum", []uint64{100}}, {"sum", []uint64{0}}, {"fib", []uint64{15}},
+		{"switch", []uint64{0}}, {"switch", []uint64{1}}, {"switch", []uint64{2}}, {"switch", []uint64{9}},
+		{"div", []uint64{7, 2}}, {"div", []uint64{7, 0}}, {"div", []uint64{0x80000000, 0xffffffff}},
+		{"mem", []uint64{3, 100}}, {"mem", []uint64{0x1234, 8}}, {"mem", []uint64{5, 0xfffffff0}},
+		{"indirect", []uint64{10, 0}}, {"indirect", []uint64{10, 1}}, {"indirect", []uint64{1, 2}}, {"indirect", []uint64{1, 5}},
+		{"float", []uint64{7, 2}}, {"float", []uint64{7, 0}}, {"float", []uint64{0xfffffff7, 4}},
+		{"log", []uint64{0}}, {"log", []uint64{3}}, {"fill", []uint64{16}}, {"callfill", []uint64{32}},
+		{"blockval", []uint64{0}}, {"blockval", []uint64{1}}, {"unreach", []uint64{0}}, {"unreach", []uint64{1}},
+		{"deep", []uint64{1}}, {"i64", []uint64{3, 5}}, {"i64", []uint64{0xfffffff9, 33}},
+		{"cmp", []uint64{0xffffffff, 1}}, {"cmp", []uint64{1, 0xffffffff}}, {"cmp", []uint64{3, 2}}, {"cmp", []uint64{2, 3}},
+		{"fmax", []uint64{5, 1}}, {"fmax", []uint64{7, 9}}, {"fmax", []uint64{0xfffffff9, 0xfffffffc}},
+		{"sload", []uint64{0x80ff8081, 64}}, {"sload", []uint64{0x7f017f01, 64}}, {"sload", []uint64{1, 0xfffffffe}},
+		{"rem", []uint64{0xfffffff9, 3}}, {"rem", []uint64{0xfffffff9, 0xffffffff}}, {"rem", []uint64{7, 0}},
+	} {
+		r1, _, err1 := interp.CallExportedFunc(c.name, c.args...)
+		r2, _, err2 := gen.CallExportedFunc(c.name, c.args...)
+		fmt.Printf("%s%v: %v %v | %v %v\n", c.name, c.args, r1, err1, r2, err2)
+		if !reflect.DeepEqual(r1, r2) || root(err1) != root(err2) ||
+			(err1 != nil && err1.Error() != err2.Error() && root(err1) != wasm.ErrCallStackExhausted) {
+			fmt.Println("\tmismatch")
+			failed = true
+		}
+
+		if c.name == "sum" && toll.GetToll() != 0 {
+			fmt.Println("\tthe sum is run by the interpreter")
+			failed = true
+		}
+	}
+
+	if !reflect.DeepEqual(interp.Memory.Value, gen.Memory.Value) || !reflect.DeepEqual(interp.Globals, gen.Globals) {
+		fmt.Println("the memories or globals mismatch")
+		failed = true
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+`
+
+func TestGenerateGo_run(t *testing.T) {
+	goTool, err := exec.LookPath("go")
+	if err != nil || testing.Short() {
+		t.Skip("the generated code is not built")
+	}
+
+	root, err := filepath.Abs("..")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := GenerateGo(&buf, "main", generatedModule()); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	gomod := "module wasm2gotest\n\ngo 1.18\n\nrequire github.com/hybridgroup/wasman v0.0.0\n\nreplace github.com/hybridgroup/wasman => " + root + "\n"
+	for name, content := range map[string]string{
+		"go.mod":     gomod,
+		"module.go":  buf.String(),
+		"harness.go": harness,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command(goTool, "run", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOWORK=off")
+	out, err := cmd.CombinedOutput()
+	if err != nil || strings.Contains(string(out), "mismatch") {
+		t.Logf("output:\n%s", out)
+		t.Fatal(err)
+	}
+}
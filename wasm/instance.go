@@ -1,16 +1,12 @@
 package wasm
 
 import (
-	"bytes"
-	"encoding/binary"
+	"context"
 	"fmt"
 	"math"
 
 	"github.com/hybridgroup/wasman/config"
-
 	"github.com/hybridgroup/wasman/stacks"
-
-	"github.com/hybridgroup/wasman/leb128decode"
 )
 
 // Instance is an instantiated module
@@ -25,12 +21,23 @@ type Instance struct {
 	Globals   []uint64
 
 	OperandStack *stacks.Stack[uint64]
+
+	interrupted uint32 // the level of the interrupted call, 1 for the outermost one, accessed atomically
+	calls       int    // the number of the running CallExportedFunc, nested by the host funcs
+	ctx         context.Context
+
+	hostValues map[interface{}]interface{} // the state of the host funcs kept per instance, see HostValue
 }
 
-// NewInstance will instantiate the module with extern modules
+// NewInstance will instantiate the module with extern modules,
+// the module is not modified so that it can be instantiated again
 func NewInstance(module *Module, externModules map[string]*Module) (*Instance, error) {
+	// the index spaces are built on the copy owned by the instance
+	m := *module
+	m.IndexSpace = nil
+
 	ins := &Instance{
-		Module:       module,
+		Module:       &m,
 		OperandStack: stacks.NewOperandStack(),
 		FrameStack: &stacks.Stack[*Frame]{
 			Ptr:    -1,
@@ -45,32 +52,36 @@ func NewInstance(module *Module, externModules map[string]*Module) (*Instance, e
 
 	// initializing memory
 	module.log("initializing memory")
-	if len(ins.Module.IndexSpace.Memories) > 0 {
-		ins.Memory = ins.Module.IndexSpace.Memories[0]
-		// ignore the requested amount of memory and just provide a single page
-		diff := config.DefaultMemoryPageSize - len(ins.Memory.Value)
-		if diff > 0 {
-			// module.log(fmt.Sprintf("3: %v", diff))
-			ins.Memory.Value = append(ins.Memory.Value, make([]byte, diff)...)
-		}
+	if len(ins.IndexSpace.Memories) > 0 {
+		ins.Memory = ins.IndexSpace.Memories[0]
 	}
 
 	// initializing functions
 	module.log("initializing functions")
-	ins.Functions = make([]fn, len(ins.Module.IndexSpace.Functions))
-	for i, f := range ins.Module.IndexSpace.Functions {
-		if wasmFn, ok := f.(*HostFunc); ok {
-			wasmFn.function = wasmFn.Generator(ins)
-			ins.Functions[i] = wasmFn
+	ins.Functions = make([]fn, len(ins.IndexSpace.Functions))
+	for i, f := range ins.IndexSpace.Functions {
+		if hostFn, ok := f.(*HostFunc); ok {
+			// the host func is shared by the instances importing it
+			hf := *hostFn
+			hf.generate(ins)
+			ins.Functions[i] = &hf
 		} else {
 			ins.Functions[i] = f
 		}
 	}
 
+	if ins.Engine == config.EngineClosure {
+		module.log("compiling closures")
+		// the funcs defined by the module, the imported ones are run by their own engine
+		for _, f := range ins.IndexSpace.Functions[len(ins.IndexSpace.Functions)-len(ins.FunctionSection):] {
+			ins.compileClosures(f.(*wasmFunc))
+		}
+	}
+
 	// initialize global
 	module.log("initializing globals")
-	ins.Globals = make([]uint64, len(ins.Module.IndexSpace.Globals))
-	for i, raw := range ins.Module.IndexSpace.Globals {
+	ins.Globals = make([]uint64, len(ins.IndexSpace.Globals))
+	for i, raw := range ins.IndexSpace.Globals {
 		switch v := raw.Val.(type) {
 		case int32:
 			ins.Globals[i] = uint64(v)
@@ -100,53 +111,23 @@ func NewInstance(module *Module, externModules map[string]*Module) (*Instance, e
 	return ins, nil
 }
 
-func (ins *Instance) fetchInt32() (int32, error) {
-	ret, num, err := leb128decode.DecodeInt32(bytes.NewReader(
-		ins.Active.Func.body[ins.Active.PC:]))
-	if err != nil {
-		return 0, err
-	}
-	ins.Active.PC += num - 1
-
-	return ret, nil
+// current returns the running instr of the active frame
+func (ins *Instance) current() *instruction {
+	return &ins.Active.Func.code[ins.Active.PC]
 }
 
-func (ins *Instance) fetchUint32() (uint32, error) {
-	ret, num, err := leb128decode.DecodeUint32(bytes.NewReader(
-		ins.Active.Func.body[ins.Active.PC:]))
-	if err != nil {
-		return 0, err
+// HostValue returns the value kept by the instance for the key, which is created by the init on the first call,
+// e.g. the state shared by the host funcs imported by the instance is created in their Generator
+func (ins *Instance) HostValue(key interface{}, init func() interface{}) interface{} {
+	if v, ok := ins.hostValues[key]; ok {
+		return v
 	}
 
-	ins.Active.PC += num - 1
-
-	return ret, nil
-}
-
-func (ins *Instance) fetchInt64() (int64, error) {
-	ret, num, err := leb128decode.DecodeInt64(bytes.NewReader(
-		ins.Active.Func.body[ins.Active.PC:]))
-	if err != nil {
-		return 0, err
+	if ins.hostValues == nil {
+		ins.hostValues = map[interface{}]interface{}{}
 	}
+	v := init()
+	ins.hostValues[key] = v
 
-	ins.Active.PC += num - 1
-
-	return ret, nil
-}
-
-func (ins *Instance) fetchFloat32() (float32, error) {
-	v := math.Float32frombits(binary.LittleEndian.Uint32(
-		ins.Active.Func.body[ins.Active.PC:]))
-	ins.Active.PC += 3
-
-	return v, nil
-}
-
-func (ins *Instance) fetchFloat64() (float64, error) {
-	v := math.Float64frombits(binary.LittleEndian.Uint64(
-		ins.Active.Func.body[ins.Active.PC:]))
-	ins.Active.PC += 7
-
-	return v, nil
+	return v
 }
@@ -2,8 +2,10 @@ package wasm
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"sync/atomic"
 
 	"github.com/hybridgroup/wasman/expr"
 	"github.com/hybridgroup/wasman/leb128decode"
@@ -14,11 +16,25 @@ import (
 
 // errors on exec func
 var (
-	ErrExportedFuncNotFound = errors.New("exported func is not found")
-	ErrFuncIndexOutOfRange  = errors.New("function index out of range")
-	ErrInvalidArgNum        = errors.New("invalid number of arguments")
+	ErrExportedFuncNotFound  = errors.New("exported func is not found")
+	ErrFuncIndexOutOfRange   = errors.New("function index out of range")
+	ErrInvalidArgNum         = errors.New("invalid number of arguments")
+	ErrInterrupted           = errors.New("execution interrupted")
+	ErrCallStackExhausted    = errors.New("call stack exhausted")
+	ErrOperandStackExhausted = errors.New("operand stack exhausted")
 )
 
+// ExitError is returned by the call when the guest exits with the Code,
+// e.g. the wasi proc_exit is called.
+// The host funcs can panic with an *ExitError to end the execution.
+type ExitError struct {
+	Code uint32
+}
+
+func (e *ExitError) Error() string {
+	return "exit status " + utils.IntToString(int(e.Code))
+}
+
 func (ins *Instance) execExpr(expression *expr.Expression) (v interface{}, err error) {
 	r := bytes.NewReader(expression.Data)
 	switch expression.OpCode {
@@ -57,13 +73,26 @@ func (ins *Instance) execExpr(expression *expr.Expression) (v interface{}, err e
 	return v, nil
 }
 
+// execFunc runs the compiled instrs of the active frame,
+// the control instrs jump by setting the PC to the instr before the next one to run
 func (ins *Instance) execFunc() error {
-	for ; int(ins.Active.PC) < len(ins.Active.Func.body); ins.Active.PC++ {
-		opByte := ins.Active.Func.body[ins.Active.PC]
-		op := expr.OpCode(opByte)
+	frame := ins.Active
+	code := frame.Func.code
+	for ; frame.PC < uint64(len(code)); frame.PC++ {
+		if atomic.LoadUint32(&ins.interrupted) != 0 {
+			return ErrInterrupted
+		}
+
+		op := code[frame.PC].OpCode
+		pc := frame.PC
 		err := instructions[op](ins)
 		if err != nil {
-			return err
+			ins.Active.PC = pc
+			return ins.trapOf(err, op)
+		}
+
+		if ins.OperandStackLimit != nil && uint64(ins.OperandStack.Ptr+1) > *ins.OperandStackLimit {
+			return ins.newTrap(ErrOperandStackExhausted, op)
 		}
 
 		// Toll
@@ -83,9 +112,118 @@ func (ins *Instance) execFunc() error {
 	return nil
 }
 
+// trapOf wraps the err raised by the instr of the active frame into a Trap
+func (ins *Instance) trapOf(err error, op expr.OpCode) error {
+	// the trap of the callee, or the exit from the host func
+	var trap *Trap
+	var exit *ExitError
+	if errors.As(err, &trap) || errors.As(err, &exit) || errors.Is(err, ErrInterrupted) {
+		return err
+	}
+
+	return ins.newTrap(err, op)
+}
+
+// Interrupt stops the running call of the instance with ErrInterrupted,
+// it is safe to be called from another goroutine
+func (ins *Instance) Interrupt() {
+	ins.interrupt(1)
+}
+
+// interrupt stops the call nested at the level and the ones nested in it,
+// the outer calls keep running
+func (ins *Instance) interrupt(level uint32) {
+	for {
+		old := atomic.LoadUint32(&ins.interrupted)
+		if (old != 0 && old <= level) || atomic.CompareAndSwapUint32(&ins.interrupted, old, level) {
+			return
+		}
+	}
+}
+
+// resume clears the interruption of the call nested at the level when it returns,
+// the interruption of the outer calls is kept
+func (ins *Instance) resume(level uint32) {
+	for {
+		old := atomic.LoadUint32(&ins.interrupted)
+		if old < level || atomic.CompareAndSwapUint32(&ins.interrupted, old, 0) {
+			return
+		}
+	}
+}
+
+// Interrupted reports whether the running call is interrupted,
+// the code running outside the interpreter should stop with ErrInterrupted
+func (ins *Instance) Interrupted() bool {
+	return atomic.LoadUint32(&ins.interrupted) != 0
+}
+
+// CallFunc calls the func at the idx of the function index space with the args during the running call,
+// e.g. the imported funcs called by the code generated by the GenerateGo
+func (ins *Instance) CallFunc(idx uint32, args ...uint64) ([]uint64, error) {
+	if int(idx) >= len(ins.Functions) {
+		return nil, ErrFuncIndexOutOfRange
+	}
+
+	f := ins.Functions[idx]
+	if len(f.getType().InputTypes) != len(args) {
+		return nil, ErrInvalidArgNum
+	}
+
+	prevOperandPtr, prevFramePtr, prevActive := ins.OperandStack.Ptr, ins.FrameStack.Ptr, ins.Active
+	for _, arg := range args {
+		ins.OperandStack.Push(arg)
+	}
+
+	if err := f.call(ins); err != nil {
+		ins.OperandStack.Ptr, ins.FrameStack.Ptr, ins.Active = prevOperandPtr, prevFramePtr, prevActive
+		return nil, err
+	}
+
+	ret := make([]uint64, len(f.getType().ReturnTypes))
+	for i := range ret {
+		ret[len(ret)-1-i] = ins.OperandStack.Pop()
+	}
+
+	return ret, nil
+}
+
+// SetFunc replaces the func at the idx of the function index space with the f of the same signature,
+// e.g. the funcs translated to Go by the GenerateGo
+func (ins *Instance) SetFunc(idx uint32, f RawHostFuncWithError) error {
+	if int(idx) >= len(ins.Functions) {
+		return ErrFuncIndexOutOfRange
+	}
+
+	ins.Functions[idx] = &HostFunc{Signature: ins.Functions[idx].getType(), function: f}
+
+	return nil
+}
+
+// ExportedFuncType returns the signature of the exported func `name`
+func (ins *Instance) ExportedFuncType(name string) (*types.FuncType, error) {
+	exp, ok := ins.Module.ExportSection[name]
+	if !ok || exp.Desc.Kind != segments.KindFunction {
+		return nil, ErrExportedFuncNotFound
+	}
+
+	if int(exp.Desc.Index) >= len(ins.Functions) {
+		return nil, ErrFuncIndexOutOfRange
+	}
+
+	return ins.Functions[exp.Desc.Index].getType(), nil
+}
+
 // CallExportedFunc will call the func `name` with the args
-// TODO: enhance this
 func (ins *Instance) CallExportedFunc(name string, args ...uint64) (returns []uint64, returnTypes []types.ValueType, err error) {
+	return ins.CallExportedFuncContext(context.Background(), name, args...)
+}
+
+// CallExportedFuncContext will call the func `name` with the args,
+// the call is interrupted with ErrInterrupted when the ctx is done.
+// It can be called by the host funcs during the call on the same instance,
+// the state of the outer call is restored when the nested one returns.
+func (ins *Instance) CallExportedFuncContext(ctx context.Context, name string, args ...uint64) (returns []uint64, returnTypes []types.ValueType, err error) {
 	exp, ok := ins.Module.ExportSection[name]
 	if !ok || exp.Desc.Kind != segments.KindFunction {
 		return nil, nil, ErrExportedFuncNotFound
@@ -100,12 +238,54 @@ func (ins *Instance) CallExportedFunc(name string, args ...uint64) (returns []ui
 		return nil, nil, ErrInvalidArgNum
 	}
 
+	if err := ctx.Err(); err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrInterrupted, err)
+	}
+
+	// the nested call from the host func keeps the interruption of the outer one,
+	// and the interruption of the nested one is cleared when it returns
+	if ins.calls == 0 {
+		atomic.StoreUint32(&ins.interrupted, 0)
+	}
+	prevCtx := ins.ctx
+	ins.ctx = ctx
+	ins.calls++
+	level := uint32(ins.calls)
+	defer func() {
+		ins.resume(level)
+		ins.calls--
+		ins.ctx = prevCtx
+	}()
+
+	if ctx.Done() != nil {
+		done := make(chan struct{})
+		stopped := make(chan struct{})
+		defer func() {
+			close(done)
+			<-stopped // the ctx can't interrupt the call after it returns
+		}()
+		go func() {
+			defer close(stopped)
+			select {
+			case <-ctx.Done():
+				ins.interrupt(level)
+			case <-done:
+			}
+		}()
+	}
+
+	// unwind every frame left by the failed call
+	prevOperandPtr, prevFramePtr, prevActive := ins.OperandStack.Ptr, ins.FrameStack.Ptr, ins.Active
 	for i := range args {
 		ins.OperandStack.Push(args[i])
 	}
 
 	err = f.call(ins)
 	if err != nil {
+		ins.OperandStack.Ptr, ins.FrameStack.Ptr, ins.Active = prevOperandPtr, prevFramePtr, prevActive
+		if errors.Is(err, ErrInterrupted) && ctx.Err() != nil {
+			err = fmt.Errorf("%w: %s", ErrInterrupted, ctx.Err())
+		}
 		return nil, nil, err
 	}
 
@@ -116,3 +296,72 @@ func (ins *Instance) CallExportedFunc(name string, args ...uint64) (returns []ui
 
 	return ret, f.getType().ReturnTypes, nil
 }
+
+// Call will call the func `name` with the typed args, returning the typed results,
+// the args should match the input types of the func.
+func (ins *Instance) Call(ctx context.Context, name string, args ...types.Value) ([]types.Value, error) {
+	ft, err := ins.ExportedFuncType(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ft.InputTypes) != len(args) {
+		return nil, ErrInvalidArgNum
+	}
+
+	raws := make([]uint64, len(args))
+	for i, arg := range args {
+		if arg.Type != ft.InputTypes[i] {
+			return nil, fmt.Errorf("%w: arg %d is %s, want %s", ErrFuncInvalidInputType, i, arg.Type, ft.InputTypes[i])
+		}
+		raws[i] = arg.Raw
+	}
+
+	ret, retTypes, err := ins.CallExportedFuncContext(ctx, name, raws...)
+	if err != nil {
+		return nil, err
+	}
+
+	vals := make([]types.Value, len(ret))
+	for i := range ret {
+		vals[i] = types.Value{Type: retTypes[i], Raw: ret[i]}
+	}
+
+	return vals, nil
+}
+
+// ExportedGlobal returns the typed value of the global exported with the name
+func (ins *Instance) ExportedGlobal(name string) (types.Value, bool) {
+	idx, ok := ins.exportedGlobalIndex(name)
+	if !ok {
+		return types.Value{}, false
+	}
+
+	return types.Value{Type: ins.IndexSpace.Globals[idx].ValType, Raw: ins.Globals[idx]}, true
+}
+
+// SetExportedGlobal sets the value of the mutable global exported with the name,
+// the val should have the type of the global
+func (ins *Instance) SetExportedGlobal(name string, val types.Value) bool {
+	idx, ok := ins.exportedGlobalIndex(name)
+	if !ok {
+		return false
+	}
+
+	gb := ins.IndexSpace.Globals[idx]
+	if !gb.Mutable || gb.ValType != val.Type {
+		return false
+	}
+
+	ins.Globals[idx] = val.Raw
+	return true
+}
+
+func (ins *Instance) exportedGlobalIndex(name string) (uint32, bool) {
+	exp, ok := ins.ExportSection[name]
+	if !ok || exp.Desc.Kind != segments.KindGlobal || int(exp.Desc.Index) >= len(ins.Globals) {
+		return 0, false
+	}
+
+	return exp.Desc.Index, true
+}
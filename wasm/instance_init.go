@@ -3,6 +3,8 @@ package wasm
 import (
 	"bytes"
 	"fmt"
+	"math"
+	"math/bits"
 
 	"github.com/hybridgroup/wasman/config"
 	"github.com/hybridgroup/wasman/expr"
@@ -25,9 +27,10 @@ func (ins *Instance) buildIndexSpaces(externModules map[string]*Module) error {
 	// note: MVP restricts the size of memory index spaces to 1
 	if diff := len(ins.TableSection) - len(ins.IndexSpace.Tables); diff > 0 {
 		for i := 0; i < diff; i++ {
+			tt := ins.TableSection[i+len(ins.IndexSpace.Tables)]
 			ins.IndexSpace.Tables = append(ins.IndexSpace.Tables, &Table{
-				TableType: *ins.TableSection[i+len(ins.IndexSpace.Tables)],
-				Value:     []*uint32{},
+				TableType: *tt,
+				Value:     make([]*uint32, tt.Limits.Min),
 			})
 		}
 	}
@@ -36,10 +39,25 @@ func (ins *Instance) buildIndexSpaces(externModules map[string]*Module) error {
 	// note: MVP restricts the size of memory index spaces to 1
 	if diff := len(ins.MemorySection) - len(ins.IndexSpace.Memories); diff > 0 {
 		for i := 0; i < diff; i++ {
-			ins.IndexSpace.Memories = append(ins.IndexSpace.Memories, &Memory{
+			mem := &Memory{
 				MemoryType: *ins.MemorySection[i+len(ins.IndexSpace.Memories)],
-				Value:      []byte{},
-			})
+				limit:      ins.ModuleConfig.MemoryLimitPages,
+			}
+			if mem.PageSizeLog2 == nil && ins.ModuleConfig.MemoryPageSize != 0 {
+				size := ins.ModuleConfig.MemoryPageSize
+				if size&(size-1) != 0 || size > config.DefaultMemoryPageSize {
+					return fmt.Errorf("%w: %d", ErrInvalidPageSize, size)
+				}
+
+				log2 := uint32(bits.TrailingZeros32(size))
+				mem.PageSizeLog2 = &log2
+			}
+			if mem.Min > mem.MaxPages() {
+				return fmt.Errorf("%w: %d pages are required", ErrMemoryLimitExceeded, mem.Min)
+			}
+
+			mem.Value = make([]byte, mem.pagesToBytes(mem.Min))
+			ins.IndexSpace.Memories = append(ins.IndexSpace.Memories, mem)
 		}
 	}
 
@@ -71,6 +89,10 @@ func (ins *Instance) resolveImports(externModules map[string]*Module) error {
 			return fmt.Errorf("%s not exported in module %s", is.Name, is.Module)
 		}
 
+		if em.IndexSpace == nil {
+			return fmt.Errorf("module %s has no index space to import from", is.Module)
+		}
+
 		if is.Desc.Kind != es.Desc.Kind {
 			return fmt.Errorf("type mismatch on export: got %#x but want %#x", es.Desc.Kind, is.Desc.Kind)
 		}
@@ -148,7 +170,7 @@ func (ins *Instance) applyGlobalImport(externModule *Module, exportSegment *segm
 		return fmt.Errorf("cannot import mutable global")
 	}
 
-	ins.IndexSpace.Globals = append(externModule.IndexSpace.Globals, gb)
+	ins.IndexSpace.Globals = append(ins.IndexSpace.Globals, gb)
 	return nil
 }
 
@@ -176,16 +198,19 @@ func (ins *Instance) buildFunctionIndexSpace() error {
 
 		f := &wasmFunc{
 			signature: ins.TypeSection[typeIndex],
+			index:     uint32(len(ins.IndexSpace.Functions)),
 			body:      ins.CodeSection[codeIndex].Body,
 			NumLocal:  ins.CodeSection[codeIndex].NumLocals,
 		}
 
-		brs, err := ins.parseBlocks(f.body)
+		f.name, _ = ins.FunctionName(f.index)
+
+		code, err := ins.compile(f.body)
 		if err != nil {
-			return fmt.Errorf("parse blocks: %w", err)
+			return fmt.Errorf("compile %s: %w", funcString(f.index, ""), err)
 		}
 
-		f.Blocks = brs
+		f.code = code
 		ins.IndexSpace.Functions = append(ins.IndexSpace.Functions, f)
 	}
 
@@ -195,10 +220,9 @@ func (ins *Instance) buildFunctionIndexSpace() error {
 func (ins *Instance) buildMemoryIndexSpace() error {
 	for _, d := range ins.Module.DataSection {
 		// note: MVP restricts the size of memory index spaces to 1
+		// the imported memories are in the index space too
 		if d.MemoryIndex >= uint32(len(ins.IndexSpace.Memories)) {
 			return fmt.Errorf("index out of range of index space")
-		} else if !ins.IndexSpace.Memories[0].External && d.MemoryIndex >= uint32(len(ins.MemorySection)) {
-			return fmt.Errorf("index out of range of memory section %d %d", d.MemoryIndex, len(ins.MemorySection))
 		}
 
 		rawOffset, err := ins.execExpr(d.OffsetExpression)
@@ -211,20 +235,13 @@ func (ins *Instance) buildMemoryIndexSpace() error {
 			return fmt.Errorf("type assertion failed")
 		}
 
-		size := int(offset) + len(d.Init)
-		if len(ins.MemorySection) > 0 && ins.MemorySection[d.MemoryIndex].Max != nil && uint32(size) > *(ins.MemorySection[d.MemoryIndex].Max)*config.DefaultMemoryPageSize {
-			return fmt.Errorf("memory size out of limit %d * 64Ki", int(*(ins.MemorySection[d.MemoryIndex].Max)))
-		}
-
+		// the memory is not grown for the segment, it must fit in the initial pages
 		memory := ins.IndexSpace.Memories[d.MemoryIndex]
-		if size > len(memory.Value) {
-			next := make([]byte, size)
-			copy(next, memory.Value)
-			copy(next[offset:], d.Init)
-			ins.IndexSpace.Memories[d.MemoryIndex].Value = next
-		} else {
-			copy(memory.Value[offset:], d.Init)
+		if end := uint64(uint32(offset)) + uint64(len(d.Init)); end > uint64(len(memory.Value)) {
+			return fmt.Errorf("%w: data segment ends at %d beyond the memory of %d bytes", ErrPtrOutOfBounds, end, len(memory.Value))
 		}
+
+		copy(memory.Value[uint32(offset):], d.Init)
 	}
 	return nil
 }
@@ -249,25 +266,15 @@ func (ins *Instance) buildTableIndexSpace() error {
 			return fmt.Errorf("type assertion failed")
 		}
 
-		offset := int(offset32)
-		size := offset + len(elem.Init)
-		if ins.TableSection[elem.TableIndex].Limits.Max != nil &&
-			size > int(*(ins.TableSection[elem.TableIndex].Limits.Max)) {
-			return fmt.Errorf("table size out of limit of %d", int(*(ins.TableSection[elem.TableIndex].Limits.Max)))
+		// the table is not grown for the segment, it must fit in the initial size
+		offset := uint64(uint32(offset32))
+		table := ins.IndexSpace.Tables[elem.TableIndex]
+		if end := offset + uint64(len(elem.Init)); end > uint64(len(table.Value)) {
+			return fmt.Errorf("%w: elem segment ends at %d beyond the table of %d elements", ErrPtrOutOfBounds, end, len(table.Value))
 		}
 
-		table := ins.IndexSpace.Tables[elem.TableIndex]
-		if size > len(table.Value) {
-			next := make([]*uint32, size)
-			copy(next, table.Value)
-			for i := range elem.Init {
-				next[i+offset] = &elem.Init[i]
-			}
-			ins.IndexSpace.Tables[elem.TableIndex].Value = next
-		} else {
-			for i := range elem.Init {
-				table.Value[i+offset] = &elem.Init[i]
-			}
+		for i := range elem.Init {
+			table.Value[offset+uint64(i)] = &elem.Init[i]
 		}
 	}
 	return nil
@@ -303,112 +310,105 @@ func (ins *Instance) readBlockType(r utils.Reader) (*blockType, uint64, error) {
 	return ret, l, nil
 }
 
-func (ins *Instance) parseBlocks(body []byte) (map[uint64]*funcBlock, error) {
-	ret := map[uint64]*funcBlock{}
-	stack := make([]*funcBlock, 0)
-	for pc := uint64(0); pc < uint64(len(body)); pc++ {
-		rawOc := body[pc]
-		if 0x28 <= rawOc && rawOc <= 0x3e { // memory load,store
-			pc++
-			// align
-			_, l, err := leb128decode.DecodeUint32(bytes.NewReader(body[pc:]))
-			if err != nil {
-				return nil, fmt.Errorf("read memory align: %w", err)
-			}
-			pc += l
-			// offset
-			_, l, err = leb128decode.DecodeUint32(bytes.NewReader(body[pc:]))
-			if err != nil {
-				return nil, fmt.Errorf("read memory offset: %w", err)
-			}
-			pc += l - 1
-			continue
-		} else if 0x41 <= rawOc && rawOc <= 0x44 { // const instructions
-			pc++
-			switch expr.OpCode(rawOc) {
-			case expr.OpCodeI32Const:
-				_, l, err := leb128decode.DecodeInt32(bytes.NewReader(body[pc:]))
-				if err != nil {
-					return nil, fmt.Errorf("read immediate: %w", err)
-				}
-				pc += l - 1
-			case expr.OpCodeI64Const:
-				_, l, err := leb128decode.DecodeInt64(bytes.NewReader(body[pc:]))
-				if err != nil {
-					return nil, fmt.Errorf("read immediate: %w", err)
-				}
-				pc += l - 1
-			case expr.OpCodeF32Const:
-				pc += 3
-			case expr.OpCodeF64Const:
-				pc += 7
-			}
-			continue
-		} else if (0x3f <= rawOc && rawOc <= 0x40) || // memory grow,size
-			(0x20 <= rawOc && rawOc <= 0x24) || // variable instructions
-			(0x0c <= rawOc && rawOc <= 0x0d) || // br,br_if instructions
-			(0x10 <= rawOc && rawOc <= 0x11) { // call,call_indirect
-			pc++
-			_, l, err := leb128decode.DecodeUint32(bytes.NewReader(body[pc:]))
-			if err != nil {
-				return nil, fmt.Errorf("read immediate: %w", err)
-			}
-			pc += l - 1
-			if rawOc == 0x11 { // if call_indirect
-				pc++
-			}
-			continue
-		} else if rawOc == 0x0e { // br_table
-			pc++
-			r := bytes.NewReader(body[pc:])
-			nl, num, err := leb128decode.DecodeUint32(r)
-			if err != nil {
-				return nil, fmt.Errorf("read immediate: %w", err)
-			}
+// funcLabel is the label of the func body, the branch to it returns from the func
+const funcLabel = math.MaxUint64
+
+// compile decodes the body into the instrs run by the execFunc,
+// the blocks are checked and the branches are resolved to the index of their targets
+func (ins *Instance) compile(body []byte) ([]instruction, error) {
+	code := make([]instruction, 0, len(body)/2)
+	blocks := make([]uint64, 0) // the enclosing block, loop and if
+	elses := map[uint64]uint64{}
+	ends := map[uint64]uint64{}
+
+	// label returns the block of the label, or the funcLabel
+	label := func(depth uint64) (uint64, error) {
+		switch n := uint64(len(blocks)); {
+		case depth < n:
+			return blocks[n-1-depth], nil
+		case depth == n:
+			return funcLabel, nil
+		default:
+			return 0, ErrLabelNotFound
+		}
+	}
 
-			for i := uint32(0); i < nl; i++ {
-				_, n, err := leb128decode.DecodeUint32(r)
-				if err != nil {
-					return nil, fmt.Errorf("read immediate: %w", err)
-				}
-				num += n
-			}
+	for pc := uint64(0); pc < uint64(len(body)); {
+		in, err := decodeInstruction(body, pc)
+		if err != nil {
+			return nil, err
+		}
 
-			_, l, err := leb128decode.DecodeUint32(r)
-			if err != nil {
-				return nil, fmt.Errorf("read immediate: %w", err)
-			}
-			pc += l + num - 1
-			continue
+		if instructions[in.OpCode] == nil {
+			return nil, fmt.Errorf("%w: unknown opcode %#x at %#x", ErrInvalidInstruction, in.OpCode, pc)
 		}
 
-		switch expr.OpCode(rawOc) {
+		at := uint64(len(code))
+		switch in.OpCode {
 		case expr.OpCodeBlock, expr.OpCodeIf, expr.OpCodeLoop:
-			bt, l, err := ins.readBlockType(bytes.NewReader(body[pc+1:]))
+			_, _, err := ins.readBlockType(bytes.NewReader(body[pc+1:]))
 			if err != nil {
 				return nil, fmt.Errorf("read block: %w", err)
 			}
-			stack = append(stack, &funcBlock{
-				StartAt:        pc,
-				BlockType:      bt,
-				BlockTypeBytes: l,
-			})
-			pc += l
+			blocks = append(blocks, at)
 		case expr.OpCodeElse:
-			stack[len(stack)-1].ElseAt = pc
+			if len(blocks) == 0 || code[blocks[len(blocks)-1]].OpCode != expr.OpCodeIf {
+				return nil, fmt.Errorf("else without if at %#x", pc)
+			}
+			elses[blocks[len(blocks)-1]] = at
+			in.Targets = []uint64{blocks[len(blocks)-1]}
 		case expr.OpCodeEnd:
-			if len(stack) > 0 {
-				bl := stack[len(stack)-1]
-				stack = stack[:len(stack)-1]
-				bl.EndAt = pc
-				ret[bl.StartAt] = bl
+			if len(blocks) > 0 {
+				ends[blocks[len(blocks)-1]] = at
+				blocks = blocks[:len(blocks)-1]
+			}
+		case expr.OpCodeBr, expr.OpCodeBrIf, expr.OpCodeBrTable:
+			in.Targets = make([]uint64, len(in.Immediates))
+			for i, depth := range in.Immediates {
+				in.Targets[i], err = label(depth)
+				if err != nil {
+					return nil, fmt.Errorf("%w: %s at %#x", err, expr.GetOpCodeName(in.OpCode), pc)
+				}
 			}
 		}
+
+		code = append(code, *in)
+		pc = in.Next
 	}
 
-	if len(stack) > 0 {
+	if len(blocks) > 0 {
 		return nil, fmt.Errorf("ill-nested block exists")
 	}
 
-	return ret, nil
+	// the labels of the loops are their starts, and the others are their ends
+	target := func(block uint64) uint64 {
+		switch {
+		case block == funcLabel:
+			return uint64(len(code)) - 1
+		case code[block].OpCode == expr.OpCodeLoop:
+			return block
+		default:
+			return ends[block]
+		}
+	}
+
+	for i := range code {
+		in := &code[i]
+		switch in.OpCode {
+		case expr.OpCodeIf:
+			if at, ok := elses[uint64(i)]; ok {
+				in.Targets = []uint64{at}
+			} else {
+				in.Targets = []uint64{ends[uint64(i)]}
+			}
+		case expr.OpCodeElse:
+			in.Targets[0] = ends[in.Targets[0]]
+		case expr.OpCodeBr, expr.OpCodeBrIf, expr.OpCodeBrTable:
+			for j, block := range in.Targets {
+				in.Targets[j] = target(block)
+			}
+		}
+	}
+
+	return code, nil
 }
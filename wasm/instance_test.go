@@ -2,16 +2,86 @@ package wasm
 
 import (
 	"bytes"
+	"errors"
 	"reflect"
 	"testing"
 
 	"github.com/hybridgroup/wasman/utils"
 
+	"github.com/hybridgroup/wasman/config"
 	"github.com/hybridgroup/wasman/expr"
 	"github.com/hybridgroup/wasman/segments"
 	"github.com/hybridgroup/wasman/types"
 )
 
+func TestNewInstance(t *testing.T) {
+	i32 := types.ValueTypeI32
+	host := &Module{
+		IndexSpace: &IndexSpace{Functions: []fn{&HostFunc{
+			Signature: &types.FuncType{ReturnTypes: []types.ValueType{i32}},
+			Generator: func(ins *Instance) RawHostFunc {
+				return func([]uint64) []uint64 {
+					return []uint64{uint64(ins.Memory.Value[0])}
+				}
+			},
+		}}},
+		ExportSection: map[string]*segments.ExportSegment{
+			"peek": {Name: "peek", Desc: &segments.ExportDesc{Kind: segments.KindFunction}},
+		},
+	}
+	mod := &Module{
+		TypeSection: []*types.FuncType{{ReturnTypes: []types.ValueType{i32}}, {InputTypes: []types.ValueType{i32}}},
+		ImportSection: []*segments.ImportSegment{
+			{Module: "host", Name: "peek", Desc: &segments.ImportDesc{Kind: segments.KindFunction, TypeIndexPtr: utils.Uint32Ptr(0)}},
+		},
+		FunctionSection: []uint32{1},
+		MemorySection:   []*types.MemoryType{{Min: 1}},
+		CodeSection: []*segments.CodeSegment{
+			{Body: []byte{
+				byte(expr.OpCodeI32Const), 0x00,
+				byte(expr.OpCodeLocalGet), 0x00,
+				byte(expr.OpCodeI32Store8), 0x00, 0x00,
+				byte(expr.OpCodeEnd),
+			}},
+		},
+		DataSection: []*segments.DataSegment{
+			{OffsetExpression: &expr.Expression{OpCode: expr.OpCodeI32Const, Data: []byte{0x00}}, Init: []byte{0x01}},
+		},
+		ExportSection: map[string]*segments.ExportSegment{
+			"peek": {Name: "peek", Desc: &segments.ExportDesc{Kind: segments.KindFunction, Index: 0}},
+			"poke": {Name: "poke", Desc: &segments.ExportDesc{Kind: segments.KindFunction, Index: 1}},
+		},
+	}
+	externs := map[string]*Module{"host": host}
+
+	ins1, err := NewInstance(mod, externs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ins2, err := NewInstance(mod, externs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mod.IndexSpace != nil {
+		t.Fail()
+	}
+
+	if _, _, err := ins1.CallExportedFunc("poke", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// the instances do not share the memory nor the host funcs
+	if ret, _, err := ins1.CallExportedFunc("peek"); err != nil || ret[0] != 2 {
+		t.Logf("peek: %v, %v", ret, err)
+		t.Fail()
+	}
+	if ret, _, err := ins2.CallExportedFunc("peek"); err != nil || ret[0] != 1 {
+		t.Logf("peek: %v, %v", ret, err)
+		t.Fail()
+	}
+}
+
 func TestInstance_executeConstExpression(t *testing.T) {
 	t.Run("error", func(t *testing.T) {
 		for _, expression := range []*expr.Expression{
@@ -394,16 +464,156 @@ func TestModule_buildFunctionIndexSpace(t *testing.T) {
 	})
 }
 
+func TestNewInstance_memory(t *testing.T) {
+	mod := &Module{
+		TypeSection:     []*types.FuncType{{ReturnTypes: []types.ValueType{types.ValueTypeI32}}},
+		FunctionSection: []uint32{0},
+		MemorySection:   []*types.MemoryType{{Min: 3, Max: utils.Uint32Ptr(5)}},
+		CodeSection: []*segments.CodeSegment{
+			{Body: []byte{byte(expr.OpCodeI32Const), 0x01, byte(expr.OpCodeMemoryGrow), 0x00, byte(expr.OpCodeEnd)}},
+		},
+		ExportSection: map[string]*segments.ExportSegment{
+			"grow": {Name: "grow", Desc: &segments.ExportDesc{Kind: segments.KindFunction, Index: 0}},
+		},
+	}
+
+	for _, c := range []struct {
+		limit *uint32
+		grown []uint32 // the results of growing
+	}{
+		{limit: nil, grown: []uint32{3, 4, 0xffffffff}},
+		{limit: utils.Uint32Ptr(4), grown: []uint32{3, 0xffffffff}},
+	} {
+		m := *mod
+		m.ModuleConfig.MemoryLimitPages = c.limit
+		ins, err := NewInstance(&m, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ins.Memory.PageSize() != 3 {
+			t.Logf("pages: %d", ins.Memory.PageSize())
+			t.Fail()
+		}
+
+		for _, exp := range c.grown {
+			if ret, _, err := ins.CallExportedFunc("grow"); err != nil || uint32(ret[0]) != exp {
+				t.Logf("grow: %v, %v", ret, err)
+				t.Fail()
+			}
+		}
+	}
+
+	// the declared minimum is over the limit
+	m := *mod
+	m.ModuleConfig.MemoryLimitPages = utils.Uint32Ptr(2)
+	if _, err := NewInstance(&m, nil); !errors.Is(err, ErrMemoryLimitExceeded) {
+		t.Logf("err: %v", err)
+		t.Fail()
+	}
+}
+
+func TestNewInstance_segmentsOutOfBounds(t *testing.T) {
+	data := func(offset ...byte) *Module {
+		return &Module{
+			MemorySection: []*types.MemoryType{{Min: 1}},
+			DataSection: []*segments.DataSegment{
+				{OffsetExpression: &expr.Expression{OpCode: expr.OpCodeI32Const, Data: offset}, Init: []byte{0x01, 0x02}},
+			},
+		}
+	}
+
+	// the last bytes of the page
+	ins, err := NewInstance(data(0xfe, 0xff, 0x03), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ins.Memory.Value) != config.DefaultMemoryPageSize || ins.Memory.Value[0xffff] != 0x02 {
+		t.Logf("memory: %d bytes", len(ins.Memory.Value))
+		t.Fail()
+	}
+
+	for i, m := range []*Module{
+		data(0xff, 0xff, 0x03),
+		data(0x7f), // -1 is the offset 0xffffffff
+		{
+			TypeSection:     []*types.FuncType{{}},
+			FunctionSection: []uint32{0},
+			CodeSection:     []*segments.CodeSegment{{Body: []byte{byte(expr.OpCodeEnd)}}},
+			TableSection:    []*types.TableType{{Elem: 0x70, Limits: &types.Limits{Min: 1}}},
+			ElementsSection: []*segments.ElemSegment{
+				{OffsetExpr: &expr.Expression{OpCode: expr.OpCodeI32Const, Data: []byte{0x00}}, Init: []uint32{0, 0}},
+			},
+		},
+	} {
+		if _, err := NewInstance(m, nil); !errors.Is(err, ErrPtrOutOfBounds) {
+			t.Logf("%d: %v", i, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestNewInstance_pageSize(t *testing.T) {
+	mod := &Module{
+		TypeSection:     []*types.FuncType{{ReturnTypes: []types.ValueType{types.ValueTypeI32}}},
+		FunctionSection: []uint32{0},
+		CodeSection: []*segments.CodeSegment{
+			{Body: []byte{
+				byte(expr.OpCodeI32Const), 0x01, byte(expr.OpCodeMemoryGrow), 0x00, byte(expr.OpCodeDrop),
+				byte(expr.OpCodeMemorySize), 0x00,
+				byte(expr.OpCodeEnd),
+			}},
+		},
+		ExportSection: map[string]*segments.ExportSegment{
+			"grow": {Name: "grow", Desc: &segments.ExportDesc{Kind: segments.KindFunction, Index: 0}},
+		},
+	}
+
+	for i, c := range []struct {
+		pageSize uint32
+		mem      *types.MemoryType
+		bytes    int
+	}{
+		{pageSize: 0, mem: &types.MemoryType{Min: 1}, bytes: 65536},
+		{pageSize: 16384, mem: &types.MemoryType{Min: 2}, bytes: 16384 * 2},
+		// the custom page size of the memory is preferred
+		{pageSize: 16384, mem: &types.MemoryType{Min: 10, PageSizeLog2: utils.Uint32Ptr(0)}, bytes: 10},
+	} {
+		t.Run(utils.IntToString(i), func(t *testing.T) {
+			m := *mod
+			m.MemorySection = []*types.MemoryType{c.mem}
+			m.ModuleConfig.MemoryPageSize = c.pageSize
+			ins, err := NewInstance(&m, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(ins.Memory.Value) != c.bytes {
+				t.Logf("bytes: %d", len(ins.Memory.Value))
+				t.Fail()
+			}
+
+			ret, _, err := ins.CallExportedFunc("grow")
+			if err != nil || uint32(ret[0]) != c.mem.Min+1 || uint64(len(ins.Memory.Value)) != uint64(c.bytes)+ins.Memory.BytesPerPage() {
+				t.Logf("grow: %v, %v, %d", ret, err, len(ins.Memory.Value))
+				t.Fail()
+			}
+		})
+	}
+
+	m := *mod
+	m.MemorySection = []*types.MemoryType{{Min: 1}}
+	m.ModuleConfig.MemoryPageSize = 3000
+	if _, err := NewInstance(&m, nil); !errors.Is(err, ErrInvalidPageSize) {
+		t.Logf("err: %v", err)
+		t.Fail()
+	}
+}
+
 func TestModule_buildMemoryIndexSpace(t *testing.T) {
 	t.Run("error", func(t *testing.T) {
 		for _, m := range []*Module{
 			{DataSection: []*segments.DataSegment{{MemoryIndex: 1}}, IndexSpace: new(IndexSpace)},
-			{DataSection: []*segments.DataSegment{{MemoryIndex: 0}}, IndexSpace: &IndexSpace{
-				Memories: []*Memory{
-					{Value: []byte{}},
-				},
-			}},
-
 			{
 				DataSection:   []*segments.DataSegment{{OffsetExpression: &expr.Expression{}}},
 				MemorySection: []*types.MemoryType{{}},
@@ -421,10 +631,39 @@ func TestModule_buildMemoryIndexSpace(t *testing.T) {
 					},
 				},
 				MemorySection: []*types.MemoryType{{Max: utils.Uint32Ptr(0)}},
+				IndexSpace: &IndexSpace{Memories: []*Memory{
+					{MemoryType: types.MemoryType{Max: utils.Uint32Ptr(0)}, Value: []byte{}},
+				}},
+			},
+			// the segments do not grow the memory
+			{
+				DataSection: []*segments.DataSegment{
+					{
+						OffsetExpression: &expr.Expression{
+							OpCode: expr.OpCodeI32Const, Data: []byte{0x00},
+						},
+						Init: []byte{0x01, 0x01},
+					},
+				},
+				MemorySection: []*types.MemoryType{{}},
 				IndexSpace: &IndexSpace{Memories: []*Memory{
 					{Value: []byte{}},
 				}},
 			},
+			{
+				DataSection: []*segments.DataSegment{
+					{
+						OffsetExpression: &expr.Expression{
+							OpCode: expr.OpCodeI32Const, Data: []byte{0x02},
+						},
+						Init: []byte{0x01, 0x01},
+					},
+				},
+				MemorySection: []*types.MemoryType{{}},
+				IndexSpace: &IndexSpace{Memories: []*Memory{
+					{Value: []byte{0x00, 0x00, 0x00}},
+				}},
+			},
 		} {
 			ins := &Instance{Module: m}
 			err := ins.buildMemoryIndexSpace()
@@ -440,24 +679,6 @@ func TestModule_buildMemoryIndexSpace(t *testing.T) {
 			m   *Module
 			exp []*Memory
 		}{
-			{
-				m: &Module{
-					DataSection: []*segments.DataSegment{
-						{
-							OffsetExpression: &expr.Expression{
-								OpCode: expr.OpCodeI32Const,
-								Data:   []byte{0x00},
-							},
-							Init: []byte{0x01, 0x01},
-						},
-					},
-					MemorySection: []*types.MemoryType{{}},
-					IndexSpace: &IndexSpace{Memories: []*Memory{
-						{Value: []byte{}},
-					}},
-				},
-				exp: []*Memory{{Value: []byte{0x01, 0x01}}},
-			},
 			{
 				m: &Module{
 					DataSection: []*segments.DataSegment{
@@ -496,24 +717,6 @@ func TestModule_buildMemoryIndexSpace(t *testing.T) {
 				},
 				exp: []*Memory{{Value: []byte{0x00, 0x01, 0x01}}},
 			},
-			{
-				m: &Module{
-					DataSection: []*segments.DataSegment{
-						{
-							OffsetExpression: &expr.Expression{
-								OpCode: expr.OpCodeI32Const,
-								Data:   []byte{0x02},
-							},
-							Init: []byte{0x01, 0x01},
-						},
-					},
-					MemorySection: []*types.MemoryType{{}},
-					IndexSpace: &IndexSpace{Memories: []*Memory{
-						{Value: []byte{0x00, 0x00, 0x00}},
-					}},
-				},
-				exp: []*Memory{{Value: []byte{0x00, 0x00, 0x01, 0x01}}},
-			},
 			{
 				m: &Module{
 					DataSection: []*segments.DataSegment{
@@ -601,6 +804,21 @@ func TestModule_buildTableIndexSpace(t *testing.T) {
 					{Value: []*uint32{}},
 				}},
 			},
+			// the segments do not grow the table
+			{
+				ElementsSection: []*segments.ElemSegment{{
+					TableIndex: 0,
+					OffsetExpr: &expr.Expression{
+						OpCode: expr.OpCodeI32Const,
+						Data:   []byte{0x1},
+					},
+					Init: []uint32{0x1, 0x1},
+				}},
+				TableSection: []*types.TableType{{Limits: &types.Limits{}}},
+				IndexSpace: &IndexSpace{Tables: []*Table{
+					{Value: []*uint32{nil, nil}},
+				}},
+			},
 		} {
 			err := (&Instance{Module: m}).buildTableIndexSpace()
 			if err == nil {
@@ -615,25 +833,6 @@ func TestModule_buildTableIndexSpace(t *testing.T) {
 			m   *Module
 			exp []*Table
 		}{
-			{
-				m: &Module{
-					ElementsSection: []*segments.ElemSegment{{
-						TableIndex: 0,
-						OffsetExpr: &expr.Expression{
-							OpCode: expr.OpCodeI32Const,
-							Data:   []byte{0x0},
-						},
-						Init: []uint32{0x1, 0x1},
-					}},
-					TableSection: []*types.TableType{{Limits: &types.Limits{}}},
-					IndexSpace: &IndexSpace{Tables: []*Table{
-						{Value: []*uint32{}},
-					}},
-				},
-				exp: []*Table{
-					{Value: []*uint32{utils.Uint32Ptr(0x01), utils.Uint32Ptr(0x01)}},
-				},
-			},
 			{
 				m: &Module{
 					ElementsSection: []*segments.ElemSegment{{
@@ -761,269 +960,93 @@ func TestModule_readBlockType(t *testing.T) {
 	}
 }
 
-func TestModule_parseBlocks(t *testing.T) {
+func TestInstance_compile(t *testing.T) {
 	m := &Module{TypeSection: []*types.FuncType{{}, {}}}
-	for i, c := range []struct {
-		body []byte
-		exp  map[uint64]*funcBlock
-	}{
-		{
-			body: []byte{byte(expr.OpCodeBlock), 0x1, 0x0, byte(expr.OpCodeEnd)},
-			exp: map[uint64]*funcBlock{
-				0: {
-					StartAt:        0,
-					EndAt:          3,
-					BlockType:      &types.FuncType{},
-					BlockTypeBytes: 1,
-				},
-			},
-		},
-		{
-			body: []byte{byte(expr.OpCodeBlock), 0x1, byte(expr.OpCodeI32Load), 0x00, 0x0, byte(expr.OpCodeEnd)},
-			exp: map[uint64]*funcBlock{
-				0: {
-					StartAt:        0,
-					EndAt:          5,
-					BlockType:      &types.FuncType{},
-					BlockTypeBytes: 1,
-				},
-			},
-		},
-		{
-			body: []byte{byte(expr.OpCodeBlock), 0x1, byte(expr.OpCodeI64Store32), 0x00, 0x0, byte(expr.OpCodeEnd)},
-			exp: map[uint64]*funcBlock{
-				0: {
-					StartAt:        0,
-					EndAt:          5,
-					BlockType:      &types.FuncType{},
-					BlockTypeBytes: 1,
-				},
-			},
-		},
-		{
-			body: []byte{byte(expr.OpCodeBlock), 0x1, byte(expr.OpCodeMemoryGrow), 0x00, byte(expr.OpCodeEnd)},
-			exp: map[uint64]*funcBlock{
-				0: {
-					StartAt:        0,
-					EndAt:          4,
-					BlockType:      &types.FuncType{},
-					BlockTypeBytes: 1,
-				},
-			},
-		},
-		{
-			body: []byte{byte(expr.OpCodeBlock), 0x1, byte(expr.OpCodeMemorySize), 0x00, byte(expr.OpCodeEnd)},
-			exp: map[uint64]*funcBlock{
-				0: {
-					StartAt:        0,
-					EndAt:          4,
-					BlockType:      &types.FuncType{},
-					BlockTypeBytes: 1,
-				},
-			},
-		},
-		{
-			body: []byte{byte(expr.OpCodeBlock), 0x1, byte(expr.OpCodeI32Const), 0x02, byte(expr.OpCodeEnd)},
-			exp: map[uint64]*funcBlock{
-				0: {
-					StartAt:        0,
-					EndAt:          4,
-					BlockType:      &types.FuncType{},
-					BlockTypeBytes: 1,
-				},
-			},
-		},
-		{
-			body: []byte{byte(expr.OpCodeBlock), 0x1, byte(expr.OpCodeI64Const), 0x02, byte(expr.OpCodeEnd)},
-			exp: map[uint64]*funcBlock{
-				0: {
-					StartAt:        0,
-					EndAt:          4,
-					BlockType:      &types.FuncType{},
-					BlockTypeBytes: 1,
-				},
-			},
-		},
-		{
-			body: []byte{byte(expr.OpCodeBlock), 0x1,
-				byte(expr.OpCodeF32Const), 0x02, 0x02, 0x02, 0x02,
-				byte(expr.OpCodeEnd),
-			},
-			exp: map[uint64]*funcBlock{
-				0: {
-					StartAt:        0,
-					EndAt:          7,
-					BlockType:      &types.FuncType{},
-					BlockTypeBytes: 1,
-				},
-			},
-		},
-		{
-			body: []byte{byte(expr.OpCodeBlock), 0x1,
-				byte(expr.OpCodeF64Const), 0x02, 0x02, 0x02, 0x02, 0x02, 0x02, 0x02, 0x02,
-				byte(expr.OpCodeEnd),
-			},
-			exp: map[uint64]*funcBlock{
-				0: {
-					StartAt:        0,
-					EndAt:          11,
-					BlockType:      &types.FuncType{},
-					BlockTypeBytes: 1,
-				},
-			},
-		},
-		{
-			body: []byte{byte(expr.OpCodeBlock), 0x1, byte(expr.OpCodeLocalGet), 0x02, byte(expr.OpCodeEnd)},
-			exp: map[uint64]*funcBlock{
-				0: {
-					StartAt:        0,
-					EndAt:          4,
-					BlockType:      &types.FuncType{},
-					BlockTypeBytes: 1,
-				},
-			},
-		},
-		{
-			body: []byte{byte(expr.OpCodeBlock), 0x1, byte(expr.OpCodeGlobalSet), 0x03, byte(expr.OpCodeEnd)},
-			exp: map[uint64]*funcBlock{
-				0: {
-					StartAt:        0,
-					EndAt:          4,
-					BlockType:      &types.FuncType{},
-					BlockTypeBytes: 1,
-				},
-			},
-		},
-		{
-			body: []byte{byte(expr.OpCodeBlock), 0x1, byte(expr.OpCodeGlobalSet), 0x03, byte(expr.OpCodeEnd)},
-			exp: map[uint64]*funcBlock{
-				0: {
-					StartAt:        0,
-					EndAt:          4,
-					BlockType:      &types.FuncType{},
-					BlockTypeBytes: 1,
-				},
-			},
-		},
-		{
-			body: []byte{byte(expr.OpCodeBlock), 0x1, byte(expr.OpCodeBr), 0x03, byte(expr.OpCodeEnd)},
-			exp: map[uint64]*funcBlock{
-				0: {
-					StartAt:        0,
-					EndAt:          4,
-					BlockType:      &types.FuncType{},
-					BlockTypeBytes: 1,
-				},
-			},
-		},
-		{
-			body: []byte{byte(expr.OpCodeBlock), 0x1, byte(expr.OpCodeBrIf), 0x03, byte(expr.OpCodeEnd)},
-			exp: map[uint64]*funcBlock{
-				0: {
-					StartAt:        0,
-					EndAt:          4,
-					BlockType:      &types.FuncType{},
-					BlockTypeBytes: 1,
-				},
-			},
-		},
-		{
-			body: []byte{byte(expr.OpCodeBlock), 0x1, byte(expr.OpCodeCall), 0x03, byte(expr.OpCodeEnd)},
-			exp: map[uint64]*funcBlock{
-				0: {
-					StartAt:        0,
-					EndAt:          4,
-					BlockType:      &types.FuncType{},
-					BlockTypeBytes: 1,
-				},
-			},
-		},
-		{
-			body: []byte{byte(expr.OpCodeBlock), 0x1, byte(expr.OpCodeCallIndirect), 0x03, 0x00, byte(expr.OpCodeEnd)},
-			exp: map[uint64]*funcBlock{
-				0: {
-					StartAt:        0,
-					EndAt:          5,
-					BlockType:      &types.FuncType{},
-					BlockTypeBytes: 1,
-				},
-			},
-		},
-		{
-			body: []byte{byte(expr.OpCodeBlock), 0x1, byte(expr.OpCodeBrTable),
-				0x03, 0x01, 0x01, 0x01, 0x01, byte(expr.OpCodeEnd),
+	t.Run("ok", func(t *testing.T) {
+		for i, c := range []struct {
+			body []byte
+			exp  map[int][]uint64 // the targets of the instrs
+			len  int
+		}{
+			{
+				body: []byte{byte(expr.OpCodeBlock), 0x1, byte(expr.OpCodeI32Load), 0x00, 0x0, byte(expr.OpCodeEnd)},
+				exp:  map[int][]uint64{},
+				len:  3,
 			},
-			exp: map[uint64]*funcBlock{
-				0: {
-					StartAt:        0,
-					EndAt:          8,
-					BlockType:      &types.FuncType{},
-					BlockTypeBytes: 1,
+			{
+				body: []byte{byte(expr.OpCodeBlock), 0x1,
+					byte(expr.OpCodeF64Const), 0x02, 0x02, 0x02, 0x02, 0x02, 0x02, 0x02, 0x02,
+					byte(expr.OpCodeBr), 0x00, byte(expr.OpCodeEnd),
 				},
+				exp: map[int][]uint64{2: {3}},
+				len: 4,
 			},
-		},
-		{
-			body: []byte{byte(expr.OpCodeNop),
-				byte(expr.OpCodeBlock), 0x1, byte(expr.OpCodeCallIndirect), 0x03, 0x00, byte(expr.OpCodeEnd),
-				byte(expr.OpCodeIf), 0x1, byte(expr.OpCodeLocalGet), 0x02,
-				byte(expr.OpCodeElse), byte(expr.OpCodeLocalGet), 0x02,
-				byte(expr.OpCodeEnd),
+			{
+				body: []byte{byte(expr.OpCodeLoop), 0x40, byte(expr.OpCodeI32Const), 0x01, byte(expr.OpCodeBrIf), 0x00, byte(expr.OpCodeEnd)},
+				exp:  map[int][]uint64{2: {0}},
+				len:  4,
 			},
-			exp: map[uint64]*funcBlock{
-				1: {
-					StartAt:        1,
-					EndAt:          6,
-					BlockType:      &types.FuncType{},
-					BlockTypeBytes: 1,
-				},
-				7: {
-					StartAt:        7,
-					ElseAt:         11,
-					EndAt:          14,
-					BlockType:      &types.FuncType{},
-					BlockTypeBytes: 1,
+			{
+				body: []byte{byte(expr.OpCodeBlock), 0x1, byte(expr.OpCodeBrTable),
+					0x01, 0x00, 0x01, byte(expr.OpCodeEnd), byte(expr.OpCodeEnd),
 				},
+				exp: map[int][]uint64{1: {2, 3}},
+				len: 4,
 			},
-		},
-		{
-			body: []byte{byte(expr.OpCodeNop),
-				byte(expr.OpCodeBlock), 0x1, byte(expr.OpCodeCallIndirect), 0x03, 0x00, byte(expr.OpCodeEnd),
-				byte(expr.OpCodeIf), 0x1, byte(expr.OpCodeLocalGet), 0x02,
-				byte(expr.OpCodeElse), byte(expr.OpCodeLocalGet), 0x02,
-				byte(expr.OpCodeIf), 0x01, byte(expr.OpCodeLocalGet), 0x02, byte(expr.OpCodeEnd),
-				byte(expr.OpCodeEnd),
-			},
-			exp: map[uint64]*funcBlock{
-				1: {
-					StartAt:        1,
-					EndAt:          6,
-					BlockType:      &types.FuncType{},
-					BlockTypeBytes: 1,
-				},
-				7: {
-					StartAt:        7,
-					ElseAt:         11,
-					EndAt:          19,
-					BlockType:      &types.FuncType{},
-					BlockTypeBytes: 1,
-				},
-				14: {
-					StartAt:        14,
-					EndAt:          18,
-					BlockType:      &types.FuncType{},
-					BlockTypeBytes: 1,
+			{
+				body: []byte{byte(expr.OpCodeNop),
+					byte(expr.OpCodeBlock), 0x1, byte(expr.OpCodeCallIndirect), 0x03, 0x00, byte(expr.OpCodeEnd),
+					byte(expr.OpCodeIf), 0x1, byte(expr.OpCodeLocalGet), 0x02,
+					byte(expr.OpCodeElse), byte(expr.OpCodeLocalGet), 0x02,
+					byte(expr.OpCodeIf), 0x01, byte(expr.OpCodeLocalGet), 0x02, byte(expr.OpCodeEnd),
+					byte(expr.OpCodeEnd),
 				},
+				exp: map[int][]uint64{4: {6}, 6: {11}, 8: {10}},
+				len: 12,
 			},
-		},
-	} {
-		t.Run(utils.IntToString(i), func(t *testing.T) {
-			actual, err := (&Instance{Module: m}).parseBlocks(c.body)
-			if err != nil {
-				t.Fail()
-			}
-			if !reflect.DeepEqual(c.exp, actual) {
+		} {
+			t.Run(utils.IntToString(i), func(t *testing.T) {
+				code, err := (&Instance{Module: m}).compile(c.body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(code) != c.len {
+					t.Logf("compiled %d instrs", len(code))
+					t.Fail()
+				}
+				for j, in := range code {
+					if !reflect.DeepEqual(c.exp[j], in.Targets) {
+						t.Logf("targets of %s at %d: %v", expr.GetOpCodeName(in.OpCode), j, in.Targets)
+						t.Fail()
+					}
+				}
+			})
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		for i, body := range [][]byte{
+			{byte(expr.OpCodeBlock), 0x40},
+			{byte(expr.OpCodeBlock), 0x40, byte(expr.OpCodeBr), 0x02, byte(expr.OpCodeEnd)},
+			{byte(expr.OpCodeBlock), 0x40, byte(expr.OpCodeElse), byte(expr.OpCodeEnd)},
+			{byte(expr.OpCodeBlock), 0x05, byte(expr.OpCodeEnd)},
+			{byte(expr.OpCodeNop), 0xff, byte(expr.OpCodeEnd)},
+			{byte(expr.OpCodeI32Const), 0x01, 0xc5, byte(expr.OpCodeEnd)},
+		} {
+			if _, err := (&Instance{Module: m}).compile(body); err == nil {
+				t.Logf("no error on %d", i)
 				t.Fail()
 			}
-		})
+		}
+	})
+}
+
+// compiled compiles the body for the tests of the instrs
+func compiled(body ...byte) []instruction {
+	code, err := (&Instance{Module: &Module{}}).compile(body)
+	if err != nil {
+		panic(err)
 	}
+
+	return code
 }
@@ -2,26 +2,24 @@ package wasm
 
 import (
 	"github.com/hybridgroup/wasman/expr"
-	"github.com/hybridgroup/wasman/stacks"
 )
 
 // Frame is the context data of one instance
 type Frame struct {
-	PC         uint64
-	Func       *wasmFunc
-	Locals     []uint64
-	LabelStack *stacks.Stack[*stacks.Label]
+	PC     uint64 // the index of the running instr in the compiled func
+	Func   *wasmFunc
+	Locals []uint64
 }
 
 // instructions are basic wasm instructions
 var instructions = [256]func(ins *Instance) error{
 	expr.OpCodeUnreachable:       unreachable,
 	expr.OpCodeNop:               nop,
-	expr.OpCodeBlock:             block,
-	expr.OpCodeLoop:              loop,
+	expr.OpCodeBlock:             nop,
+	expr.OpCodeLoop:              nop,
 	expr.OpCodeIf:                ifOp,
 	expr.OpCodeElse:              elseOp,
-	expr.OpCodeEnd:               end,
+	expr.OpCodeEnd:               nop,
 	expr.OpCodeBr:                br,
 	expr.OpCodeBrIf:              brIf,
 	expr.OpCodeBrTable:           brTable,
@@ -188,4 +186,9 @@ var instructions = [256]func(ins *Instance) error{
 	expr.OpCodeI64ReinterpretF64: nop,
 	expr.OpCodeF32ReinterpretI32: nop,
 	expr.OpCodeF64ReinterpretI64: nop,
+	expr.OpCodeI32Extend8S:       i32extend8s,
+	expr.OpCodeI32Extend16S:      i32extend16s,
+	expr.OpCodeI64Extend8S:       i64extend8s,
+	expr.OpCodeI64Extend16S:      i64extend16s,
+	expr.OpCodeI64Extend32S:      i64extend32s,
 }
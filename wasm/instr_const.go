@@ -1,57 +1,26 @@
 package wasm
 
-import (
-	"math"
-)
-
 func i32Const(ins *Instance) error {
-	ins.Active.PC++
-
-	v, err := ins.fetchInt32()
-	if err != nil {
-		return err
-	}
-
-	ins.OperandStack.Push(uint64(v))
+	ins.OperandStack.Push(uint64(int32(ins.current().Immediates[0])))
 
 	return nil
 }
 
 func i64Const(ins *Instance) error {
-	ins.Active.PC++
-
-	v, err := ins.fetchInt64()
-	if err != nil {
-		return err
-	}
-
-	ins.OperandStack.Push(uint64(v))
+	ins.OperandStack.Push(ins.current().Immediates[0])
 
 	return nil
 }
 
+// f32Const pushes the bits of the float as it is stored
 func f32Const(ins *Instance) error {
-	ins.Active.PC++
-
-	v, err := ins.fetchFloat32()
-	if err != nil {
-		return err
-	}
-
-	ins.OperandStack.Push(uint64(math.Float32bits(v)))
+	ins.OperandStack.Push(ins.current().Immediates[0])
 
 	return nil
 }
 
 func f64Const(ins *Instance) error {
-	ins.Active.PC++
-
-	v, err := ins.fetchFloat64()
-	if err != nil {
-		return err
-	}
-
-	ins.OperandStack.Push(math.Float64bits(v))
+	ins.OperandStack.Push(ins.current().Immediates[0])
 
 	return nil
 }
@@ -11,7 +11,7 @@ import (
 func Test_i32Const(t *testing.T) {
 	ctx := &Frame{
 		Func: &wasmFunc{
-			body: []byte{byte(expr.OpCodeI32Const), 0x05},
+			code: compiled(byte(expr.OpCodeI32Const), 0x05),
 		},
 	}
 
@@ -34,7 +34,7 @@ func Test_i32Const(t *testing.T) {
 func Test_i64Const(t *testing.T) {
 	ctx := &Frame{
 		Func: &wasmFunc{
-			body: []byte{byte(expr.OpCodeI64Const), 0x05},
+			code: compiled(byte(expr.OpCodeI64Const), 0x05),
 		},
 	}
 
@@ -58,7 +58,7 @@ func Test_f32Const(t *testing.T) {
 
 	ctx := &Frame{
 		Func: &wasmFunc{
-			body: []byte{byte(expr.OpCodeF32Const), 0x00, 0x00, 0x80, 0x3f},
+			code: compiled(byte(expr.OpCodeF32Const), 0x00, 0x00, 0x80, 0x3f),
 		},
 	}
 
@@ -81,7 +81,7 @@ func Test_f32Const(t *testing.T) {
 func Test_f64Const(t *testing.T) {
 	ctx := &Frame{
 		Func: &wasmFunc{
-			body: []byte{byte(expr.OpCodeF64Const), 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf0, 0x3f},
+			code: compiled(byte(expr.OpCodeF64Const), 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf0, 0x3f),
 		},
 	}
 
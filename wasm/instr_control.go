@@ -1,19 +1,14 @@
 package wasm
 
 import (
-	"bytes"
 	"errors"
 
-	"github.com/hybridgroup/wasman/leb128decode"
-	"github.com/hybridgroup/wasman/stacks"
 	"github.com/hybridgroup/wasman/types"
 )
 
 // errors on control instr
 var (
 	ErrUnreachable                 = errors.New("unreachable")
-	ErrBlockNotInitialized         = errors.New("block not initialized")
-	ErrBlockNotFound               = errors.New("block not found")
 	ErrFuncSignMismatch            = errors.New("function signature mismatch")
 	ErrLabelNotFound               = errors.New("label not found")
 	ErrTableIndexOutOfRange        = errors.New("table index out of range")
@@ -28,158 +23,65 @@ func nop(_ *Instance) error {
 	return nil
 }
 
-func block(ins *Instance) error {
-	ctx := ins.Active
-	block, ok := ctx.Func.Blocks[ctx.PC]
-	if !ok {
-		return ErrBlockNotInitialized
-	}
-
-	ctx.PC += block.BlockTypeBytes
-	ctx.LabelStack.Push(&stacks.Label{
-		Arity:          len(block.BlockType.ReturnTypes),
-		ContinuationPC: block.EndAt,
-		EndPC:          block.EndAt,
-	})
-
-	return nil
-}
-
-func loop(ins *Instance) error {
-	ctx := ins.Active
-	block, ok := ctx.Func.Blocks[ctx.PC]
-	if !ok {
-		return ErrBlockNotFound
-	}
-	ctx.PC += block.BlockTypeBytes
-	ctx.LabelStack.Push(&stacks.Label{
-		Arity:          len(block.BlockType.ReturnTypes),
-		ContinuationPC: block.StartAt - 1,
-		EndPC:          block.EndAt,
-	})
-
-	return nil
-}
-
 func ifOp(ins *Instance) error {
-	ctx := ins.Active
-	block, ok := ctx.Func.Blocks[ins.Active.PC]
-	if !ok {
-		return ErrBlockNotInitialized
+	if ins.OperandStack.Pop() == 0 { // means false, turn to the else or end
+		ins.Active.PC = ins.current().Targets[0]
 	}
-	ctx.PC += block.BlockTypeBytes
-
-	if ins.OperandStack.Pop() == 0 && // means false, turn to else codes
-		block.ElseAt > block.StartAt {
-		// enter else
-		ins.Active.PC = block.ElseAt
-	}
-
-	ctx.LabelStack.Push(&stacks.Label{
-		Arity:          len(block.BlockType.ReturnTypes),
-		ContinuationPC: block.EndAt,
-		EndPC:          block.EndAt,
-	})
 
 	return nil
 }
 
 func elseOp(ins *Instance) error {
-	l := ins.Active.LabelStack.Pop()
-	ins.Active.PC = l.EndPC
-
-	return nil
-}
-
-func end(ins *Instance) error {
-	if ins.Active.LabelStack.Ptr > -1 {
-		_ = ins.Active.LabelStack.Pop()
-	}
+	ins.Active.PC = ins.current().Targets[0]
 
 	return nil
 }
 
 func br(ins *Instance) error {
-	ins.Active.PC++
-	index, err := ins.fetchUint32()
-	if err != nil {
-		return err
-	}
-
-	return branchAt(ins, index)
-}
-
-func branchAt(ins *Instance, index uint32) error {
-	var l *stacks.Label
-
-	for i := uint32(0); i < index+1; i++ {
-		l = ins.Active.LabelStack.Pop()
-	}
-
-	if l == nil {
-		return ErrLabelNotFound
-	}
-
-	ins.Active.PC = l.ContinuationPC
+	ins.Active.PC = ins.current().Targets[0]
 
 	return nil
 }
 
 func brIf(ins *Instance) error {
-	ins.Active.PC++
-	index, err := ins.fetchUint32()
-	if err != nil {
-		return err
-	}
-
 	c := ins.OperandStack.Pop()
 	if c != 0 {
-		return branchAt(ins, index)
+		ins.Active.PC = ins.current().Targets[0]
 	}
 
 	return nil
 }
 
 func brTable(ins *Instance) error {
-	ins.Active.PC++
-	r := bytes.NewReader(ins.Active.Func.body[ins.Active.PC:])
-	nl, num, err := leb128decode.DecodeUint32(r)
-	if err != nil {
-		return err
+	targets := ins.current().Targets
+	i := uint32(ins.OperandStack.Pop())
+	if uint64(i) < uint64(len(targets)-1) {
+		ins.Active.PC = targets[i]
+	} else {
+		ins.Active.PC = targets[len(targets)-1] // the default one
 	}
 
-	lis := make([]uint32, nl)
-	for i := range lis {
-		li, n, err := leb128decode.DecodeUint32(r)
-		if err != nil {
-			return err
-		}
-		num += n
-		lis[i] = li
-	}
+	return nil
+}
 
-	ln, n, err := leb128decode.DecodeUint32(r)
+func call(ins *Instance) error {
+	index := ins.current().Immediates[0]
+
+	err := ins.Functions[index].call(ins)
 	if err != nil {
 		return err
 	}
-	ins.Active.PC += n + num
-
-	i := ins.OperandStack.Pop()
-	if uint32(i) < nl {
-		return branchAt(ins, lis[i])
-	}
 
-	return branchAt(ins, ln)
+	return nil
 }
 
-func call(ins *Instance) error {
-	ins.Active.PC++
-	index, err := ins.fetchUint32()
+func callIndirect(ins *Instance) error {
+	idx, err := ins.IndirectFunc(uint32(ins.current().Immediates[0]), ins.OperandStack.Pop())
 	if err != nil {
 		return err
 	}
 
-	err = ins.Functions[index].call(ins)
+	err = ins.Functions[idx].call(ins)
 	if err != nil {
 		return err
 	}
@@ -187,39 +89,26 @@ func call(ins *Instance) error {
 	return nil
 }
 
-func callIndirect(ins *Instance) error {
-	ins.Active.PC++
-	index, err := ins.fetchUint32()
-	if err != nil {
-		return err
-	}
-
-	expType := ins.Module.TypeSection[index]
+// IndirectFunc returns the index of the func at the elem of the table,
+// which should have the signature of the type at the typeIndex as the call_indirect checks
+func (ins *Instance) IndirectFunc(typeIndex uint32, elem uint64) (uint32, error) {
+	expType := ins.Module.TypeSection[typeIndex]
 
-	tableIndex := ins.OperandStack.Pop()
 	// note: mvp limits the size of table index space to 1
-	if tableIndex >= uint64(len(ins.Module.IndexSpace.Tables[0].Value)) {
-		return ErrTableIndexOutOfRange
+	if elem >= uint64(len(ins.IndexSpace.Tables[0].Value)) {
+		return 0, ErrTableIndexOutOfRange
 	}
 
-	te := ins.Module.IndexSpace.Tables[0].Value[tableIndex]
+	te := ins.IndexSpace.Tables[0].Value[elem]
 	if te == nil {
-		return ErrTableInstanceNotInitialized
+		return 0, ErrTableInstanceNotInitialized
 	}
 
-	f := ins.Functions[*te]
-	ft := f.getType()
+	ft := ins.Functions[*te].getType()
 	if !types.HasSameSignature(ft.InputTypes, expType.InputTypes) ||
 		!types.HasSameSignature(ft.ReturnTypes, expType.ReturnTypes) {
-		return ErrFuncSignMismatch
+		return 0, ErrFuncSignMismatch
 	}
 
-	err = f.call(ins)
-	if err != nil {
-		return err
-	}
-
-	ins.Active.PC++ // skip 0x00
-
-	return nil
+	return *te, nil
 }
@@ -1,7 +1,6 @@
 package wasm
 
 import (
-	"reflect"
 	"testing"
 
 	"github.com/hybridgroup/wasman/utils"
@@ -11,59 +10,38 @@ import (
 	"github.com/hybridgroup/wasman/types"
 )
 
-func Test_block(t *testing.T) {
-	ctx := &Frame{
-		PC: 1,
-		Func: &wasmFunc{
-			Blocks: map[uint64]*funcBlock{
-				1: {
-					StartAt:        1,
-					EndAt:          100,
-					BlockTypeBytes: 3,
-					BlockType:      &types.FuncType{ReturnTypes: []types.ValueType{types.ValueTypeI32}},
-				},
-			},
-		},
-		LabelStack: stacks.NewLabelStack(),
-	}
-	if block(&Instance{Active: ctx}) != nil {
-		t.Fail()
-	}
-	if !reflect.DeepEqual(&stacks.Label{
-		Arity:          1,
-		ContinuationPC: 100,
-		EndPC:          100,
-	}, ctx.LabelStack.Values[ctx.LabelStack.Ptr]) {
-		t.Fail()
-	}
-	if ctx.PC != 4 {
-		t.Fail()
+func Test_ifOp(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		body []byte
+		cond uint64
+		exp  uint64
+	}{
+		{name: "true", body: []byte{expr.OpCodeIf, 0x40, expr.OpCodeNop, expr.OpCodeElse, expr.OpCodeNop, expr.OpCodeEnd}, cond: 1, exp: 0},
+		{name: "false", body: []byte{expr.OpCodeIf, 0x40, expr.OpCodeNop, expr.OpCodeElse, expr.OpCodeNop, expr.OpCodeEnd}, cond: 0, exp: 2},
+		{name: "false without else", body: []byte{expr.OpCodeIf, 0x40, expr.OpCodeNop, expr.OpCodeEnd}, cond: 0, exp: 2},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := &Frame{Func: &wasmFunc{code: compiled(c.body...)}}
+			vm := &Instance{Active: ctx, OperandStack: stacks.NewOperandStack()}
+			vm.OperandStack.Push(c.cond)
+			if ifOp(vm) != nil {
+				t.Fail()
+			}
+			if ctx.PC != c.exp {
+				t.Logf("pc %d", ctx.PC)
+				t.Fail()
+			}
+		})
 	}
 }
 
-func Test_loop(t *testing.T) {
+func Test_elseOp(t *testing.T) {
 	ctx := &Frame{
-		PC: 1,
-		Func: &wasmFunc{
-			Blocks: map[uint64]*funcBlock{
-				1: {
-					StartAt:        1,
-					EndAt:          100,
-					BlockTypeBytes: 3,
-					BlockType:      &types.FuncType{ReturnTypes: []types.ValueType{types.ValueTypeI32}},
-				},
-			},
-		},
-		LabelStack: stacks.NewLabelStack(),
+		PC:   2,
+		Func: &wasmFunc{code: compiled(expr.OpCodeIf, 0x40, expr.OpCodeNop, expr.OpCodeElse, expr.OpCodeNop, expr.OpCodeEnd)},
 	}
-	if loop(&Instance{Active: ctx}) != nil {
-		t.Fail()
-	}
-	if !reflect.DeepEqual(&stacks.Label{
-		Arity:          1,
-		ContinuationPC: 0,
-		EndPC:          100,
-	}, ctx.LabelStack.Values[ctx.LabelStack.Ptr]) {
+	if elseOp(&Instance{Active: ctx}) != nil {
 		t.Fail()
 	}
 	if ctx.PC != 4 {
@@ -71,140 +49,46 @@ func Test_loop(t *testing.T) {
 	}
 }
 
-func Test_ifOp(t *testing.T) {
-	t.Run("true", func(t *testing.T) {
-		ctx := &Frame{
-			PC: 1,
-			Func: &wasmFunc{
-				Blocks: map[uint64]*funcBlock{
-					1: {
-						StartAt:        1,
-						EndAt:          100,
-						BlockTypeBytes: 3,
-						BlockType:      &types.FuncType{ReturnTypes: []types.ValueType{types.ValueTypeI32}},
-					},
-				},
-			},
-			LabelStack: stacks.NewLabelStack(),
-		}
-		vm := &Instance{Active: ctx, OperandStack: stacks.NewOperandStack()}
-		vm.OperandStack.Push(1)
-		if ifOp(vm) != nil {
-			t.Fail()
-		}
-		if !reflect.DeepEqual(&stacks.Label{
-			Arity:          1,
-			ContinuationPC: 100,
-			EndPC:          100,
-		}, ctx.LabelStack.Values[ctx.LabelStack.Ptr]) {
-			t.Fail()
-		}
-		if ctx.PC != 4 {
-			t.Fail()
-		}
-	})
-	t.Run("false", func(t *testing.T) {
-		ctx := &Frame{
-			PC: 1,
-			Func: &wasmFunc{
-				Blocks: map[uint64]*funcBlock{
-					1: {
-						StartAt:        1,
-						ElseAt:         50,
-						EndAt:          100,
-						BlockTypeBytes: 3,
-						BlockType:      &types.FuncType{ReturnTypes: []types.ValueType{types.ValueTypeI32}},
-					},
-				},
-			},
-			LabelStack: stacks.NewLabelStack(),
-		}
-		vm := &Instance{Active: ctx, OperandStack: stacks.NewOperandStack()}
-		vm.OperandStack.Push(0)
-		if ifOp(vm) != nil {
-			t.Fail()
-		}
-		if !reflect.DeepEqual(&stacks.Label{
-			Arity:          1,
-			ContinuationPC: 100,
-			EndPC:          100,
-		}, ctx.LabelStack.Values[ctx.LabelStack.Ptr]) {
+func Test_br(t *testing.T) {
+	code := compiled(
+		expr.OpCodeBlock, 0x40, expr.OpCodeLoop, 0x40,
+		expr.OpCodeBr, 0x01, expr.OpCodeBr, 0x00, expr.OpCodeBr, 0x02,
+		expr.OpCodeEnd, expr.OpCodeEnd, expr.OpCodeEnd,
+	)
+	for _, c := range []struct {
+		pc, exp uint64
+	}{
+		{pc: 2, exp: 6}, // the end of the block
+		{pc: 3, exp: 1}, // the start of the loop
+		{pc: 4, exp: 7}, // the end of the func
+	} {
+		ctx := &Frame{PC: c.pc, Func: &wasmFunc{code: code}}
+		if br(&Instance{Active: ctx}) != nil {
 			t.Fail()
 		}
-		if ctx.PC != 50 {
+		if ctx.PC != c.exp {
+			t.Logf("br at %d: pc %d", c.pc, ctx.PC)
 			t.Fail()
 		}
-	})
-}
-
-func Test_elseOp(t *testing.T) {
-	ctx := &Frame{
-		LabelStack: stacks.NewLabelStack(),
-	}
-
-	ctx.LabelStack.Push(&stacks.Label{EndPC: 100000})
-	if elseOp(&Instance{Active: ctx}) != nil {
-		t.Fail()
-	}
-	if ctx.PC != 100000 {
-		t.Fail()
-	}
-}
-
-func Test_end(t *testing.T) {
-	ctx := &Frame{LabelStack: stacks.NewLabelStack()}
-	ctx.LabelStack.Push(&stacks.Label{EndPC: 100000})
-	if end(&Instance{Active: ctx}) != nil {
-		t.Fail()
-	}
-	if ctx.LabelStack.Ptr != -1 {
-		t.Fail()
-	}
-}
-
-func Test_br(t *testing.T) {
-	ctx := &Frame{
-		LabelStack: stacks.NewLabelStack(),
-		Func:       &wasmFunc{body: []byte{0x00, 0x01}},
-	}
-	vm := &Instance{
-		Active:       ctx,
-		OperandStack: stacks.NewOperandStack()}
-	ctx.LabelStack.Push(&stacks.Label{ContinuationPC: 5})
-	ctx.LabelStack.Push(&stacks.Label{})
-	if br(vm) != nil {
-		t.Fail()
-	}
-	if ctx.PC != 5 {
-		t.Fail()
 	}
 }
 
 func Test_brIf(t *testing.T) {
+	code := compiled(expr.OpCodeBlock, 0x40, expr.OpCodeBrIf, 0x00, expr.OpCodeEnd)
 	t.Run("true", func(t *testing.T) {
-		ctx := &Frame{
-			LabelStack: stacks.NewLabelStack(),
-			Func:       &wasmFunc{body: []byte{0x00, 0x01}},
-		}
-
+		ctx := &Frame{PC: 1, Func: &wasmFunc{code: code}}
 		vm := &Instance{Active: ctx, OperandStack: stacks.NewOperandStack()}
 		vm.OperandStack.Push(1)
-		ctx.LabelStack.Push(&stacks.Label{ContinuationPC: 5})
-		ctx.LabelStack.Push(&stacks.Label{})
 		if brIf(vm) != nil {
 			t.Fail()
 		}
-		if ctx.PC != 5 {
+		if ctx.PC != 2 {
 			t.Fail()
 		}
 	})
 
 	t.Run("false", func(t *testing.T) {
-		ctx := &Frame{
-			LabelStack: stacks.NewLabelStack(),
-			Func:       &wasmFunc{body: []byte{0x00, 0x01}},
-		}
-
+		ctx := &Frame{PC: 1, Func: &wasmFunc{code: code}}
 		vm := &Instance{Active: ctx, OperandStack: stacks.NewOperandStack()}
 		vm.OperandStack.Push(0)
 		if brIf(vm) != nil {
@@ -216,12 +100,31 @@ func Test_brIf(t *testing.T) {
 	})
 }
 
-func Test_brAt(t *testing.T) {
-	// fixme:
-}
-
 func Test_brTable(t *testing.T) {
-	// fixme:
+	code := compiled(
+		expr.OpCodeBlock, 0x40, expr.OpCodeBlock, 0x40,
+		expr.OpCodeBrTable, 0x02, 0x00, 0x01, 0x02,
+		expr.OpCodeEnd, expr.OpCodeEnd, expr.OpCodeEnd,
+	)
+	for _, c := range []struct {
+		i, exp uint64
+	}{
+		{i: 0, exp: 3},
+		{i: 1, exp: 4},
+		{i: 2, exp: 5}, // the default one
+		{i: 1 << 32, exp: 3},
+	} {
+		ctx := &Frame{PC: 2, Func: &wasmFunc{code: code}}
+		vm := &Instance{Active: ctx, OperandStack: stacks.NewOperandStack()}
+		vm.OperandStack.Push(c.i)
+		if brTable(vm) != nil {
+			t.Fail()
+		}
+		if ctx.PC != c.exp {
+			t.Logf("br_table with %d: pc %d", c.i, ctx.PC)
+			t.Fail()
+		}
+	}
 }
 
 type dummyFunc struct {
@@ -240,7 +143,7 @@ func Test_call(t *testing.T) {
 	ins := &Instance{
 		Active: &Frame{
 			Func: &wasmFunc{
-				body: []byte{byte(expr.OpCodeCall), 0x01},
+				code: compiled(byte(expr.OpCodeCall), 0x01),
 			},
 		},
 		Functions: []fn{nil, df},
@@ -259,7 +162,7 @@ func Test_callIndirect(t *testing.T) {
 	ins := &Instance{
 		Active: &Frame{
 			Func: &wasmFunc{
-				body: []byte{byte(expr.OpCodeCall), 0x01, 0x00},
+				code: compiled(byte(expr.OpCodeCallIndirect), 0x01, 0x00),
 			},
 		},
 		Functions: []fn{nil, df},
@@ -0,0 +1,136 @@
+package wasm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/hybridgroup/wasman/expr"
+	"github.com/hybridgroup/wasman/leb128decode"
+)
+
+// ErrInvalidInstruction will be throw when the immediates of an instruction can not be decoded
+var ErrInvalidInstruction = errors.New("invalid instruction")
+
+// instruction is an instruction decoded from the func body with its immediates
+type instruction struct {
+	OpCode  expr.OpCode
+	SubCode uint32 // the sub opcode of the OpCodeBulkMemory
+
+	Offset uint64 // the offset of the opcode in the body
+	Next   uint64 // the offset of the following instruction
+
+	// Immediates are the immediates in the order of the binary format except the block type,
+	// e.g. the align and offset of the memory instructions, the labels of the br_table followed by the default one.
+	// The consts are the raw bits of their values.
+	Immediates []uint64
+	BlockType  int64 // the raw block type of the block, loop and if
+
+	// Targets are the indexes of the compiled instrs to jump to, resolved by the compile:
+	// the else or end of the if, the end of the else, and the labels of the br, br_if and br_table.
+	Targets []uint64
+}
+
+// decodeInstruction decodes the instruction at the pc of the body
+func decodeInstruction(body []byte, pc uint64) (*instruction, error) {
+	in := &instruction{OpCode: body[pc], Offset: pc}
+	r := bytes.NewReader(body[pc+1:])
+
+	var err error
+	switch op := in.OpCode; {
+	case op == expr.OpCodeBlock || op == expr.OpCodeLoop || op == expr.OpCodeIf:
+		in.BlockType, _, err = leb128decode.DecodeInt33AsInt64(r)
+	case op == expr.OpCodeBr || op == expr.OpCodeBrIf || op == expr.OpCodeCall ||
+		(expr.OpCodeLocalGet <= op && op <= expr.OpCodeGlobalSet) ||
+		op == expr.OpCodeMemorySize || op == expr.OpCodeMemoryGrow || op == expr.OpCodeFunc:
+		err = in.readUint32s(r, 1)
+	case op == expr.OpCodeCallIndirect:
+		err = in.readUint32s(r, 2) // type index, table index
+	case op == expr.OpCodeBrTable:
+		var n uint32
+		n, _, err = leb128decode.DecodeUint32(r)
+		if err == nil {
+			err = in.readUint32s(r, int(n)+1)
+		}
+	case expr.OpCodeI32Load <= op && op <= expr.OpCodeI64Store32:
+		err = in.readUint32s(r, 2) // align, offset
+	case op == expr.OpCodeI32Const:
+		var v int32
+		v, _, err = leb128decode.DecodeInt32(r)
+		in.Immediates = append(in.Immediates, uint64(uint32(v)))
+	case op == expr.OpCodeI64Const:
+		var v int64
+		v, _, err = leb128decode.DecodeInt64(r)
+		in.Immediates = append(in.Immediates, uint64(v))
+	case op == expr.OpCodeF32Const:
+		b := make([]byte, 4)
+		_, err = io.ReadFull(r, b)
+		in.Immediates = append(in.Immediates, uint64(binary.LittleEndian.Uint32(b)))
+	case op == expr.OpCodeF64Const:
+		b := make([]byte, 8)
+		_, err = io.ReadFull(r, b)
+		in.Immediates = append(in.Immediates, binary.LittleEndian.Uint64(b))
+	case op == expr.OpCodeNull:
+		err = in.readBytes(r, 1) // reference type
+	case op == expr.OpCodeBulkMemory:
+		in.SubCode, _, err = leb128decode.DecodeUint32(r)
+		if err == nil {
+			err = in.readBulkMemoryImmediates(r)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s at %#x: %v", ErrInvalidInstruction, expr.GetOpCodeName(in.OpCode), pc, err)
+	}
+
+	in.Next = uint64(len(body)) - uint64(r.Len())
+	return in, nil
+}
+
+func (in *instruction) readBulkMemoryImmediates(r *bytes.Reader) error {
+	switch in.SubCode {
+	case 0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07: // trunc_sat
+		return nil
+	case 0x08: // memory.init
+		if err := in.readUint32s(r, 1); err != nil {
+			return err
+		}
+		return in.readBytes(r, 1)
+	case 0x09, 0x0d, 0x0f, 0x10, 0x11: // data.drop, elem.drop, table.grow, table.size, table.fill
+		return in.readUint32s(r, 1)
+	case 0x0a: // memory.copy
+		return in.readBytes(r, 2)
+	case 0x0b: // memory.fill
+		return in.readBytes(r, 1)
+	case 0x0c, 0x0e: // table.init, table.copy
+		return in.readUint32s(r, 2)
+	default:
+		return ErrInvalidSubcode
+	}
+}
+
+func (in *instruction) readUint32s(r *bytes.Reader, n int) error {
+	for i := 0; i < n; i++ {
+		v, _, err := leb128decode.DecodeUint32(r)
+		if err != nil {
+			return err
+		}
+		in.Immediates = append(in.Immediates, uint64(v))
+	}
+
+	return nil
+}
+
+// readBytes reads the n immediates of a single byte, e.g. the memory index of the memory.copy
+func (in *instruction) readBytes(r *bytes.Reader, n int) error {
+	for i := 0; i < n; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		in.Immediates = append(in.Immediates, uint64(b))
+	}
+
+	return nil
+}
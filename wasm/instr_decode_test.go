@@ -0,0 +1,71 @@
+package wasm
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/hybridgroup/wasman/expr"
+	"github.com/hybridgroup/wasman/utils"
+)
+
+func Test_decodeInstruction(t *testing.T) {
+	for i, c := range []struct {
+		body []byte
+		exp  *instruction
+	}{
+		{
+			body: []byte{expr.OpCodeNop},
+			exp:  &instruction{OpCode: expr.OpCodeNop, Next: 1},
+		},
+		{
+			body: []byte{expr.OpCodeI32Const, 0x7f},
+			exp:  &instruction{OpCode: expr.OpCodeI32Const, Next: 2, Immediates: []uint64{0xffffffff}},
+		},
+		{
+			body: []byte{expr.OpCodeF32Const, 0x00, 0x00, 0xc0, 0x3f},
+			exp:  &instruction{OpCode: expr.OpCodeF32Const, Next: 5, Immediates: []uint64{0x3fc00000}},
+		},
+		{
+			body: []byte{expr.OpCodeI64Load, 0x03, 0x80, 0x01},
+			exp:  &instruction{OpCode: expr.OpCodeI64Load, Next: 4, Immediates: []uint64{3, 128}},
+		},
+		{
+			body: []byte{expr.OpCodeBrTable, 0x02, 0x00, 0x01, 0x02},
+			exp:  &instruction{OpCode: expr.OpCodeBrTable, Next: 5, Immediates: []uint64{0, 1, 2}},
+		},
+		{
+			body: []byte{expr.OpCodeLoop, 0x7f},
+			exp:  &instruction{OpCode: expr.OpCodeLoop, Next: 2, BlockType: -1},
+		},
+		{
+			body: []byte{expr.OpCodeCallIndirect, 0x01, 0x00},
+			exp:  &instruction{OpCode: expr.OpCodeCallIndirect, Next: 3, Immediates: []uint64{1, 0}},
+		},
+		{
+			body: []byte{expr.OpCodeBulkMemory, 0x0a, 0x00, 0x00},
+			exp:  &instruction{OpCode: expr.OpCodeBulkMemory, SubCode: 0x0a, Next: 4, Immediates: []uint64{0, 0}},
+		},
+	} {
+		t.Run(utils.IntToString(i), func(t *testing.T) {
+			in, err := decodeInstruction(c.body, 0)
+			if err != nil || !reflect.DeepEqual(in, c.exp) {
+				t.Logf("%+v, %v", in, err)
+				t.Fail()
+			}
+		})
+	}
+
+	// truncated immediates
+	for i, body := range [][]byte{
+		{expr.OpCodeF64Const, 0x00},
+		{expr.OpCodeBrTable, 0x02, 0x00},
+		{expr.OpCodeBulkMemory, 0x20},
+	} {
+		t.Run("error"+utils.IntToString(i), func(t *testing.T) {
+			if _, err := decodeInstruction(body, 0); !errors.Is(err, ErrInvalidInstruction) {
+				t.Fail()
+			}
+		})
+	}
+}
@@ -1,8 +1,8 @@
 package wasm
 
 import (
-	"encoding/binary"
 	"errors"
+	"math"
 
 	"github.com/hybridgroup/wasman/config"
 )
@@ -10,25 +10,17 @@ import (
 // ErrPtrOutOfBounds will be throw when the pointer visiting a pos out of the range of memory
 var ErrPtrOutOfBounds = errors.New("pointer is out of bounds")
 
-func memoryBase(ins *Instance) (uint64, error) {
-	ins.Active.PC++
-	_, err := ins.fetchUint32() // ignore align
-	if err != nil {
-		return 0, err
-	}
-	ins.Active.PC++
-	v, err := ins.fetchUint32()
-	if err != nil {
-		return 0, err
-	}
+// memoryBase returns the effective address of the memory instruction,
+// the access is checked by the Memory methods
+func memoryBase(ins *Instance) (uint32, error) {
+	offset := ins.current().Immediates[1] // ignore align
 
-	base := uint64(v) + ins.OperandStack.Pop()
-	if !(base < uint64(len(ins.Memory.Value))) {
-		println("memory too small", base, len(ins.Memory.Value))
+	base := offset + uint64(uint32(ins.OperandStack.Pop()))
+	if base > math.MaxUint32 {
 		return 0, ErrPtrOutOfBounds
 	}
 
-	return base, nil
+	return uint32(base), nil
 }
 
 func i32Load(ins *Instance) error {
@@ -37,7 +29,12 @@ func i32Load(ins *Instance) error {
 		return err
 	}
 
-	ins.OperandStack.Push(uint64(binary.LittleEndian.Uint32(ins.Memory.Value[base:])))
+	v, err := ins.Memory.ReadUint32(base)
+	if err != nil {
+		return err
+	}
+
+	ins.OperandStack.Push(uint64(v))
 
 	return nil
 }
@@ -48,7 +45,12 @@ func i64Load(ins *Instance) error {
 		return err
 	}
 
-	ins.OperandStack.Push(binary.LittleEndian.Uint64(ins.Memory.Value[base:]))
+	v, err := ins.Memory.ReadUint64(base)
+	if err != nil {
+		return err
+	}
+
+	ins.OperandStack.Push(v)
 
 	return nil
 }
@@ -67,13 +69,30 @@ func i32Load8s(ins *Instance) error {
 		return err
 	}
 
-	ins.OperandStack.Push(uint64(ins.Memory.Value[base]))
+	v, err := ins.Memory.ReadUint8(base)
+	if err != nil {
+		return err
+	}
+
+	ins.OperandStack.Push(uint64(uint32(int8(v))))
 
 	return nil
 }
 
 func i32Load8u(ins *Instance) error {
-	return i32Load8s(ins)
+	base, err := memoryBase(ins)
+	if err != nil {
+		return err
+	}
+
+	v, err := ins.Memory.ReadUint8(base)
+	if err != nil {
+		return err
+	}
+
+	ins.OperandStack.Push(uint64(v))
+
+	return nil
 }
 
 func i32Load16s(ins *Instance) error {
@@ -82,13 +101,30 @@ func i32Load16s(ins *Instance) error {
 		return err
 	}
 
-	ins.OperandStack.Push(uint64(binary.LittleEndian.Uint16(ins.Memory.Value[base:])))
+	v, err := ins.Memory.ReadUint16(base)
+	if err != nil {
+		return err
+	}
+
+	ins.OperandStack.Push(uint64(uint32(int16(v))))
 
 	return nil
 }
 
 func i32Load16u(ins *Instance) error {
-	return i32Load16s(ins)
+	base, err := memoryBase(ins)
+	if err != nil {
+		return err
+	}
+
+	v, err := ins.Memory.ReadUint16(base)
+	if err != nil {
+		return err
+	}
+
+	ins.OperandStack.Push(uint64(v))
+
+	return nil
 }
 
 func i64Load8s(ins *Instance) error {
@@ -97,13 +133,30 @@ func i64Load8s(ins *Instance) error {
 		return err
 	}
 
-	ins.OperandStack.Push(uint64(ins.Memory.Value[base]))
+	v, err := ins.Memory.ReadUint8(base)
+	if err != nil {
+		return err
+	}
+
+	ins.OperandStack.Push(uint64(int8(v)))
 
 	return nil
 }
 
 func i64Load8u(ins *Instance) error {
-	return i64Load8s(ins)
+	base, err := memoryBase(ins)
+	if err != nil {
+		return err
+	}
+
+	v, err := ins.Memory.ReadUint8(base)
+	if err != nil {
+		return err
+	}
+
+	ins.OperandStack.Push(uint64(v))
+
+	return nil
 }
 
 func i64Load16s(ins *Instance) error {
@@ -112,13 +165,30 @@ func i64Load16s(ins *Instance) error {
 		return err
 	}
 
-	ins.OperandStack.Push(uint64(binary.LittleEndian.Uint16(ins.Memory.Value[base:])))
+	v, err := ins.Memory.ReadUint16(base)
+	if err != nil {
+		return err
+	}
+
+	ins.OperandStack.Push(uint64(int16(v)))
 
 	return nil
 }
 
 func i64Load16u(ins *Instance) error {
-	return i64Load16s(ins)
+	base, err := memoryBase(ins)
+	if err != nil {
+		return err
+	}
+
+	v, err := ins.Memory.ReadUint16(base)
+	if err != nil {
+		return err
+	}
+
+	ins.OperandStack.Push(uint64(v))
+
+	return nil
 }
 
 func i64Load32s(ins *Instance) error {
@@ -127,13 +197,30 @@ func i64Load32s(ins *Instance) error {
 		return err
 	}
 
-	ins.OperandStack.Push(uint64(binary.LittleEndian.Uint32(ins.Memory.Value[base:])))
+	v, err := ins.Memory.ReadUint32(base)
+	if err != nil {
+		return err
+	}
+
+	ins.OperandStack.Push(uint64(int32(v)))
 
 	return nil
 }
 
 func i64Load32u(ins *Instance) error {
-	return i64Load32s(ins)
+	base, err := memoryBase(ins)
+	if err != nil {
+		return err
+	}
+
+	v, err := ins.Memory.ReadUint32(base)
+	if err != nil {
+		return err
+	}
+
+	ins.OperandStack.Push(uint64(v))
+
+	return nil
 }
 
 func i32Store(ins *Instance) error {
@@ -143,9 +230,7 @@ func i32Store(ins *Instance) error {
 		return err
 	}
 
-	binary.LittleEndian.PutUint32(ins.Memory.Value[base:], uint32(val))
-
-	return nil
+	return ins.Memory.WriteUint32(base, uint32(val))
 }
 
 func i64Store(ins *Instance) error {
@@ -155,9 +240,7 @@ func i64Store(ins *Instance) error {
 		return err
 	}
 
-	binary.LittleEndian.PutUint64(ins.Memory.Value[base:], val)
-
-	return nil
+	return ins.Memory.WriteUint64(base, val)
 }
 
 func f32Store(ins *Instance) error {
@@ -167,9 +250,7 @@ func f32Store(ins *Instance) error {
 		return err
 	}
 
-	binary.LittleEndian.PutUint32(ins.Memory.Value[base:], uint32(val))
-
-	return nil
+	return ins.Memory.WriteUint32(base, uint32(val))
 }
 
 func f64Store(ins *Instance) error {
@@ -179,9 +260,7 @@ func f64Store(ins *Instance) error {
 		return err
 	}
 
-	binary.LittleEndian.PutUint64(ins.Memory.Value[base:], v)
-
-	return nil
+	return ins.Memory.WriteUint64(base, v)
 }
 
 func i32Store8(ins *Instance) error {
@@ -191,9 +270,7 @@ func i32Store8(ins *Instance) error {
 		return err
 	}
 
-	ins.Memory.Value[base] = v
-
-	return nil
+	return ins.Memory.WriteUint8(base, v)
 }
 
 func i32Store16(ins *Instance) error {
@@ -203,9 +280,7 @@ func i32Store16(ins *Instance) error {
 		return err
 	}
 
-	binary.LittleEndian.PutUint16(ins.Memory.Value[base:], v)
-
-	return nil
+	return ins.Memory.WriteUint16(base, v)
 }
 
 func i64Store8(ins *Instance) error {
@@ -215,9 +290,7 @@ func i64Store8(ins *Instance) error {
 		return err
 	}
 
-	ins.Memory.Value[base] = v
-
-	return nil
+	return ins.Memory.WriteUint8(base, v)
 }
 
 func i64Store16(ins *Instance) error {
@@ -227,9 +300,7 @@ func i64Store16(ins *Instance) error {
 		return err
 	}
 
-	binary.LittleEndian.PutUint16(ins.Memory.Value[base:], v)
-
-	return nil
+	return ins.Memory.WriteUint16(base, v)
 }
 
 func i64Store32(ins *Instance) error {
@@ -239,32 +310,20 @@ func i64Store32(ins *Instance) error {
 		return err
 	}
 
-	binary.LittleEndian.PutUint32(ins.Memory.Value[base:], v)
-
-	return nil
+	return ins.Memory.WriteUint32(base, v)
 }
 
 func memorySize(ins *Instance) error {
-	ins.Active.PC++
-	ins.OperandStack.Push(uint64(int32(len(ins.Memory.Value) / config.DefaultMemoryPageSize)))
+	ins.OperandStack.Push(uint64(ins.Memory.PageSize()))
 
 	return nil
 }
 
 func memoryGrow(ins *Instance) error {
-	ins.Active.PC++
 	n := uint32(ins.OperandStack.Pop())
 
-	if ins.Module.MemorySection[0].Max != nil &&
-		uint64(n+uint32(len(ins.Memory.Value)/config.DefaultMemoryPageSize)) > uint64(*(ins.Module.MemorySection[0].Max)) {
-		v := int32(-1)
-		ins.OperandStack.Push(uint64(v))
-
-		return nil
-	}
-
-	ins.OperandStack.Push(uint64(len(ins.Memory.Value)) / config.DefaultMemoryPageSize)
-	ins.Memory.Value = append(ins.Memory.Value, make([]byte, n*config.DefaultMemoryPageSize)...)
+	// -1 when failed to grow
+	ins.OperandStack.Push(uint64(int32(ins.Memory.Grow(n))))
 
 	return nil
 }
@@ -272,10 +331,10 @@ func memoryGrow(ins *Instance) error {
 var ErrInvalidSubcode = errors.New("invalid bulk memory subcode")
 
 func bulkMemory(ins *Instance) error {
-	ins.Active.PC++
-	opByte := ins.Active.Func.body[ins.Active.PC]
-
-	switch opByte {
+	switch ins.current().SubCode {
+	case 0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07:
+		// i32.trunc_sat_f32_s ... i64.trunc_sat_f64_u
+		return truncSat(ins)
 	case 0x08:
 		// memory.init
 		return memoryInit(ins)
@@ -312,88 +371,68 @@ func bulkMemory(ins *Instance) error {
 }
 
 func memoryInit(ins *Instance) error {
-	ins.Active.PC++
-
-	idx, err := ins.fetchUint32()
-	if err != nil {
-		return err
-	}
+	idx := ins.current().Immediates[0]
 
 	size := uint32(ins.OperandStack.Pop())
 	offset := uint32(ins.OperandStack.Pop())
 	dest := uint32(ins.OperandStack.Pop())
 
-	if dest+size > uint32(len(ins.Memory.Value)) {
-		return ErrPtrOutOfBounds
+	buf, err := ins.Memory.Read(dest, size)
+	if err != nil {
+		return err
 	}
 
-	if offset+size > uint32(len(ins.Module.DataSection[idx].OffsetExpression.Data)) {
+	if uint64(offset)+uint64(size) > uint64(len(ins.Module.DataSection[idx].OffsetExpression.Data)) {
 		return ErrPtrOutOfBounds
 	}
 
-	copy(ins.Memory.Value[dest:], ins.Module.DataSection[idx].OffsetExpression.Data[offset:offset+size])
+	copy(buf, ins.Module.DataSection[idx].OffsetExpression.Data[offset:offset+size])
 	return nil
 }
 
 func dataDrop(ins *Instance) error {
-	ins.Active.PC++
-
-	// value returned here is the index of the data segment.
-	_, err := ins.fetchUint32()
-	if err != nil {
-		return err
-	}
-
 	// TODO: can remove the memory in data section
 	return nil
 }
 
 func memoryCopy(ins *Instance) error {
-	ins.Active.PC++
-	ins.Active.PC++
-
 	size := uint32(ins.OperandStack.Pop())
 	src := uint32(ins.OperandStack.Pop())
 	dest := uint32(ins.OperandStack.Pop())
 
-	if src+size > uint32(len(ins.Memory.Value)) || dest+size > uint32(len(ins.Memory.Value)) {
-		return ErrPtrOutOfBounds
+	from, err := ins.Memory.Read(src, size)
+	if err != nil {
+		return err
 	}
 
-	copy(ins.Memory.Value[dest:], ins.Memory.Value[src:src+size])
+	to, err := ins.Memory.Read(dest, size)
+	if err != nil {
+		return err
+	}
+
+	copy(to, from)
 	return nil
 }
 
 func memoryFill(ins *Instance) error {
-	ins.Active.PC++
-
 	size := uint32(ins.OperandStack.Pop())
 	v := uint32(ins.OperandStack.Pop())
 	dest := uint32(ins.OperandStack.Pop())
 
-	if dest+size > uint32(len(ins.Memory.Value)) {
-		return ErrPtrOutOfBounds
+	buf, err := ins.Memory.Read(dest, size)
+	if err != nil {
+		return err
 	}
 
-	for i := dest; i < dest+size; i++ {
-		ins.Memory.Value[i] = byte(v)
+	for i := range buf {
+		buf[i] = byte(v)
 	}
 
 	return nil
 }
 
 func tableInit(ins *Instance) error {
-	ins.Active.PC++
-
-	eidx, err := ins.fetchUint32()
-	if err != nil {
-		return err
-	}
-
-	tidx, err := ins.fetchUint32()
-	if err != nil {
-		return err
-	}
+	eidx, tidx := ins.current().Immediates[0], ins.current().Immediates[1]
 
 	size := uint32(ins.OperandStack.Pop())
 	offset := uint32(ins.OperandStack.Pop())
@@ -413,32 +452,13 @@ func tableInit(ins *Instance) error {
 }
 
 func elementDrop(ins *Instance) error {
-	ins.Active.PC++
-
-	// value returned here is the index of the element.
-	_, err := ins.fetchUint32()
-	if err != nil {
-		return err
-	}
-
 	// TODO: can remove the memory in data section
 	return nil
 }
 
 func tableCopy(ins *Instance) error {
-	ins.Active.PC++
-
-	// value returned here is the index of the table.
-	xidx, err := ins.fetchUint32()
-	if err != nil {
-		return err
-	}
-
-	// value returned here is the index of the table.
-	yidx, err := ins.fetchUint32()
-	if err != nil {
-		return err
-	}
+	// the indexes of the tables
+	xidx, yidx := ins.current().Immediates[0], ins.current().Immediates[1]
 
 	size := uint32(ins.OperandStack.Pop())
 	src := uint32(ins.OperandStack.Pop())
@@ -453,26 +473,13 @@ func tableCopy(ins *Instance) error {
 }
 
 func tableGrow(ins *Instance) error {
-	ins.Active.PC++
-
-	// value returned here is the index of the table.
-	_, err := ins.fetchUint32()
-	if err != nil {
-		return err
-	}
-
 	// TODO: grow table
 	return nil
 }
 
 func tableSize(ins *Instance) error {
-	ins.Active.PC++
-
-	// value returned here is the index of the table.
-	idx, err := ins.fetchUint32()
-	if err != nil {
-		return err
-	}
+	// the index of the table
+	idx := ins.current().Immediates[0]
 
 	if len(ins.IndexSpace.Tables) == 0 {
 		v := int32(-1)
@@ -486,13 +493,8 @@ func tableSize(ins *Instance) error {
 }
 
 func tableFill(ins *Instance) error {
-	ins.Active.PC++
-
-	// value returned here is the index of the table.
-	idx, err := ins.fetchUint32()
-	if err != nil {
-		return err
-	}
+	// the index of the table
+	idx := ins.current().Immediates[0]
 
 	size := uint32(ins.OperandStack.Pop())
 	// the value to fill with
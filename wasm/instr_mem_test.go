@@ -17,7 +17,7 @@ func Test_i32Load(t *testing.T) {
 	vm := &Instance{
 		Active: &Frame{
 			Func: &wasmFunc{
-				body: []byte{byte(expr.OpCodeI32Load), 0x00, 0x01},
+				code: compiled(byte(expr.OpCodeI32Load), 0x00, 0x01),
 			},
 		},
 		Memory: &Memory{
@@ -39,7 +39,7 @@ func Test_i64Load(t *testing.T) {
 	vm := &Instance{
 		Active: &Frame{
 			Func: &wasmFunc{
-				body: []byte{byte(expr.OpCodeI64Load), 0x00, 0x01},
+				code: compiled(byte(expr.OpCodeI64Load), 0x00, 0x01),
 			},
 		},
 		Memory: &Memory{
@@ -61,7 +61,7 @@ func Test_f32Load(t *testing.T) {
 	vm := &Instance{
 		Active: &Frame{
 			Func: &wasmFunc{
-				body: []byte{byte(expr.OpCodeF32Load), 0x00, 0x01},
+				code: compiled(byte(expr.OpCodeF32Load), 0x00, 0x01),
 			},
 		},
 		Memory: &Memory{
@@ -84,7 +84,7 @@ func Test_f64Load(t *testing.T) {
 	vm := &Instance{
 		Active: &Frame{
 			Func: &wasmFunc{
-				body: []byte{byte(expr.OpCodeF64Load), 0x00, 0x01},
+				code: compiled(byte(expr.OpCodeF64Load), 0x00, 0x01),
 			},
 		},
 		Memory: &Memory{
@@ -106,7 +106,7 @@ func Test_i32Load8s(t *testing.T) {
 	vm := &Instance{
 		Active: &Frame{
 			Func: &wasmFunc{
-				body: []byte{byte(expr.OpCodeI32Load), 0x00, 0x01},
+				code: compiled(byte(expr.OpCodeI32Load), 0x00, 0x01),
 			},
 		},
 		Memory: &Memory{
@@ -128,7 +128,7 @@ func Test_i32Load8u(t *testing.T) {
 	vm := &Instance{
 		Active: &Frame{
 			Func: &wasmFunc{
-				body: []byte{byte(expr.OpCodeI32Load), 0x00, 0x01},
+				code: compiled(byte(expr.OpCodeI32Load), 0x00, 0x01),
 			},
 		},
 		Memory: &Memory{
@@ -150,7 +150,7 @@ func Test_i32Load16s(t *testing.T) {
 	vm := &Instance{
 		Active: &Frame{
 			Func: &wasmFunc{
-				body: []byte{byte(expr.OpCodeI32Load), 0x00, 0x01},
+				code: compiled(byte(expr.OpCodeI32Load), 0x00, 0x01),
 			},
 		},
 		Memory: &Memory{
@@ -172,7 +172,7 @@ func Test_i32Load16u(t *testing.T) {
 	vm := &Instance{
 		Active: &Frame{
 			Func: &wasmFunc{
-				body: []byte{byte(expr.OpCodeI32Load), 0x00, 0x01},
+				code: compiled(byte(expr.OpCodeI32Load), 0x00, 0x01),
 			},
 		},
 		Memory: &Memory{
@@ -194,7 +194,7 @@ func Test_i64Load8s(t *testing.T) {
 	vm := &Instance{
 		Active: &Frame{
 			Func: &wasmFunc{
-				body: []byte{byte(expr.OpCodeI32Load), 0x00, 0x01},
+				code: compiled(byte(expr.OpCodeI32Load), 0x00, 0x01),
 			},
 		},
 		Memory: &Memory{
@@ -216,7 +216,7 @@ func Test_i64Load8u(t *testing.T) {
 	vm := &Instance{
 		Active: &Frame{
 			Func: &wasmFunc{
-				body: []byte{byte(expr.OpCodeI32Load), 0x00, 0x01},
+				code: compiled(byte(expr.OpCodeI32Load), 0x00, 0x01),
 			},
 		},
 		Memory: &Memory{
@@ -238,7 +238,7 @@ func Test_i64Load16s(t *testing.T) {
 	vm := &Instance{
 		Active: &Frame{
 			Func: &wasmFunc{
-				body: []byte{byte(expr.OpCodeI32Load), 0x00, 0x01},
+				code: compiled(byte(expr.OpCodeI32Load), 0x00, 0x01),
 			},
 		},
 		Memory: &Memory{
@@ -260,7 +260,7 @@ func Test_i64Load16u(t *testing.T) {
 	vm := &Instance{
 		Active: &Frame{
 			Func: &wasmFunc{
-				body: []byte{byte(expr.OpCodeI32Load), 0x00, 0x01},
+				code: compiled(byte(expr.OpCodeI32Load), 0x00, 0x01),
 			},
 		},
 		Memory: &Memory{
@@ -282,7 +282,7 @@ func Test_i64Load32s(t *testing.T) {
 	vm := &Instance{
 		Active: &Frame{
 			Func: &wasmFunc{
-				body: []byte{byte(expr.OpCodeI32Load), 0x00, 0x01},
+				code: compiled(byte(expr.OpCodeI32Load), 0x00, 0x01),
 			},
 		},
 		Memory: &Memory{
@@ -304,7 +304,7 @@ func Test_i64Load32u(t *testing.T) {
 	vm := &Instance{
 		Active: &Frame{
 			Func: &wasmFunc{
-				body: []byte{byte(expr.OpCodeI32Load), 0x00, 0x01},
+				code: compiled(byte(expr.OpCodeI32Load), 0x00, 0x01),
 			},
 		},
 		Memory: &Memory{
@@ -326,7 +326,7 @@ func Test_i32Store(t *testing.T) {
 	vm := &Instance{
 		Active: &Frame{
 			Func: &wasmFunc{
-				body: []byte{byte(expr.OpCodeI32Store), 0x00, 0x01},
+				code: compiled(byte(expr.OpCodeI32Store), 0x00, 0x01),
 			},
 		},
 		Memory: &Memory{
@@ -349,7 +349,7 @@ func Test_i64Store(t *testing.T) {
 	vm := &Instance{
 		Active: &Frame{
 			Func: &wasmFunc{
-				body: []byte{byte(expr.OpCodeI32Store), 0x00, 0x01},
+				code: compiled(byte(expr.OpCodeI32Store), 0x00, 0x01),
 			},
 		},
 		Memory: &Memory{
@@ -377,7 +377,7 @@ func Test_f32Store(t *testing.T) {
 	vm := &Instance{
 		Active: &Frame{
 			Func: &wasmFunc{
-				body: []byte{byte(expr.OpCodeI32Store), 0x00, 0x01},
+				code: compiled(byte(expr.OpCodeI32Store), 0x00, 0x01),
 			},
 		},
 		Memory: &Memory{
@@ -400,7 +400,7 @@ func Test_f64Store(t *testing.T) {
 	vm := &Instance{
 		Active: &Frame{
 			Func: &wasmFunc{
-				body: []byte{byte(expr.OpCodeI32Store), 0x00, 0x01},
+				code: compiled(byte(expr.OpCodeI32Store), 0x00, 0x01),
 			},
 		},
 		Memory: &Memory{
@@ -423,7 +423,7 @@ func Test_i32store8(t *testing.T) {
 	vm := &Instance{
 		Active: &Frame{
 			Func: &wasmFunc{
-				body: []byte{byte(expr.OpCodeI32Store), 0x00, 0x01},
+				code: compiled(byte(expr.OpCodeI32Store), 0x00, 0x01),
 			},
 		},
 		Memory: &Memory{
@@ -446,7 +446,7 @@ func Test_i32store16(t *testing.T) {
 	vm := &Instance{
 		Active: &Frame{
 			Func: &wasmFunc{
-				body: []byte{byte(expr.OpCodeI32Store), 0x00, 0x01},
+				code: compiled(byte(expr.OpCodeI32Store), 0x00, 0x01),
 			},
 		},
 		Memory: &Memory{
@@ -469,7 +469,7 @@ func Test_i64store8(t *testing.T) {
 	vm := &Instance{
 		Active: &Frame{
 			Func: &wasmFunc{
-				body: []byte{byte(expr.OpCodeI32Store), 0x00, 0x01},
+				code: compiled(byte(expr.OpCodeI32Store), 0x00, 0x01),
 			},
 		},
 		Memory: &Memory{
@@ -492,7 +492,7 @@ func Test_i64store16(t *testing.T) {
 	vm := &Instance{
 		Active: &Frame{
 			Func: &wasmFunc{
-				body: []byte{byte(expr.OpCodeI32Store), 0x00, 0x01},
+				code: compiled(byte(expr.OpCodeI32Store), 0x00, 0x01),
 			},
 		},
 		Memory: &Memory{
@@ -515,7 +515,7 @@ func Test_i64store32(t *testing.T) {
 	vm := &Instance{
 		Active: &Frame{
 			Func: &wasmFunc{
-				body: []byte{byte(expr.OpCodeI32Store), 0x00, 0x01},
+				code: compiled(byte(expr.OpCodeI32Store), 0x00, 0x01),
 			},
 		},
 		Memory: &Memory{
@@ -580,7 +580,8 @@ func Test_memoryGrow(t *testing.T) {
 		vm := &Instance{
 			Active: &Frame{},
 			Memory: &Memory{
-				Value: make([]byte, config.DefaultMemoryPageSize*2),
+				MemoryType: types.MemoryType{Max: utils.Uint32Ptr(0)},
+				Value:      make([]byte, config.DefaultMemoryPageSize*2),
 			},
 			OperandStack: stacks.NewOperandStack(),
 			Module: &Module{
@@ -640,3 +641,69 @@ func Test_tableSize(t *testing.T) {
 func Test_tableFill(t *testing.T) {
 	t.Skip("TODO")
 }
+
+func Test_i32Load_outOfBounds(t *testing.T) {
+	vm := &Instance{
+		Active: &Frame{
+			Func: &wasmFunc{
+				code: compiled(byte(expr.OpCodeI32Load), 0x00, 0x00),
+			},
+		},
+		Memory: &Memory{
+			Value: []byte{0x00, 0x01, 0x00, 0x00, 0x00},
+		},
+		OperandStack: stacks.NewOperandStack(),
+	}
+
+	// the last byte is in the memory but the others are not
+	vm.OperandStack.Push(uint64(4))
+	if i32Load(vm) != ErrPtrOutOfBounds {
+		t.Fail()
+	}
+}
+
+func Test_loadExtends(t *testing.T) {
+	mem := []byte{0x00, 0x01, 0xff, 0x81, 0x7f}
+
+	for _, c := range []struct {
+		name   string
+		load   func(*Instance) error
+		offset uint64
+		exp    uint64
+	}{
+		// the signed loads extend the sign to the full width of the operand
+		{"i32.load8_s", i32Load8s, 2, 0xffffffff},
+		{"i32.load16_s", i32Load16s, 1, 0xffffff01},
+		{"i64.load8_s", i64Load8s, 2, 0xffffffffffffffff},
+		{"i64.load16_s", i64Load16s, 1, 0xffffffffffffff01},
+		{"i64.load32_s", i64Load32s, 0, 0xffffffff81ff0100},
+		{"i64.load32_s", i64Load32s, 1, 0x7f81ff01},
+		// the unsigned loads leave the upper bits zero
+		{"i32.load8_u", i32Load8u, 2, 0xff},
+		{"i32.load16_u", i32Load16u, 2, 0x81ff},
+		{"i64.load8_u", i64Load8u, 2, 0xff},
+		{"i64.load16_u", i64Load16u, 2, 0x81ff},
+		{"i64.load32_u", i64Load32u, 0, 0x81ff0100},
+	} {
+		vm := &Instance{
+			Active: &Frame{
+				Func: &wasmFunc{
+					code: compiled(byte(expr.OpCodeI32Load), 0x00, 0x00),
+				},
+			},
+			Memory: &Memory{
+				Value: mem,
+			},
+			OperandStack: stacks.NewOperandStack(),
+		}
+
+		vm.OperandStack.Push(c.offset)
+		if err := c.load(vm); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if got := vm.OperandStack.Pop(); got != c.exp {
+			t.Logf("%s at %d: got %#x, expected %#x", c.name, c.offset, got, c.exp)
+			t.Fail()
+		}
+	}
+}
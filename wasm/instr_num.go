@@ -213,7 +213,7 @@ func i64gts(ins *Instance) error {
 func i64gtu(ins *Instance) error {
 	v2 := ins.OperandStack.Pop()
 	v1 := ins.OperandStack.Pop()
-	if v1 < v2 {
+	if v1 > v2 {
 		ins.OperandStack.Push(1)
 	} else {
 		ins.OperandStack.Push(0)
@@ -306,8 +306,8 @@ func f32lt(ins *Instance) error {
 }
 
 func f32gt(ins *Instance) error {
-	f1 := math.Float32frombits(uint32(ins.OperandStack.Pop()))
 	f2 := math.Float32frombits(uint32(ins.OperandStack.Pop()))
+	f1 := math.Float32frombits(uint32(ins.OperandStack.Pop()))
 	if f1 > f2 {
 		ins.OperandStack.Push(1)
 	} else {
@@ -465,6 +465,9 @@ func i32divs(ins *Instance) error {
 func i32divu(ins *Instance) error {
 	v2 := uint32(ins.OperandStack.Pop())
 	v1 := uint32(ins.OperandStack.Pop())
+	if v2 == 0 {
+		return ErrUndefined
+	}
 	ins.OperandStack.Push(uint64(v1 / v2))
 
 	return nil
@@ -473,6 +476,9 @@ func i32divu(ins *Instance) error {
 func i32rems(ins *Instance) error {
 	v2 := int32(ins.OperandStack.Pop())
 	v1 := int32(ins.OperandStack.Pop())
+	if v2 == 0 {
+		return ErrUndefined
+	}
 	ins.OperandStack.Push(uint64(v1 % v2))
 
 	return nil
@@ -481,6 +487,9 @@ func i32rems(ins *Instance) error {
 func i32remu(ins *Instance) error {
 	v2 := uint32(ins.OperandStack.Pop())
 	v1 := uint32(ins.OperandStack.Pop())
+	if v2 == 0 {
+		return ErrUndefined
+	}
 	ins.OperandStack.Push(uint64(v1 % v2))
 
 	return nil
@@ -603,6 +612,9 @@ func i64divs(ins *Instance) error {
 func i64divu(ins *Instance) error {
 	v2 := ins.OperandStack.Pop()
 	v1 := ins.OperandStack.Pop()
+	if v2 == 0 {
+		return ErrUndefined
+	}
 	ins.OperandStack.Push(v1 / v2)
 
 	return nil
@@ -611,6 +623,9 @@ func i64divu(ins *Instance) error {
 func i64rems(ins *Instance) error {
 	v2 := int64(ins.OperandStack.Pop())
 	v1 := int64(ins.OperandStack.Pop())
+	if v2 == 0 {
+		return ErrUndefined
+	}
 	ins.OperandStack.Push(uint64(v1 % v2))
 
 	return nil
@@ -619,6 +634,9 @@ func i64rems(ins *Instance) error {
 func i64remu(ins *Instance) error {
 	v2 := ins.OperandStack.Pop()
 	v1 := ins.OperandStack.Pop()
+	if v2 == 0 {
+		return ErrUndefined
+	}
 	ins.OperandStack.Push(v1 % v2)
 
 	return nil
@@ -665,7 +683,7 @@ func i64shru(ins *Instance) error {
 }
 
 func i64shrs(ins *Instance) error {
-	v2 := int64(ins.OperandStack.Pop())
+	v2 := ins.OperandStack.Pop()
 	v1 := int64(ins.OperandStack.Pop())
 	ins.OperandStack.Push(uint64(v1 >> (v2 % 64)))
 
@@ -725,9 +743,8 @@ func f32trunc(ins *Instance) error {
 }
 
 func f32nearest(ins *Instance) error {
-	raw := math.Float32frombits(uint32(ins.OperandStack.Pop()))
-	v := math.Float64bits(float64(int32(raw + float32(math.Copysign(0.5, float64(raw))))))
-	ins.OperandStack.Push(v)
+	v := math.Float32frombits(uint32(ins.OperandStack.Pop()))
+	ins.OperandStack.Push(uint64(math.Float32bits(float32(math.RoundToEven(float64(v))))))
 
 	return nil
 }
@@ -780,7 +797,7 @@ func f32min(ins *Instance) error {
 func f32max(ins *Instance) error {
 	v2 := math.Float32frombits(uint32(ins.OperandStack.Pop()))
 	v1 := math.Float32frombits(uint32(ins.OperandStack.Pop()))
-	ins.OperandStack.Push(uint64(math.Float32bits(float32(math.Min(float64(v1), float64(v2))))))
+	ins.OperandStack.Push(uint64(math.Float32bits(float32(math.Max(float64(v1), float64(v2))))))
 
 	return nil
 }
@@ -830,9 +847,8 @@ func f64trunc(ins *Instance) error {
 }
 
 func f64nearest(ins *Instance) error {
-	raw := math.Float64frombits(ins.OperandStack.Pop())
-	v := math.Float64bits(float64(int64(raw + math.Copysign(0.5, raw))))
-	ins.OperandStack.Push(v)
+	v := math.Float64frombits(ins.OperandStack.Pop())
+	ins.OperandStack.Push(math.Float64bits(math.RoundToEven(v)))
 
 	return nil
 }
@@ -885,7 +901,7 @@ func f64min(ins *Instance) error {
 func f64max(ins *Instance) error {
 	v2 := math.Float64frombits(ins.OperandStack.Pop())
 	v1 := math.Float64frombits(ins.OperandStack.Pop())
-	ins.OperandStack.Push(math.Float64bits(math.Min(v1, v2)))
+	ins.OperandStack.Push(math.Float64bits(math.Max(v1, v2)))
 
 	return nil
 }
@@ -974,6 +990,58 @@ func i64truncf64u(ins *Instance) error {
 	return nil
 }
 
+// truncSatS truncates v to a signed int of the bits, NaN is 0 and the overflow saturates
+func truncSatS(v float64, bits uint) int64 {
+	switch lim := math.Ldexp(1, int(bits)-1); {
+	case math.IsNaN(v):
+		return 0
+	case v >= lim:
+		return math.MaxInt64 >> (64 - bits)
+	case v < -lim:
+		return math.MinInt64 >> (64 - bits)
+	default:
+		return int64(math.Trunc(v))
+	}
+}
+
+// truncSatU truncates v to an unsigned int of the bits, NaN is 0 and the overflow saturates
+func truncSatU(v float64, bits uint) uint64 {
+	switch lim := math.Ldexp(1, int(bits)); {
+	case math.IsNaN(v) || v <= -1:
+		return 0
+	case v >= lim:
+		return math.MaxUint64 >> (64 - bits)
+	default:
+		return uint64(math.Trunc(v))
+	}
+}
+
+// truncSat runs the saturating truncation of the subcode 0x00 to 0x07
+func truncSat(ins *Instance) error {
+	sub := ins.current().SubCode
+
+	var v float64
+	if sub&0x02 == 0 {
+		// the operand of i32.trunc_sat_f32 and i64.trunc_sat_f32
+		v = float64(math.Float32frombits(uint32(ins.OperandStack.Pop())))
+	} else {
+		v = math.Float64frombits(ins.OperandStack.Pop())
+	}
+
+	bits := uint(32)
+	if sub >= 0x04 {
+		bits = 64
+	}
+
+	if sub&0x01 == 0 {
+		ins.OperandStack.Push(uint64(truncSatS(v, bits)) & (math.MaxUint64 >> (64 - bits)))
+	} else {
+		ins.OperandStack.Push(truncSatU(v, bits))
+	}
+
+	return nil
+}
+
 func f32converti32s(ins *Instance) error {
 	v := float32(int32(ins.OperandStack.Pop()))
 	ins.OperandStack.Push(uint64(math.Float32bits(v)))
@@ -1043,3 +1111,38 @@ func f64promotef32(ins *Instance) error {
 
 	return nil
 }
+
+func i32extend8s(ins *Instance) error {
+	v := int32(int8(ins.OperandStack.Pop()))
+	ins.OperandStack.Push(uint64(uint32(v)))
+
+	return nil
+}
+
+func i32extend16s(ins *Instance) error {
+	v := int32(int16(ins.OperandStack.Pop()))
+	ins.OperandStack.Push(uint64(uint32(v)))
+
+	return nil
+}
+
+func i64extend8s(ins *Instance) error {
+	v := int64(int8(ins.OperandStack.Pop()))
+	ins.OperandStack.Push(uint64(v))
+
+	return nil
+}
+
+func i64extend16s(ins *Instance) error {
+	v := int64(int16(ins.OperandStack.Pop()))
+	ins.OperandStack.Push(uint64(v))
+
+	return nil
+}
+
+func i64extend32s(ins *Instance) error {
+	v := int64(int32(ins.OperandStack.Pop()))
+	ins.OperandStack.Push(uint64(v))
+
+	return nil
+}
@@ -1,8 +1,10 @@
 package wasm
 
 import (
+	"math"
 	"testing"
 
+	"github.com/hybridgroup/wasman/expr"
 	"github.com/hybridgroup/wasman/stacks"
 )
 
@@ -115,6 +117,151 @@ func (s *NumTestSet) Test_i32gts(t *testing.T) {
 	}
 }
 
+func (s *NumTestSet) Test_extends(t *testing.T) {
+	var testTable = []struct {
+		f     func(ins *Instance) error
+		input uint64
+		want  uint64
+	}{
+		{f: i32extend8s, input: 0x7f, want: 0x7f},
+		{f: i32extend8s, input: 0x1280, want: 0xffffff80},
+		{f: i32extend16s, input: 0x18000, want: 0xffff8000},
+		{f: i64extend8s, input: 0x80, want: 0xffffffffffffff80},
+		{f: i64extend16s, input: 0x7fff, want: 0x7fff},
+		{f: i64extend32s, input: 0x180000000, want: 0xffffffff80000000},
+	}
+	for _, tt := range testTable {
+		s.vm.OperandStack.Push(tt.input)
+		if tt.f(s.vm) != nil {
+			t.Fail()
+		}
+		if v := s.vm.OperandStack.Pop(); v != tt.want {
+			t.Logf("extend %#x: %#x", tt.input, v)
+			t.Fail()
+		}
+	}
+}
+
+func (s *NumTestSet) Test_i64gtu(t *testing.T) {
+	var testTable = []struct {
+		input [2]uint64
+		want  uint64
+	}{
+		{input: [2]uint64{0xffffffffffffffff, 1}, want: 1},
+		{input: [2]uint64{1, 0xffffffffffffffff}, want: 0},
+		{input: [2]uint64{1, 1}, want: 0},
+	}
+	for _, tt := range testTable {
+		s.vm.OperandStack.Push(tt.input[0])
+		s.vm.OperandStack.Push(tt.input[1])
+		if i64gtu(s.vm) != nil {
+			t.Fail()
+		}
+		if s.vm.OperandStack.Pop() != tt.want {
+			t.Fail()
+		}
+	}
+}
+
+func (s *NumTestSet) Test_floats(t *testing.T) {
+	f32 := func(v float32) uint64 { return uint64(math.Float32bits(v)) }
+	f64 := math.Float64bits
+
+	var testTable = []struct {
+		f     func(ins *Instance) error
+		input []uint64
+		want  uint64
+	}{
+		{f: f32gt, input: []uint64{f32(2), f32(1)}, want: 1},
+		{f: f32gt, input: []uint64{f32(1), f32(2)}, want: 0},
+		{f: f32max, input: []uint64{f32(-1), f32(2)}, want: f32(2)},
+		{f: f64max, input: []uint64{f64(-1), f64(2)}, want: f64(2)},
+		{f: f32nearest, input: []uint64{f32(2.5)}, want: f32(2)},
+		{f: f32nearest, input: []uint64{f32(-3.5)}, want: f32(-4)},
+		{f: f32nearest, input: []uint64{f32(1e10)}, want: f32(1e10)},
+		{f: f64nearest, input: []uint64{f64(0.5)}, want: f64(0)},
+		{f: f64nearest, input: []uint64{f64(-0.4)}, want: f64(math.Copysign(0, -1))},
+		{f: f64nearest, input: []uint64{f64(1e300)}, want: f64(1e300)},
+	}
+	for _, tt := range testTable {
+		for _, v := range tt.input {
+			s.vm.OperandStack.Push(v)
+		}
+		if tt.f(s.vm) != nil {
+			t.Fail()
+		}
+		if v := s.vm.OperandStack.Pop(); v != tt.want {
+			t.Logf("%v: %#x, want %#x", tt.input, v, tt.want)
+			t.Fail()
+		}
+	}
+}
+
+func (s *NumTestSet) Test_divByZero(t *testing.T) {
+	for _, f := range []func(ins *Instance) error{i32divs, i32divu, i32rems, i32remu, i64divs, i64divu, i64rems, i64remu} {
+		s.vm.OperandStack.Push(1)
+		s.vm.OperandStack.Push(0)
+		if f(s.vm) != ErrUndefined {
+			t.Fail()
+		}
+	}
+}
+
+func (s *NumTestSet) Test_i64shrs(t *testing.T) {
+	s.vm.OperandStack.Push(0xfffffffffffffff8) // -8
+	s.vm.OperandStack.Push(0xffffffffffffffff) // -1, which is 63 modulo 64
+	if i64shrs(s.vm) != nil {
+		t.Fail()
+	}
+	if s.vm.OperandStack.Pop() != 0xffffffffffffffff {
+		t.Fail()
+	}
+}
+
+func (s *NumTestSet) Test_truncSat(t *testing.T) {
+	f32 := func(v float32) uint64 { return uint64(math.Float32bits(v)) }
+	f64 := math.Float64bits
+
+	for _, c := range []struct {
+		sub uint32
+		v   uint64
+		exp uint64
+	}{
+		{0x00, f32(-1.5), 0xffffffff},
+		{0x00, f32(3e9), 0x7fffffff},
+		{0x00, f32(-3e9), 0x80000000},
+		{0x00, f32(float32(math.NaN())), 0},
+		{0x01, f32(-1.5), 0},
+		{0x01, f32(5e9), 0xffffffff},
+		{0x02, f64(-2147483648.9), 0x80000000},
+		{0x03, f64(4294967295.9), 0xffffffff},
+		{0x04, f32(-1e19), 0x8000000000000000},
+		{0x05, f32(float32(math.Inf(1))), 0xffffffffffffffff},
+		{0x06, f64(9.3e18), 0x7fffffffffffffff},
+		{0x06, f64(-7.5), 0xfffffffffffffff9},
+		{0x07, f64(math.NaN()), 0},
+		{0x07, f64(1.8e19), 0xf9ccd8a1c5080000},
+	} {
+		vm := &Instance{
+			Active: &Frame{
+				Func: &wasmFunc{
+					code: []instruction{{OpCode: expr.OpCodeBulkMemory, SubCode: c.sub}},
+				},
+			},
+			OperandStack: s.vm.OperandStack,
+		}
+
+		vm.OperandStack.Push(c.v)
+		if err := bulkMemory(vm); err != nil {
+			t.Fatal(err)
+		}
+		if got := s.vm.OperandStack.Pop(); got != c.exp {
+			t.Logf("trunc_sat %#x of %#x: got %#x, expected %#x", c.sub, c.v, got, c.exp)
+			t.Fail()
+		}
+	}
+}
+
 func TestRunSuite(t *testing.T) {
 	set := new(NumTestSet)
 	set.SetupTest()
@@ -123,4 +270,10 @@ func TestRunSuite(t *testing.T) {
 	set.Test_i32lts(t)
 	set.Test_i32ltu(t)
 	set.Test_i32gts(t)
+	set.Test_extends(t)
+	set.Test_i64gtu(t)
+	set.Test_floats(t)
+	set.Test_divByZero(t)
+	set.Test_i64shrs(t)
+	set.Test_truncSat(t)
 }
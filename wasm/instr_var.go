@@ -1,11 +1,7 @@
 package wasm
 
 func getLocal(ins *Instance) error {
-	ins.Active.PC++
-	id, err := ins.fetchUint32()
-	if err != nil {
-		return err
-	}
+	id := ins.current().Immediates[0]
 
 	ins.OperandStack.Push(ins.Active.Locals[id])
 
@@ -13,11 +9,7 @@ func getLocal(ins *Instance) error {
 }
 
 func setLocal(ins *Instance) error {
-	ins.Active.PC++
-	id, err := ins.fetchUint32()
-	if err != nil {
-		return err
-	}
+	id := ins.current().Immediates[0]
 
 	v := ins.OperandStack.Pop()
 	ins.Active.Locals[id] = v
@@ -26,11 +18,7 @@ func setLocal(ins *Instance) error {
 }
 
 func teeLocal(ins *Instance) error {
-	ins.Active.PC++
-	id, err := ins.fetchUint32()
-	if err != nil {
-		return err
-	}
+	id := ins.current().Immediates[0]
 
 	v := ins.OperandStack.Peek()
 	ins.Active.Locals[id] = v
@@ -39,11 +27,7 @@ func teeLocal(ins *Instance) error {
 }
 
 func getGlobal(ins *Instance) error {
-	ins.Active.PC++
-	id, err := ins.fetchUint32()
-	if err != nil {
-		return err
-	}
+	id := ins.current().Immediates[0]
 
 	ins.OperandStack.Push(ins.Globals[id])
 
@@ -51,11 +35,7 @@ func getGlobal(ins *Instance) error {
 }
 
 func setGlobal(ins *Instance) error {
-	ins.Active.PC++
-	id, err := ins.fetchUint32()
-	if err != nil {
-		return err
-	}
+	id := ins.current().Immediates[0]
 
 	ins.Globals[id] = ins.OperandStack.Pop()
 
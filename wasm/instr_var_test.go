@@ -11,7 +11,7 @@ func Test_getLocal(t *testing.T) {
 	exp := uint64(100)
 	ctx := &Frame{
 		Func: &wasmFunc{
-			body: []byte{byte(expr.OpCodeLocalGet), 0x05},
+			code: compiled(byte(expr.OpCodeLocalGet), 0x05),
 		},
 		Locals: []uint64{0, 0, 0, 0, 0, exp},
 	}
@@ -32,7 +32,7 @@ func Test_getLocal(t *testing.T) {
 func Test_setLocal(t *testing.T) {
 	ctx := &Frame{
 		Func: &wasmFunc{
-			body: []byte{byte(expr.OpCodeLocalSet), 0x05},
+			code: compiled(byte(expr.OpCodeLocalSet), 0x05),
 		},
 		Locals: make([]uint64, 100),
 	}
@@ -57,7 +57,7 @@ func Test_setLocal(t *testing.T) {
 func Test_teeLocal(t *testing.T) {
 	ctx := &Frame{
 		Func: &wasmFunc{
-			body: []byte{byte(expr.OpCodeLocalTee), 0x05},
+			code: compiled(byte(expr.OpCodeLocalTee), 0x05),
 		},
 		Locals: make([]uint64, 100),
 	}
@@ -82,7 +82,7 @@ func Test_teeLocal(t *testing.T) {
 func Test_getGlobal(t *testing.T) {
 	ctx := &Frame{
 		Func: &wasmFunc{
-			body: []byte{byte(expr.OpCodeGlobalGet), 0x05},
+			code: compiled(byte(expr.OpCodeGlobalGet), 0x05),
 		},
 	}
 
@@ -109,7 +109,7 @@ func Test_getGlobal(t *testing.T) {
 func Test_setGlobal(t *testing.T) {
 	ctx := &Frame{
 		Func: &wasmFunc{
-			body: []byte{byte(expr.OpCodeGlobalSet), 0x05},
+			code: compiled(byte(expr.OpCodeGlobalSet), 0x05),
 		},
 	}
 
@@ -1,41 +1,255 @@
 package wasm
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+
 	"github.com/hybridgroup/wasman/config"
 	"github.com/hybridgroup/wasman/types"
 )
 
+// errors on the memory
+var (
+	ErrMemoryLimitExceeded = errors.New("memory limit exceeded")
+	ErrInvalidPageSize     = errors.New("invalid memory page size")
+)
+
 // Memory is an instance of the memory value
 type Memory struct {
 	types.MemoryType
 	External bool
 	Value    []byte
-}
 
-// memoryBytesNumToPages converts the given number of bytes into the number of pages.
-func memoryBytesNumToPages(bytesNum uint64) (pages uint32) {
-	return uint32(bytesNum >> config.DefaultMemoryPageSizeInBits)
+	limit     *uint32 // set by the config.ModuleConfig.MemoryLimitPages
+	growHooks []func(prevPages, pages uint32)
 }
 
-// MemoryPagesToBytesNum converts the given pages into the number of bytes contained in these pages.
+// MemoryPagesToBytesNum converts the given pages of the default size into the number of bytes contained in these pages.
 func MemoryPagesToBytesNum(pages uint32) (bytesNum uint64) {
 	return uint64(pages) << config.DefaultMemoryPageSizeInBits
 }
 
+// PageSizeInBits returns the log2 of the size of the pages of the memory,
+// which is set by the custom-page-sizes or the config.ModuleConfig.MemoryPageSize
+func (mem *Memory) PageSizeInBits() uint32 {
+	if mem.PageSizeLog2 != nil {
+		return *mem.PageSizeLog2
+	}
+
+	return config.DefaultMemoryPageSizeInBits
+}
+
+// BytesPerPage returns the size of the pages of the memory in bytes
+func (mem *Memory) BytesPerPage() uint64 {
+	return 1 << mem.PageSizeInBits()
+}
+
+// pagesToBytes converts the given pages into the number of bytes contained in these pages.
+func (mem *Memory) pagesToBytes(pages uint32) uint64 {
+	return uint64(pages) << mem.PageSizeInBits()
+}
+
 // PageSize returns the current memory buffer size in pages.
-func (m *Memory) PageSize() uint32 {
-	return memoryBytesNumToPages(uint64(len(m.Value)))
+func (mem *Memory) PageSize() uint32 {
+	return uint32(uint64(len(mem.Value)) >> mem.PageSizeInBits())
+}
+
+// MaxPages returns the number of pages the memory can grow to,
+// which is the least of its Max, the limit of the config and the pages of the 32-bit address space
+func (mem *Memory) MaxPages() uint32 {
+	max := uint32(math.MaxUint32)
+	if pages := uint64(1) << (32 - mem.PageSizeInBits()); pages < math.MaxUint32 {
+		max = uint32(pages) // e.g. DefaultMemoryMaxPages for the default page size
+	}
+
+	if mem.Max != nil && *mem.Max < max {
+		max = *mem.Max
+	}
+
+	if mem.limit != nil && *mem.limit < max {
+		max = *mem.limit
+	}
+
+	return max
 }
 
+// Grow grows the memory by the newPages, returning the previous page size,
+// or 0xffffffff when the max of the memory would be exceeded
 func (mem *Memory) Grow(newPages uint32) (result uint32) {
-	currentPages := memoryBytesNumToPages(uint64(len(mem.Value)))
+	currentPages := mem.PageSize()
 
-	if mem.Max != nil &&
-		newPages+currentPages > *(mem.Max) {
+	if uint64(newPages)+uint64(currentPages) > uint64(mem.MaxPages()) {
 		return 0xffffffff // failed to grow
 	}
 
-	mem.Value = append(mem.Value, make([]byte, MemoryPagesToBytesNum(newPages))...)
+	mem.Value = append(mem.Value, make([]byte, mem.pagesToBytes(newPages))...)
+
+	if newPages > 0 {
+		for _, hook := range mem.growHooks {
+			hook(currentPages, currentPages+newPages)
+		}
+	}
 
 	return currentPages
 }
+
+// OnGrow registers the hook called after the memory grows, with the page sizes before and after growing.
+// The slices of the Value taken before growing may not be backed by the memory anymore,
+// the MemoryViews always resolve against the current Value.
+func (mem *Memory) OnGrow(hook func(prevPages, pages uint32)) {
+	mem.growHooks = append(mem.growHooks, hook)
+}
+
+// Read returns the l bytes at the ptr, the returned bytes share the memory.
+// ErrPtrOutOfBounds is returned when the range is out of the memory.
+func (mem *Memory) Read(ptr, l uint32) ([]byte, error) {
+	end := uint64(ptr) + uint64(l)
+	if end > uint64(len(mem.Value)) {
+		return nil, ErrPtrOutOfBounds
+	}
+
+	return mem.Value[ptr:end], nil
+}
+
+// Write copies the b into the memory at the ptr
+func (mem *Memory) Write(ptr uint32, b []byte) error {
+	buf, err := mem.Read(ptr, uint32(len(b)))
+	if err != nil {
+		return err
+	}
+
+	copy(buf, b)
+	return nil
+}
+
+// ReadString returns the string of the l bytes at the ptr
+func (mem *Memory) ReadString(ptr, l uint32) (string, error) {
+	b, err := mem.Read(ptr, l)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+// ReadCString returns the NUL-terminated string at the ptr, without the NUL
+func (mem *Memory) ReadCString(ptr uint32) (string, error) {
+	if uint64(ptr) >= uint64(len(mem.Value)) {
+		return "", ErrPtrOutOfBounds
+	}
+
+	l := bytes.IndexByte(mem.Value[ptr:], 0)
+	if l < 0 {
+		return "", ErrPtrOutOfBounds
+	}
+
+	return string(mem.Value[ptr : int(ptr)+l]), nil
+}
+
+// ReadUint8 reads the byte at the ptr
+func (mem *Memory) ReadUint8(ptr uint32) (uint8, error) {
+	b, err := mem.Read(ptr, 1)
+	if err != nil {
+		return 0, err
+	}
+
+	return b[0], nil
+}
+
+// ReadUint16 reads the little endian uint16 at the ptr
+func (mem *Memory) ReadUint16(ptr uint32) (uint16, error) {
+	b, err := mem.Read(ptr, 2)
+	if err != nil {
+		return 0, err
+	}
+
+	return binary.LittleEndian.Uint16(b), nil
+}
+
+// ReadUint32 reads the little endian uint32 at the ptr
+func (mem *Memory) ReadUint32(ptr uint32) (uint32, error) {
+	b, err := mem.Read(ptr, 4)
+	if err != nil {
+		return 0, err
+	}
+
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+// ReadUint64 reads the little endian uint64 at the ptr
+func (mem *Memory) ReadUint64(ptr uint32) (uint64, error) {
+	b, err := mem.Read(ptr, 8)
+	if err != nil {
+		return 0, err
+	}
+
+	return binary.LittleEndian.Uint64(b), nil
+}
+
+// ReadFloat32 reads the little endian float32 at the ptr
+func (mem *Memory) ReadFloat32(ptr uint32) (float32, error) {
+	v, err := mem.ReadUint32(ptr)
+	return math.Float32frombits(v), err
+}
+
+// ReadFloat64 reads the little endian float64 at the ptr
+func (mem *Memory) ReadFloat64(ptr uint32) (float64, error) {
+	v, err := mem.ReadUint64(ptr)
+	return math.Float64frombits(v), err
+}
+
+// WriteUint8 writes the byte at the ptr
+func (mem *Memory) WriteUint8(ptr uint32, v uint8) error {
+	b, err := mem.Read(ptr, 1)
+	if err != nil {
+		return err
+	}
+
+	b[0] = v
+	return nil
+}
+
+// WriteUint16 writes the v at the ptr in little endian
+func (mem *Memory) WriteUint16(ptr uint32, v uint16) error {
+	b, err := mem.Read(ptr, 2)
+	if err != nil {
+		return err
+	}
+
+	binary.LittleEndian.PutUint16(b, v)
+	return nil
+}
+
+// WriteUint32 writes the v at the ptr in little endian
+func (mem *Memory) WriteUint32(ptr uint32, v uint32) error {
+	b, err := mem.Read(ptr, 4)
+	if err != nil {
+		return err
+	}
+
+	binary.LittleEndian.PutUint32(b, v)
+	return nil
+}
+
+// WriteUint64 writes the v at the ptr in little endian
+func (mem *Memory) WriteUint64(ptr uint32, v uint64) error {
+	b, err := mem.Read(ptr, 8)
+	if err != nil {
+		return err
+	}
+
+	binary.LittleEndian.PutUint64(b, v)
+	return nil
+}
+
+// WriteFloat32 writes the v at the ptr in little endian
+func (mem *Memory) WriteFloat32(ptr uint32, v float32) error {
+	return mem.WriteUint32(ptr, math.Float32bits(v))
+}
+
+// WriteFloat64 writes the v at the ptr in little endian
+func (mem *Memory) WriteFloat64(ptr uint32, v float64) error {
+	return mem.WriteUint64(ptr, math.Float64bits(v))
+}
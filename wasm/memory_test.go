@@ -0,0 +1,116 @@
+package wasm
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/hybridgroup/wasman/config"
+)
+
+func TestMemory_accessors(t *testing.T) {
+	mem := &Memory{Value: make([]byte, 16)}
+
+	if mem.WriteUint32(0, 0x01020304) != nil || mem.WriteUint16(4, 0x0506) != nil || mem.WriteUint8(6, 0x07) != nil {
+		t.Fail()
+	}
+	if v, err := mem.ReadUint32(0); err != nil || v != 0x01020304 {
+		t.Fail()
+	}
+	if v, err := mem.ReadUint16(4); err != nil || v != 0x0506 {
+		t.Fail()
+	}
+	if v, err := mem.ReadUint8(6); err != nil || v != 0x07 {
+		t.Fail()
+	}
+
+	if mem.WriteFloat64(8, 1.5) != nil {
+		t.Fail()
+	}
+	if v, err := mem.ReadFloat64(8); err != nil || v != 1.5 {
+		t.Fail()
+	}
+	if mem.WriteFloat32(12, -2) != nil {
+		t.Fail()
+	}
+	if v, err := mem.ReadFloat32(12); err != nil || v != -2 {
+		t.Fail()
+	}
+
+	if mem.Write(0, []byte("hello\x00")) != nil {
+		t.Fail()
+	}
+	if s, err := mem.ReadString(1, 4); err != nil || s != "ello" {
+		t.Fail()
+	}
+	if s, err := mem.ReadCString(0); err != nil || s != "hello" {
+		t.Fail()
+	}
+
+	// out of bounds
+	if _, err := mem.ReadUint64(9); !errors.Is(err, ErrPtrOutOfBounds) {
+		t.Fail()
+	}
+	if _, err := mem.Read(0xffffffff, 2); !errors.Is(err, ErrPtrOutOfBounds) {
+		t.Fail()
+	}
+	if err := mem.Write(15, []byte{1, 2}); !errors.Is(err, ErrPtrOutOfBounds) {
+		t.Fail()
+	}
+	if _, err := mem.ReadCString(16); !errors.Is(err, ErrPtrOutOfBounds) {
+		t.Fail()
+	}
+	if _, err := (&Memory{Value: []byte("abc")}).ReadCString(0); !errors.Is(err, ErrPtrOutOfBounds) {
+		t.Fail()
+	}
+}
+
+func TestMemory_View(t *testing.T) {
+	mem := &Memory{Value: make([]byte, config.DefaultMemoryPageSize)}
+
+	var grown []uint32
+	mem.OnGrow(func(prevPages, pages uint32) {
+		grown = append(grown, prevPages, pages)
+	})
+
+	view := mem.View(config.DefaultMemoryPageSize-2, 4)
+	if _, err := view.Bytes(); !errors.Is(err, ErrPtrOutOfBounds) {
+		t.Fail()
+	}
+
+	if mem.Grow(1) != 1 || len(grown) != 2 || grown[0] != 1 || grown[1] != 2 {
+		t.Logf("grown: %v", grown)
+		t.Fail()
+	}
+
+	// the view resolves against the grown memory
+	if n, err := view.WriteAt([]byte{1, 2, 3}, 1); err != nil || n != 3 {
+		t.Fail()
+	}
+	if mem.Value[config.DefaultMemoryPageSize+1] != 3 {
+		t.Fail()
+	}
+
+	buf := make([]byte, 8)
+	if n, err := view.ReadAt(buf, 2); err != io.EOF || n != 2 || buf[0] != 2 || buf[1] != 3 {
+		t.Logf("%d, %v, %v", n, err, buf)
+		t.Fail()
+	}
+	if n, err := view.WriteAt([]byte{1, 2}, 3); err != io.ErrShortWrite || n != 1 {
+		t.Fail()
+	}
+
+	// past the end of the view
+	if n, err := view.ReadAt(buf, 5); err != io.EOF || n != 0 {
+		t.Fail()
+	}
+	if n, err := view.WriteAt([]byte{1}, 5); err != io.ErrShortWrite || n != 0 {
+		t.Logf("%d, %v", n, err)
+		t.Fail()
+	}
+
+	// the hooks are not called when not grown
+	if mem.Grow(0) != 2 || len(grown) != 2 {
+		t.Fail()
+	}
+}
@@ -0,0 +1,73 @@
+package wasm
+
+import (
+	"io"
+)
+
+// MemoryView is a range of the Memory which resolves against the current Value on every access,
+// so it can be kept by the host across the calls and stays valid after the memory grows.
+type MemoryView struct {
+	mem *Memory
+	Ptr uint32
+	Len uint32
+}
+
+// View returns the MemoryView of the l bytes at the ptr,
+// the range is checked on the access rather than here.
+func (mem *Memory) View(ptr, l uint32) MemoryView {
+	return MemoryView{mem: mem, Ptr: ptr, Len: l}
+}
+
+// Bytes returns the bytes of the view in the current Value,
+// which should not be kept after the memory grows
+func (v MemoryView) Bytes() ([]byte, error) {
+	return v.mem.Read(v.Ptr, v.Len)
+}
+
+// ReadAt implements the io.ReaderAt, the off is relative to the view
+func (v MemoryView) ReadAt(p []byte, off int64) (int, error) {
+	b, err := v.slice(off, io.EOF)
+	if err != nil {
+		return 0, err
+	}
+
+	n := copy(p, b)
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// WriteAt implements the io.WriterAt, the off is relative to the view
+func (v MemoryView) WriteAt(p []byte, off int64) (int, error) {
+	b, err := v.slice(off, io.ErrShortWrite)
+	if err != nil {
+		return 0, err
+	}
+
+	n := copy(b, p)
+	if n < len(p) {
+		return n, io.ErrShortWrite
+	}
+
+	return n, nil
+}
+
+// slice returns the bytes of the view from the off, errPastEnd when the off is past the end
+func (v MemoryView) slice(off int64, errPastEnd error) ([]byte, error) {
+	if off < 0 {
+		return nil, ErrPtrOutOfBounds
+	}
+
+	b, err := v.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	if off > int64(len(b)) {
+		return nil, errPastEnd
+	}
+
+	return b[off:], nil
+}
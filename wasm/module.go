@@ -42,10 +42,21 @@ type Module struct {
 	DataSection     []*segments.DataSegment
 	DataCount       uint32
 
-	// index spaces
+	// custom sections
+	CustomSections []*CustomSection      // all the custom sections in the order of the binary
+	NameSection    *segments.NameSection // nil if the module has no valid "name" section
+
+	// index spaces, defined by the Linker for the host modules,
+	// or built on the instance's own copy of the module when instantiating
 	IndexSpace *IndexSpace
 }
 
+// CustomSection is a custom section of the module, https://www.w3.org/TR/wasm-core-1/#custom-section
+type CustomSection struct {
+	Name string
+	Data []byte // the contents after the name
+}
+
 // IndexSpace is the indeices to the imports
 type IndexSpace struct {
 	Functions []fn
@@ -75,6 +86,18 @@ func NewModule(config config.ModuleConfig, r utils.Reader) (*Module, error) {
 		return nil, fmt.Errorf("readSections failed: %w", err)
 	}
 
+	if config.EnableValidation {
+		if err := module.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.DisableFloatPoint {
+		if err := module.checkFloatPoint(); err != nil {
+			return nil, err
+		}
+	}
+
 	return module, nil
 }
 
@@ -83,3 +106,26 @@ func (m *Module) log(text string) {
 		m.ModuleConfig.Logger(text)
 	}
 }
+
+// FunctionName returns the name of the func at the index in the function index space,
+// which is defined in the "name" section
+func (m *Module) FunctionName(idx uint32) (string, bool) {
+	if m.NameSection == nil {
+		return "", false
+	}
+
+	name, ok := m.NameSection.FunctionNames[idx]
+	return name, ok
+}
+
+// CustomSection returns the first custom section with the name,
+// the custom sections sharing the same name can be found in the CustomSections
+func (m *Module) CustomSection(name string) (*CustomSection, bool) {
+	for _, cs := range m.CustomSections {
+		if cs.Name == name {
+			return cs, true
+		}
+	}
+
+	return nil, false
+}
@@ -1,6 +1,7 @@
 package wasm
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
@@ -52,9 +53,7 @@ func (m *Module) readSection(r utils.Reader) error {
 
 	switch sectionID(b[0]) {
 	case sectionIDCustom:
-		// Custom section is ignored here: https://www.w3.org/TR/wasm-core-1/#custom-section
-		bb := make([]byte, ss)
-		_, err = io.ReadFull(r, bb)
+		err = m.readSectionCustom(r, ss)
 	case sectionIDType:
 		err = m.readSectionTypes(r)
 	case sectionIDImport:
@@ -89,6 +88,36 @@ func (m *Module) readSection(r utils.Reader) error {
 	return nil
 }
 
+// readSectionCustom reads the custom section, https://www.w3.org/TR/wasm-core-1/#custom-section,
+// the "name" section is decoded additionally
+func (m *Module) readSectionCustom(r utils.Reader, size uint32) error {
+	bb := make([]byte, size)
+	if _, err := io.ReadFull(r, bb); err != nil {
+		return fmt.Errorf("read custom section: %w", err)
+	}
+
+	br := bytes.NewReader(bb)
+	name, err := types.ReadNameValue(br)
+	if err != nil {
+		return fmt.Errorf("read name of custom section: %w", err)
+	}
+
+	data := bb[len(bb)-br.Len():]
+	m.CustomSections = append(m.CustomSections, &CustomSection{Name: name, Data: data})
+
+	if name == "name" {
+		// the malformed name section should not fail the module
+		ns, err := segments.ReadNameSection(bytes.NewReader(data))
+		if err != nil {
+			m.log("ignoring name section: " + err.Error())
+		} else {
+			m.NameSection = ns
+		}
+	}
+
+	return nil
+}
+
 func (m *Module) readSectionTypes(r utils.Reader) error {
 	vs, _, err := leb128decode.DecodeUint32(r)
 	if err != nil {
@@ -0,0 +1,44 @@
+package wasm
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestModule_readSectionCustom(t *testing.T) {
+	m := &Module{}
+	buf := []byte{
+		0x04, 'n', 'a', 'm', 'e',
+		0x01, 0x05, 0x01, 0x03, 0x02, 'f', 'n',
+	}
+	if err := m.readSectionCustom(bytes.NewReader(buf), uint32(len(buf))); err != nil {
+		t.Fatal(err)
+	}
+	if name, ok := m.FunctionName(3); !ok || name != "fn" {
+		t.Fail()
+	}
+	if _, ok := m.FunctionName(0); ok {
+		t.Fail()
+	}
+
+	buf = []byte{0x07, 'v', 'e', 'r', 's', 'i', 'o', 'n', 0x01, 0x02}
+	if err := m.readSectionCustom(bytes.NewReader(buf), uint32(len(buf))); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.CustomSections) != 2 || m.CustomSections[0].Name != "name" {
+		t.Fail()
+	}
+	if cs, ok := m.CustomSection("version"); !ok || !bytes.Equal(cs.Data, []byte{0x01, 0x02}) {
+		t.Fail()
+	}
+	if _, ok := m.CustomSection("none"); ok {
+		t.Fail()
+	}
+
+	// the malformed name section is ignored
+	m = &Module{}
+	buf = []byte{0x04, 'n', 'a', 'm', 'e', 0x01, 0x05, 0x01}
+	if err := m.readSectionCustom(bytes.NewReader(buf), uint32(len(buf))); err != nil || m.NameSection != nil {
+		t.Fail()
+	}
+}
@@ -0,0 +1,71 @@
+package wasm
+
+import (
+	"fmt"
+
+	"github.com/hybridgroup/wasman/expr"
+	"github.com/hybridgroup/wasman/utils"
+)
+
+// Trap is the error raised by an instruction of the wasm func,
+// which wraps the cause (e.g. ErrUnreachable) and records where it happened
+type Trap struct {
+	Err       error
+	OpCode    expr.OpCode // the faulting instr
+	FuncIndex uint32      // the index of the faulting func in the module defining it
+	FuncName  string      // the name of the faulting func in the "name" section, if any
+	PC        uint64      // the offset of the faulting instr in the func body
+	Frames    []TrapFrame // the frames on the FrameStack, the innermost first
+}
+
+// TrapFrame is the snapshot of a Frame when the Trap is raised
+type TrapFrame struct {
+	FuncIndex uint32
+	FuncName  string
+	PC        uint64
+}
+
+func (f TrapFrame) String() string {
+	return funcString(f.FuncIndex, f.FuncName) + " at pc " + utils.IntToString(int(f.PC))
+}
+
+func (t *Trap) Error() string {
+	return fmt.Sprintf("wasm trap: %v (%s, pc %d, opcode %#x)", t.Err, funcString(t.FuncIndex, t.FuncName), t.PC, byte(t.OpCode))
+}
+
+// funcString formats the func like "func[37]" or "my_crate::parse[37]"
+func funcString(idx uint32, name string) string {
+	if name == "" {
+		name = "func"
+	}
+
+	return name + "[" + utils.IntToString(int(idx)) + "]"
+}
+
+func (t *Trap) Unwrap() error {
+	return t.Err
+}
+
+// newTrap wraps the err with the state of the active frame,
+// the funcs are named by the modules defining them
+func (ins *Instance) newTrap(err error, op expr.OpCode) *Trap {
+	t := &Trap{
+		Err:       err,
+		OpCode:    op,
+		FuncIndex: ins.Active.Func.index,
+		FuncName:  ins.Active.Func.name,
+		PC:        ins.Active.Func.offset(ins.Active.PC),
+		Frames:    make([]TrapFrame, 0, ins.FrameStack.Ptr+1),
+	}
+
+	for i := ins.FrameStack.Ptr; i >= 0; i-- {
+		frame := ins.FrameStack.Values[i]
+		t.Frames = append(t.Frames, TrapFrame{
+			FuncIndex: frame.Func.index,
+			FuncName:  frame.Func.name,
+			PC:        frame.Func.offset(frame.PC),
+		})
+	}
+
+	return t
+}
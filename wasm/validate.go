@@ -0,0 +1,902 @@
+package wasm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/hybridgroup/wasman/expr"
+	"github.com/hybridgroup/wasman/leb128decode"
+	"github.com/hybridgroup/wasman/segments"
+	"github.com/hybridgroup/wasman/types"
+)
+
+// ErrInvalidModule will be throw when the module does not pass the Validate
+var ErrInvalidModule = errors.New("invalid module")
+
+// valueTypeUnknown is the type of the operands popped from the unreachable code, which matches any type
+const valueTypeUnknown types.ValueType = 0
+
+// Validate checks the module is valid before the instantiation,
+// the function bodies are type checked with the validation algorithm of the spec.
+// https://webassembly.github.io/spec/core/appendix/algorithm.html
+func (m *Module) Validate() error {
+	ctx, err := m.newValidationContext()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidModule, err)
+	}
+
+	if len(m.FunctionSection) != len(m.CodeSection) {
+		return fmt.Errorf("%w: %d funcs but %d bodies", ErrInvalidModule, len(m.FunctionSection), len(m.CodeSection))
+	}
+
+	for i, c := range m.CodeSection {
+		idx := ctx.numImportedFuncs + uint32(i)
+		if err := ctx.validateFunc(idx, c); err != nil {
+			name, _ := m.FunctionName(idx)
+			return fmt.Errorf("%w: %s: %v", ErrInvalidModule, funcString(idx, name), err)
+		}
+	}
+
+	if err := ctx.validateSegments(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidModule, err)
+	}
+
+	return nil
+}
+
+// validationContext is the index spaces of the module to be validated
+type validationContext struct {
+	*Module
+
+	funcs              []*types.FuncType
+	globals            []*types.GlobalType
+	numImportedFuncs   uint32
+	numImportedGlobals int
+	numTables          int
+	numMemories        int
+}
+
+func (m *Module) newValidationContext() (*validationContext, error) {
+	ctx := &validationContext{Module: m}
+	for _, imp := range m.ImportSection {
+		switch imp.Desc.Kind {
+		case segments.KindFunction:
+			if imp.Desc.TypeIndexPtr == nil || int(*imp.Desc.TypeIndexPtr) >= len(m.TypeSection) {
+				return nil, fmt.Errorf("invalid type of the imported func %s.%s", imp.Module, imp.Name)
+			}
+			ctx.funcs = append(ctx.funcs, m.TypeSection[*imp.Desc.TypeIndexPtr])
+			ctx.numImportedFuncs++
+		case segments.KindTable:
+			if err := validateTableType(imp.Desc.TableTypePtr); err != nil {
+				return nil, fmt.Errorf("imported table %s.%s: %w", imp.Module, imp.Name, err)
+			}
+			ctx.numTables++
+		case segments.KindMem:
+			if err := validateMemoryType(imp.Desc.MemTypePtr); err != nil {
+				return nil, fmt.Errorf("imported memory %s.%s: %w", imp.Module, imp.Name, err)
+			}
+			ctx.numMemories++
+		case segments.KindGlobal:
+			ctx.globals = append(ctx.globals, imp.Desc.GlobalTypePtr)
+		}
+	}
+
+	for i, typeIdx := range m.FunctionSection {
+		if int(typeIdx) >= len(m.TypeSection) {
+			return nil, fmt.Errorf("invalid type of the func[%d]", ctx.numImportedFuncs+uint32(i))
+		}
+		ctx.funcs = append(ctx.funcs, m.TypeSection[typeIdx])
+	}
+
+	for i, tt := range m.TableSection {
+		if err := validateTableType(tt); err != nil {
+			return nil, fmt.Errorf("table[%d]: %w", ctx.numTables+i, err)
+		}
+	}
+
+	for i, mt := range m.MemorySection {
+		if err := validateMemoryType(mt); err != nil {
+			return nil, fmt.Errorf("memory[%d]: %w", ctx.numMemories+i, err)
+		}
+	}
+
+	ctx.numTables += len(m.TableSection)
+	ctx.numMemories += len(m.MemorySection)
+
+	if ctx.numTables > 1 {
+		return nil, fmt.Errorf("multiple tables: %d", ctx.numTables)
+	}
+
+	if ctx.numMemories > 1 {
+		return nil, fmt.Errorf("multiple memories: %d", ctx.numMemories)
+	}
+
+	ctx.numImportedGlobals = len(ctx.globals)
+	for i, g := range m.GlobalSection {
+		t, err := ctx.constExprType(g.Init)
+		if err != nil {
+			return nil, fmt.Errorf("global[%d]: %w", len(ctx.globals), err)
+		}
+
+		if t != g.Type.ValType {
+			return nil, fmt.Errorf("global[%d]: type mismatch: %s != %s", ctx.numImportedGlobals+i, t, g.Type.ValType)
+		}
+		ctx.globals = append(ctx.globals, g.Type)
+	}
+
+	return ctx, nil
+}
+
+// validateTableType checks the limits of the table
+func validateTableType(tt *types.TableType) error {
+	if tt == nil || tt.Limits == nil {
+		return errors.New("missing table type")
+	}
+
+	if tt.Limits.Max != nil && tt.Limits.Min > *tt.Limits.Max {
+		return fmt.Errorf("size minimum %d must not be greater than maximum %d", tt.Limits.Min, *tt.Limits.Max)
+	}
+
+	return nil
+}
+
+// validateMemoryType checks the limits of the memory, which are up to 4GiB
+func validateMemoryType(mt *types.MemoryType) error {
+	if mt == nil {
+		return errors.New("missing memory type")
+	}
+
+	log2 := uint32(types.MaxMemoryPageSizeLog2)
+	if mt.PageSizeLog2 != nil {
+		log2 = *mt.PageSizeLog2
+	}
+
+	maxPages := uint64(1) << (32 - log2)
+	if uint64(mt.Min) > maxPages {
+		return fmt.Errorf("memory size must be at most %d pages", maxPages)
+	}
+
+	if mt.Max != nil {
+		if uint64(*mt.Max) > maxPages {
+			return fmt.Errorf("memory size must be at most %d pages", maxPages)
+		}
+
+		if mt.Min > *mt.Max {
+			return fmt.Errorf("size minimum %d must not be greater than maximum %d", mt.Min, *mt.Max)
+		}
+	}
+
+	return nil
+}
+
+// constExprType returns the type of the constant expression,
+// which can refer to the immutable imported globals only
+func (ctx *validationContext) constExprType(e *expr.Expression) (types.ValueType, error) {
+	switch e.OpCode {
+	case expr.OpCodeI32Const:
+		return types.ValueTypeI32, nil
+	case expr.OpCodeI64Const:
+		return types.ValueTypeI64, nil
+	case expr.OpCodeF32Const:
+		return types.ValueTypeF32, nil
+	case expr.OpCodeF64Const:
+		return types.ValueTypeF64, nil
+	case expr.OpCodeGlobalGet:
+		idx, _, err := leb128decode.DecodeUint32(bytes.NewReader(e.Data))
+		if err != nil {
+			return 0, err
+		}
+
+		if int(idx) >= ctx.numImportedGlobals {
+			return 0, fmt.Errorf("unknown global %d", idx)
+		}
+
+		if ctx.globals[idx].Mutable {
+			return 0, fmt.Errorf("global %d is mutable", idx)
+		}
+
+		return ctx.globals[idx].ValType, nil
+	default:
+		return 0, fmt.Errorf("invalid constant expression %s", expr.GetOpCodeName(e.OpCode))
+	}
+}
+
+func (ctx *validationContext) validateSegments() error {
+	for name, exp := range ctx.ExportSection {
+		var n int
+		switch exp.Desc.Kind {
+		case segments.KindFunction:
+			n = len(ctx.funcs)
+		case segments.KindTable:
+			n = ctx.numTables
+		case segments.KindMem:
+			n = ctx.numMemories
+		case segments.KindGlobal:
+			n = len(ctx.globals)
+		}
+
+		if int(exp.Desc.Index) >= n {
+			return fmt.Errorf("export %s: unknown index %d", name, exp.Desc.Index)
+		}
+	}
+
+	for _, idx := range ctx.StartSection {
+		if int(idx) >= len(ctx.funcs) {
+			return fmt.Errorf("start: unknown func %d", idx)
+		}
+
+		if ft := ctx.funcs[idx]; len(ft.InputTypes) > 0 || len(ft.ReturnTypes) > 0 {
+			return fmt.Errorf("start: func %d should take and return nothing", idx)
+		}
+	}
+
+	for i, elem := range ctx.ElementsSection {
+		if int(elem.TableIndex) >= ctx.numTables {
+			return fmt.Errorf("elem[%d]: unknown table %d", i, elem.TableIndex)
+		}
+
+		if t, err := ctx.constExprType(elem.OffsetExpr); err != nil || t != types.ValueTypeI32 {
+			return fmt.Errorf("elem[%d]: invalid offset", i)
+		}
+
+		for _, idx := range elem.Init {
+			if int(idx) >= len(ctx.funcs) {
+				return fmt.Errorf("elem[%d]: unknown func %d", i, idx)
+			}
+		}
+	}
+
+	for i, d := range ctx.DataSection {
+		if int(d.MemoryIndex) >= ctx.numMemories {
+			return fmt.Errorf("data[%d]: unknown memory %d", i, d.MemoryIndex)
+		}
+
+		if t, err := ctx.constExprType(d.OffsetExpression); err != nil || t != types.ValueTypeI32 {
+			return fmt.Errorf("data[%d]: invalid offset", i)
+		}
+	}
+
+	return nil
+}
+
+// ctrlFrame is the frame of a block on the control stack of the funcValidator
+type ctrlFrame struct {
+	opCode      expr.OpCode
+	startTypes  []types.ValueType
+	endTypes    []types.ValueType
+	height      int // the height of the operand stack at the start
+	unreachable bool
+}
+
+// labelTypes returns the types of the operands taken by the branch to the block
+func (f *ctrlFrame) labelTypes() []types.ValueType {
+	if f.opCode == expr.OpCodeLoop {
+		return f.startTypes
+	}
+
+	return f.endTypes
+}
+
+// funcValidator type checks a func body
+type funcValidator struct {
+	*validationContext
+
+	locals []types.ValueType // the params followed by the locals
+	vals   []types.ValueType
+	ctrls  []*ctrlFrame
+}
+
+func (ctx *validationContext) validateFunc(idx uint32, c *segments.CodeSegment) error {
+	ft := ctx.funcs[idx]
+	if uint32(len(c.LocalTypes)) != c.NumLocals {
+		return fmt.Errorf("the types of %d locals are missing", c.NumLocals)
+	}
+
+	v := &funcValidator{validationContext: ctx}
+	v.locals = append(append(v.locals, ft.InputTypes...), c.LocalTypes...)
+	v.pushCtrl(expr.OpCodeBlock, nil, ft.ReturnTypes)
+
+	for pc := uint64(0); pc < uint64(len(c.Body)); {
+		in, err := decodeInstruction(c.Body, pc)
+		if err != nil {
+			return err
+		}
+
+		if len(v.ctrls) == 0 {
+			return fmt.Errorf("%s at %#x: unexpected instruction after the end", expr.GetOpCodeName(in.OpCode), pc)
+		}
+
+		if err := v.validateInstruction(in); err != nil {
+			return fmt.Errorf("%s at %#x: %w", expr.GetOpCodeName(in.OpCode), pc, err)
+		}
+
+		pc = in.Next
+	}
+
+	// the end of the body is not in the CodeSegment
+	if len(v.ctrls) != 1 {
+		return errors.New("unexpected end of the body")
+	}
+
+	_, err := v.popCtrl()
+	return err
+}
+
+func (v *funcValidator) push(t types.ValueType) {
+	v.vals = append(v.vals, t)
+}
+
+func (v *funcValidator) pushVals(ts []types.ValueType) {
+	v.vals = append(v.vals, ts...)
+}
+
+func (v *funcValidator) pop() (types.ValueType, error) {
+	ctrl := v.ctrls[len(v.ctrls)-1]
+	if len(v.vals) == ctrl.height {
+		if ctrl.unreachable {
+			return valueTypeUnknown, nil
+		}
+		return 0, errors.New("operand stack underflow")
+	}
+
+	t := v.vals[len(v.vals)-1]
+	v.vals = v.vals[:len(v.vals)-1]
+	return t, nil
+}
+
+func (v *funcValidator) popExpect(exp types.ValueType) (types.ValueType, error) {
+	t, err := v.pop()
+	if err != nil {
+		return 0, err
+	}
+
+	if t != exp && t != valueTypeUnknown && exp != valueTypeUnknown {
+		return 0, fmt.Errorf("type mismatch: %s != %s", t, exp)
+	}
+
+	return t, nil
+}
+
+func (v *funcValidator) popVals(ts []types.ValueType) error {
+	for i := len(ts) - 1; i >= 0; i-- {
+		if _, err := v.popExpect(ts[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (v *funcValidator) pushCtrl(op expr.OpCode, in, out []types.ValueType) {
+	v.ctrls = append(v.ctrls, &ctrlFrame{opCode: op, startTypes: in, endTypes: out, height: len(v.vals)})
+	v.pushVals(in)
+}
+
+func (v *funcValidator) popCtrl() (*ctrlFrame, error) {
+	frame := v.ctrls[len(v.ctrls)-1]
+	if err := v.popVals(frame.endTypes); err != nil {
+		return nil, err
+	}
+
+	if len(v.vals) != frame.height {
+		return nil, errors.New("values remain on the operand stack")
+	}
+
+	v.ctrls = v.ctrls[:len(v.ctrls)-1]
+	return frame, nil
+}
+
+func (v *funcValidator) setUnreachable() {
+	ctrl := v.ctrls[len(v.ctrls)-1]
+	v.vals = v.vals[:ctrl.height]
+	ctrl.unreachable = true
+}
+
+// label returns the frame of the branch target at the depth
+func (v *funcValidator) label(depth uint64) (*ctrlFrame, error) {
+	if depth >= uint64(len(v.ctrls)) {
+		return nil, fmt.Errorf("unknown label %d", depth)
+	}
+
+	return v.ctrls[len(v.ctrls)-1-int(depth)], nil
+}
+
+// blockType resolves the raw block type of the instruction
+func (v *funcValidator) blockType(raw int64) (*types.FuncType, error) {
+	switch raw {
+	case -64: // 0x40 = empty
+		return &types.FuncType{}, nil
+	case -1, -2, -3, -4, -16, -17: // value types
+		return &types.FuncType{ReturnTypes: []types.ValueType{types.ValueType(0x80 + raw)}}, nil
+	}
+
+	if raw < 0 || raw >= int64(len(v.TypeSection)) {
+		return nil, fmt.Errorf("invalid block type %d", raw)
+	}
+
+	return v.TypeSection[raw], nil
+}
+
+func (v *funcValidator) validateInstruction(in *instruction) error {
+	op := in.OpCode
+	if ins, out, ok := numericSignature(op); ok {
+		if err := v.popVals(ins); err != nil {
+			return err
+		}
+		v.push(out)
+		return nil
+	}
+
+	if t, width, store, ok := memoryAccess(op); ok {
+		return v.validateMemoryAccess(in, t, width, store)
+	}
+
+	switch op {
+	case expr.OpCodeUnreachable:
+		v.setUnreachable()
+	case expr.OpCodeNop:
+	case expr.OpCodeBlock, expr.OpCodeLoop, expr.OpCodeIf:
+		bt, err := v.blockType(in.BlockType)
+		if err != nil {
+			return err
+		}
+
+		if op == expr.OpCodeIf {
+			if _, err := v.popExpect(types.ValueTypeI32); err != nil {
+				return err
+			}
+		}
+
+		if err := v.popVals(bt.InputTypes); err != nil {
+			return err
+		}
+		v.pushCtrl(op, bt.InputTypes, bt.ReturnTypes)
+	case expr.OpCodeElse:
+		frame, err := v.popCtrl()
+		if err != nil {
+			return err
+		}
+
+		if frame.opCode != expr.OpCodeIf {
+			return errors.New("else without if")
+		}
+		v.pushCtrl(expr.OpCodeElse, frame.startTypes, frame.endTypes)
+	case expr.OpCodeEnd:
+		frame, err := v.popCtrl()
+		if err != nil {
+			return err
+		}
+
+		// the missing else branch passes the params through
+		if frame.opCode == expr.OpCodeIf && !types.HasSameSignature(frame.startTypes, frame.endTypes) {
+			return errors.New("if without else should return its params")
+		}
+		v.pushVals(frame.endTypes)
+	case expr.OpCodeBr:
+		l, err := v.label(in.Immediates[0])
+		if err != nil {
+			return err
+		}
+
+		if err := v.popVals(l.labelTypes()); err != nil {
+			return err
+		}
+		v.setUnreachable()
+	case expr.OpCodeBrIf:
+		if _, err := v.popExpect(types.ValueTypeI32); err != nil {
+			return err
+		}
+
+		l, err := v.label(in.Immediates[0])
+		if err != nil {
+			return err
+		}
+
+		if err := v.popVals(l.labelTypes()); err != nil {
+			return err
+		}
+		v.pushVals(l.labelTypes())
+	case expr.OpCodeBrTable:
+		return v.validateBrTable(in)
+	case expr.OpCodeReturn:
+		if err := v.popVals(v.ctrls[0].endTypes); err != nil {
+			return err
+		}
+		v.setUnreachable()
+	case expr.OpCodeCall:
+		if in.Immediates[0] >= uint64(len(v.funcs)) {
+			return fmt.Errorf("unknown func %d", in.Immediates[0])
+		}
+
+		ft := v.funcs[in.Immediates[0]]
+		if err := v.popVals(ft.InputTypes); err != nil {
+			return err
+		}
+		v.pushVals(ft.ReturnTypes)
+	case expr.OpCodeCallIndirect:
+		if in.Immediates[1] >= uint64(v.numTables) {
+			return fmt.Errorf("unknown table %d", in.Immediates[1])
+		}
+
+		if in.Immediates[0] >= uint64(len(v.TypeSection)) {
+			return fmt.Errorf("unknown type %d", in.Immediates[0])
+		}
+
+		if _, err := v.popExpect(types.ValueTypeI32); err != nil {
+			return err
+		}
+
+		ft := v.TypeSection[in.Immediates[0]]
+		if err := v.popVals(ft.InputTypes); err != nil {
+			return err
+		}
+		v.pushVals(ft.ReturnTypes)
+	case expr.OpCodeDrop:
+		_, err := v.pop()
+		return err
+	case expr.OpCodeSelect:
+		return v.validateSelect()
+	case expr.OpCodeLocalGet, expr.OpCodeLocalSet, expr.OpCodeLocalTee:
+		if in.Immediates[0] >= uint64(len(v.locals)) {
+			return fmt.Errorf("unknown local %d", in.Immediates[0])
+		}
+
+		t := v.locals[in.Immediates[0]]
+		if op != expr.OpCodeLocalGet {
+			if _, err := v.popExpect(t); err != nil {
+				return err
+			}
+		}
+
+		if op != expr.OpCodeLocalSet {
+			v.push(t)
+		}
+	case expr.OpCodeGlobalGet, expr.OpCodeGlobalSet:
+		if in.Immediates[0] >= uint64(len(v.globals)) {
+			return fmt.Errorf("unknown global %d", in.Immediates[0])
+		}
+
+		g := v.globals[in.Immediates[0]]
+		if op == expr.OpCodeGlobalGet {
+			v.push(g.ValType)
+			return nil
+		}
+
+		if !g.Mutable {
+			return fmt.Errorf("global %d is immutable", in.Immediates[0])
+		}
+
+		_, err := v.popExpect(g.ValType)
+		return err
+	case expr.OpCodeMemorySize, expr.OpCodeMemoryGrow:
+		if v.numMemories == 0 {
+			return errors.New("unknown memory 0")
+		}
+
+		if in.Immediates[0] != 0x00 {
+			return errors.New("zero byte expected")
+		}
+
+		if op == expr.OpCodeMemoryGrow {
+			if _, err := v.popExpect(types.ValueTypeI32); err != nil {
+				return err
+			}
+		}
+		v.push(types.ValueTypeI32)
+	case expr.OpCodeI32Const:
+		v.push(types.ValueTypeI32)
+	case expr.OpCodeI64Const:
+		v.push(types.ValueTypeI64)
+	case expr.OpCodeF32Const:
+		v.push(types.ValueTypeF32)
+	case expr.OpCodeF64Const:
+		v.push(types.ValueTypeF64)
+	case expr.OpCodeNull, expr.OpCodeIsNull, expr.OpCodeFunc:
+		// the interpreter has no reference values on the operand stack
+		return errors.New("reference instructions are not supported")
+	case expr.OpCodeBulkMemory:
+		return v.validateBulkMemory(in)
+	default:
+		return errors.New("unknown instruction")
+	}
+
+	return nil
+}
+
+func (v *funcValidator) validateBrTable(in *instruction) error {
+	if _, err := v.popExpect(types.ValueTypeI32); err != nil {
+		return err
+	}
+
+	def, err := v.label(in.Immediates[len(in.Immediates)-1])
+	if err != nil {
+		return err
+	}
+
+	arity := len(def.labelTypes())
+	for _, depth := range in.Immediates[:len(in.Immediates)-1] {
+		l, err := v.label(depth)
+		if err != nil {
+			return err
+		}
+
+		if len(l.labelTypes()) != arity {
+			return fmt.Errorf("arity mismatch of the label %d", depth)
+		}
+
+		// check the operands without consuming them
+		if err := v.popVals(l.labelTypes()); err != nil {
+			return err
+		}
+		v.pushVals(l.labelTypes())
+	}
+
+	if err := v.popVals(def.labelTypes()); err != nil {
+		return err
+	}
+	v.setUnreachable()
+
+	return nil
+}
+
+func (v *funcValidator) validateSelect() error {
+	if _, err := v.popExpect(types.ValueTypeI32); err != nil {
+		return err
+	}
+
+	t1, err := v.pop()
+	if err != nil {
+		return err
+	}
+
+	t2, err := v.pop()
+	if err != nil {
+		return err
+	}
+
+	if isRefType(t1) || isRefType(t2) {
+		return errors.New("select of references needs the type")
+	}
+
+	if t1 != t2 && t1 != valueTypeUnknown && t2 != valueTypeUnknown {
+		return fmt.Errorf("type mismatch: %s != %s", t1, t2)
+	}
+
+	if t1 == valueTypeUnknown {
+		t1 = t2
+	}
+	v.push(t1)
+
+	return nil
+}
+
+func (v *funcValidator) validateMemoryAccess(in *instruction, t types.ValueType, width uint64, store bool) error {
+	if v.numMemories == 0 {
+		return errors.New("unknown memory 0")
+	}
+
+	if align := in.Immediates[0]; align >= 64 || uint64(1)<<align > width {
+		return fmt.Errorf("alignment 2^%d is larger than natural", align)
+	}
+
+	if store {
+		if _, err := v.popExpect(t); err != nil {
+			return err
+		}
+	}
+
+	if _, err := v.popExpect(types.ValueTypeI32); err != nil {
+		return err
+	}
+
+	if !store {
+		v.push(t)
+	}
+
+	return nil
+}
+
+func (v *funcValidator) validateBulkMemory(in *instruction) error {
+	i32 := types.ValueTypeI32
+	switch in.SubCode {
+	case 0x00, 0x01: // i32.trunc_sat_f32
+		return v.unary(types.ValueTypeF32, i32)
+	case 0x02, 0x03: // i32.trunc_sat_f64
+		return v.unary(types.ValueTypeF64, i32)
+	case 0x04, 0x05: // i64.trunc_sat_f32
+		return v.unary(types.ValueTypeF32, types.ValueTypeI64)
+	case 0x06, 0x07: // i64.trunc_sat_f64
+		return v.unary(types.ValueTypeF64, types.ValueTypeI64)
+	case 0x08, 0x09: // memory.init, data.drop
+		if in.Immediates[0] >= uint64(len(v.DataSection)) {
+			return fmt.Errorf("unknown data %d", in.Immediates[0])
+		}
+
+		if in.SubCode == 0x09 {
+			return nil
+		}
+
+		if v.numMemories == 0 {
+			return errors.New("unknown memory 0")
+		}
+
+		if in.Immediates[1] != 0x00 {
+			return errors.New("zero byte expected")
+		}
+		return v.popVals([]types.ValueType{i32, i32, i32})
+	case 0x0a, 0x0b: // memory.copy, memory.fill
+		if v.numMemories == 0 {
+			return errors.New("unknown memory 0")
+		}
+
+		for _, b := range in.Immediates {
+			if b != 0x00 {
+				return errors.New("zero byte expected")
+			}
+		}
+		return v.popVals([]types.ValueType{i32, i32, i32})
+	case 0x0c: // table.init
+		if in.Immediates[0] >= uint64(len(v.ElementsSection)) {
+			return fmt.Errorf("unknown elem %d", in.Immediates[0])
+		}
+
+		if in.Immediates[1] >= uint64(v.numTables) {
+			return fmt.Errorf("unknown table %d", in.Immediates[1])
+		}
+		return v.popVals([]types.ValueType{i32, i32, i32})
+	case 0x0d: // elem.drop
+		if in.Immediates[0] >= uint64(len(v.ElementsSection)) {
+			return fmt.Errorf("unknown elem %d", in.Immediates[0])
+		}
+		return nil
+	case 0x0e: // table.copy
+		if in.Immediates[0] >= uint64(v.numTables) || in.Immediates[1] >= uint64(v.numTables) {
+			return errors.New("unknown table")
+		}
+		return v.popVals([]types.ValueType{i32, i32, i32})
+	default: // table.grow, table.size, table.fill
+		if in.Immediates[0] >= uint64(v.numTables) {
+			return fmt.Errorf("unknown table %d", in.Immediates[0])
+		}
+
+		switch in.SubCode {
+		case 0x0f:
+			if err := v.popVals([]types.ValueType{types.ValueTypeFuncref, i32}); err != nil {
+				return err
+			}
+		case 0x11:
+			return v.popVals([]types.ValueType{i32, types.ValueTypeFuncref, i32})
+		}
+		v.push(i32)
+		return nil
+	}
+}
+
+func (v *funcValidator) unary(in, out types.ValueType) error {
+	if _, err := v.popExpect(in); err != nil {
+		return err
+	}
+	v.push(out)
+	return nil
+}
+
+func isRefType(t types.ValueType) bool {
+	return t == types.ValueTypeFuncref || t == types.ValueTypeExternref
+}
+
+// memoryAccess returns the type and the width in bytes of the load or store instruction
+func memoryAccess(op expr.OpCode) (t types.ValueType, width uint64, store bool, ok bool) {
+	i32, i64, f32, f64 := types.ValueTypeI32, types.ValueTypeI64, types.ValueTypeF32, types.ValueTypeF64
+	switch op {
+	case expr.OpCodeI32Load:
+		return i32, 4, false, true
+	case expr.OpCodeI64Load:
+		return i64, 8, false, true
+	case expr.OpCodeF32Load:
+		return f32, 4, false, true
+	case expr.OpCodeF64Load:
+		return f64, 8, false, true
+	case expr.OpCodeI32Load8s, expr.OpCodeI32Load8u:
+		return i32, 1, false, true
+	case expr.OpCodeI32Load16s, expr.OpCodeI32Load16u:
+		return i32, 2, false, true
+	case expr.OpCodeI64Load8s, expr.OpCodeI64Load8u:
+		return i64, 1, false, true
+	case expr.OpCodeI64Load16s, expr.OpCodeI64Load16u:
+		return i64, 2, false, true
+	case expr.OpCodeI64Load32s, expr.OpCodeI64Load32u:
+		return i64, 4, false, true
+	case expr.OpCodeI32Store:
+		return i32, 4, true, true
+	case expr.OpCodeI64Store:
+		return i64, 8, true, true
+	case expr.OpCodeF32Store:
+		return f32, 4, true, true
+	case expr.OpCodeF64Store:
+		return f64, 8, true, true
+	case expr.OpCodeI32Store8:
+		return i32, 1, true, true
+	case expr.OpCodeI32Store16:
+		return i32, 2, true, true
+	case expr.OpCodeI64Store8:
+		return i64, 1, true, true
+	case expr.OpCodeI64Store16:
+		return i64, 2, true, true
+	case expr.OpCodeI64Store32:
+		return i64, 4, true, true
+	}
+
+	return 0, 0, false, false
+}
+
+// numericSignature returns the operand types and the result type of the numeric instruction
+func numericSignature(op expr.OpCode) (in []types.ValueType, out types.ValueType, ok bool) {
+	i32, i64, f32, f64 := types.ValueTypeI32, types.ValueTypeI64, types.ValueTypeF32, types.ValueTypeF64
+	un := func(t types.ValueType) []types.ValueType { return []types.ValueType{t} }
+	bin := func(t types.ValueType) []types.ValueType { return []types.ValueType{t, t} }
+
+	switch {
+	case op == expr.OpCodeI32Eqz:
+		return un(i32), i32, true
+	case expr.OpCodeI32Eq <= op && op <= expr.OpCodeI32GeU:
+		return bin(i32), i32, true
+	case op == expr.OpCodeI64Eqz:
+		return un(i64), i32, true
+	case expr.OpCodeI64Eq <= op && op <= expr.OpCodeI64GeU:
+		return bin(i64), i32, true
+	case expr.OpCodeF32Eq <= op && op <= expr.OpCodeF32Ge:
+		return bin(f32), i32, true
+	case expr.OpCodeF64Eq <= op && op <= expr.OpCodeF64Ge:
+		return bin(f64), i32, true
+	case expr.OpCodeI32Clz <= op && op <= expr.OpCodeI32PopCnt:
+		return un(i32), i32, true
+	case expr.OpCodeI32Add <= op && op <= expr.OpCodeI32RotR:
+		return bin(i32), i32, true
+	case expr.OpCodeI64Clz <= op && op <= expr.OpCodeI64PopCnt:
+		return un(i64), i64, true
+	case expr.OpCodeI64Add <= op && op <= expr.OpCodeI64RotR:
+		return bin(i64), i64, true
+	case expr.OpCodeF32Abs <= op && op <= expr.OpCodeF32Sqrt:
+		return un(f32), f32, true
+	case expr.OpCodeF32Add <= op && op <= expr.OpCodeF32CopySign:
+		return bin(f32), f32, true
+	case expr.OpCodeF64Abs <= op && op <= expr.OpCodeF64Sqrt:
+		return un(f64), f64, true
+	case expr.OpCodeF64Add <= op && op <= expr.OpCodeF64CopySign:
+		return bin(f64), f64, true
+	}
+
+	switch op {
+	case expr.OpCodeI32WrapI64:
+		return un(i64), i32, true
+	case expr.OpCodeI32TruncF32S, expr.OpCodeI32TruncF32U, expr.OpCodeI32ReinterpretF32:
+		return un(f32), i32, true
+	case expr.OpCodeI32truncF64S, expr.OpCodeI32truncF64U:
+		return un(f64), i32, true
+	case expr.OpCodeI64ExtendI32S, expr.OpCodeI64ExtendI32U:
+		return un(i32), i64, true
+	case expr.OpCodeI64TruncF32S, expr.OpCodeI64TruncF32U:
+		return un(f32), i64, true
+	case expr.OpCodeI64TruncF64S, expr.OpCodeI64TruncF64U, expr.OpCodeI64ReinterpretF64:
+		return un(f64), i64, true
+	case expr.OpCodeF32ConvertI32S, expr.OpCodeF32ConvertI32U, expr.OpCodeF32ReinterpretI32:
+		return un(i32), f32, true
+	case expr.OpCodeF32ConvertI64S, expr.OpCodeF32ConvertI64U:
+		return un(i64), f32, true
+	case expr.OpCodeF32DemoteF64:
+		return un(f64), f32, true
+	case expr.OpCodeF64ConvertI32S, expr.OpCodeF64ConvertI32U:
+		return un(i32), f64, true
+	case expr.OpCodeF64ConvertI64S, expr.OpCodeF64ConvertI64U, expr.OpCodeF64ReinterpretI64:
+		return un(i64), f64, true
+	case expr.OpCodeF64PromoteF32:
+		return un(f32), f64, true
+	case expr.OpCodeI32Extend8S, expr.OpCodeI32Extend16S:
+		return un(i32), i32, true
+	case expr.OpCodeI64Extend8S, expr.OpCodeI64Extend16S, expr.OpCodeI64Extend32S:
+		return un(i64), i64, true
+	}
+
+	return nil, 0, false
+}
@@ -0,0 +1,139 @@
+package wasm
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/hybridgroup/wasman/expr"
+	"github.com/hybridgroup/wasman/segments"
+	"github.com/hybridgroup/wasman/types"
+	"github.com/hybridgroup/wasman/utils"
+)
+
+func TestModule_Validate(t *testing.T) {
+	i32, i64 := types.ValueTypeI32, types.ValueTypeI64
+	module := func(ft *types.FuncType, locals []types.ValueType, body ...byte) *Module {
+		return &Module{
+			TypeSection:     []*types.FuncType{ft},
+			FunctionSection: []uint32{0},
+			MemorySection:   []*types.MemoryType{{Min: 1}},
+			GlobalSection: []*segments.GlobalSegment{
+				{Type: &types.GlobalType{ValType: i32}, Init: &expr.Expression{OpCode: expr.OpCodeI32Const, Data: []byte{0x00}}},
+			},
+			CodeSection: []*segments.CodeSegment{{NumLocals: uint32(len(locals)), LocalTypes: locals, Body: body}},
+		}
+	}
+	add := &types.FuncType{InputTypes: []types.ValueType{i32, i32}, ReturnTypes: []types.ValueType{i32}}
+	void := &types.FuncType{}
+
+	t.Run("ok", func(t *testing.T) {
+		for i, m := range []*Module{
+			module(add, nil, expr.OpCodeLocalGet, 0x00, expr.OpCodeLocalGet, 0x01, expr.OpCodeI32Add),
+			// the stack is polymorphic after the unreachable
+			module(add, nil, expr.OpCodeUnreachable, expr.OpCodeI32Add),
+			module(void, []types.ValueType{i64},
+				expr.OpCodeBlock, 0x7e, // block (result i64)
+				expr.OpCodeI64Const, 0x01, expr.OpCodeI32Const, 0x00, expr.OpCodeBrIf, 0x00,
+				expr.OpCodeEnd,
+				expr.OpCodeLocalSet, 0x00),
+			module(void, nil,
+				expr.OpCodeLoop, 0x40,
+				expr.OpCodeI32Const, 0x00, expr.OpCodeBrTable, 0x01, 0x00, 0x01,
+				expr.OpCodeEnd),
+			module(add, nil,
+				expr.OpCodeLocalGet, 0x00,
+				expr.OpCodeIf, 0x7f, expr.OpCodeI32Const, 0x01, expr.OpCodeElse, expr.OpCodeI32Const, 0x02, expr.OpCodeEnd),
+			module(void, nil, expr.OpCodeI32Const, 0x00, expr.OpCodeI64Const, 0x01, expr.OpCodeI64Store, 0x03, 0x00),
+			module(add, nil, expr.OpCodeLocalGet, 0x00, expr.OpCodeI32Extend8S),
+			module(void, nil,
+				expr.OpCodeI32Const, 0x00, expr.OpCodeI32Const, 0x00, expr.OpCodeI32Const, 0x00,
+				expr.OpCodeBulkMemory, 0x0a, 0x00, 0x00), // memory.copy
+			module(add, nil,
+				expr.OpCodeLocalGet, 0x00, expr.OpCodeF32ConvertI32S,
+				expr.OpCodeBulkMemory, 0x00), // i32.trunc_sat_f32_s
+			// a memory of 1 byte pages can have more pages
+			{MemorySection: []*types.MemoryType{{Min: 65537, PageSizeLog2: utils.Uint32Ptr(0)}}},
+		} {
+			t.Run(utils.IntToString(i), func(t *testing.T) {
+				if err := m.Validate(); err != nil {
+					t.Fatal(err)
+				}
+			})
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		for i, c := range []struct {
+			m   *Module
+			msg string
+		}{
+			{m: module(add, nil, expr.OpCodeLocalGet, 0x00, expr.OpCodeI32Add), msg: "operand stack underflow"},
+			{m: module(add, nil, expr.OpCodeI64Const, 0x00), msg: "type mismatch: i64 != i32"},
+			{m: module(void, nil, expr.OpCodeI32Const, 0x00), msg: "values remain"},
+			{m: module(void, nil, expr.OpCodeLocalGet, 0x02, expr.OpCodeDrop), msg: "LocalGet at 0x0: unknown local 2"},
+			{m: module(void, nil, expr.OpCodeI32Const, 0x00, expr.OpCodeGlobalSet, 0x00), msg: "global 0 is immutable"},
+			{m: module(void, nil, expr.OpCodeGlobalGet, 0x01, expr.OpCodeDrop), msg: "unknown global 1"},
+			{m: module(void, nil, expr.OpCodeBr, 0x01), msg: "unknown label 1"},
+			{m: module(void, nil, expr.OpCodeCall, 0x01), msg: "unknown func 1"},
+			{m: module(void, nil, expr.OpCodeBlock, 0x40), msg: "unexpected end of the body"},
+			{m: module(void, nil, expr.OpCodeEnd, expr.OpCodeNop), msg: "unexpected instruction after the end"},
+			{m: module(void, nil, expr.OpCodeIf, 0x7f, expr.OpCodeI32Const, 0x00, expr.OpCodeEnd, expr.OpCodeDrop), msg: "operand stack underflow"},
+			{m: module(void, nil, expr.OpCodeI32Const, 0x00, expr.OpCodeI32Load, 0x03, 0x00, expr.OpCodeDrop), msg: "alignment"},
+			{m: module(void, []types.ValueType{i64}, expr.OpCodeI32Const, 0x00, expr.OpCodeLocalSet, 0x00), msg: "type mismatch: i32 != i64"},
+			{m: module(void, nil, 0xff), msg: "unknown instruction"},
+			{m: &Module{TypeSection: []*types.FuncType{void}, FunctionSection: []uint32{0}}, msg: "1 funcs but 0 bodies"},
+			{m: &Module{StartSection: []uint32{0}}, msg: "start: unknown func 0"},
+			{m: module(void, nil,
+				expr.OpCodeI32Const, 0x00, expr.OpCodeI32Const, 0x00, expr.OpCodeI32Const, 0x00,
+				expr.OpCodeBulkMemory, 0x0a, 0x00, 0x01), msg: "zero byte expected"}, // memory.copy
+			{m: module(void, nil,
+				expr.OpCodeI32Const, 0x00, expr.OpCodeI32Const, 0x00, expr.OpCodeI32Const, 0x00,
+				expr.OpCodeBulkMemory, 0x0b, 0x01), msg: "zero byte expected"}, // memory.fill
+			{m: module(void, nil, expr.OpCodeNull, 0x70, expr.OpCodeDrop), msg: "reference instructions are not supported"},
+			{m: module(add, nil, expr.OpCodeFunc, 0x00, expr.OpCodeIsNull), msg: "reference instructions are not supported"},
+			{m: &Module{MemorySection: []*types.MemoryType{{Min: 2, Max: utils.Uint32Ptr(1)}}}, msg: "memory[0]: size minimum 2 must not be greater than maximum 1"},
+			{m: &Module{MemorySection: []*types.MemoryType{{Min: 65537}}}, msg: "memory[0]: memory size must be at most 65536 pages"},
+			{m: &Module{MemorySection: []*types.MemoryType{{Min: 1, Max: utils.Uint32Ptr(65537)}}}, msg: "memory size must be at most 65536 pages"},
+			{m: &Module{
+				ImportSection: []*segments.ImportSegment{
+					{Module: "env", Name: "mem", Desc: &segments.ImportDesc{Kind: segments.KindMem, MemTypePtr: &types.MemoryType{Min: 65537}}},
+				},
+			}, msg: "imported memory env.mem: memory size must be at most 65536 pages"},
+			{m: &Module{TableSection: []*types.TableType{{Elem: 0x70, Limits: &types.Limits{Min: 2, Max: utils.Uint32Ptr(1)}}}}, msg: "table[0]: size minimum 2 must not be greater than maximum 1"},
+			{m: &Module{MemorySection: []*types.MemoryType{{Min: 1}, {Min: 1}}}, msg: "multiple memories"},
+			{m: &Module{
+				ImportSection: []*segments.ImportSegment{
+					{Module: "env", Name: "table", Desc: &segments.ImportDesc{Kind: segments.KindTable, TableTypePtr: &types.TableType{Elem: 0x70, Limits: &types.Limits{}}}},
+				},
+				TableSection: []*types.TableType{{Elem: 0x70, Limits: &types.Limits{}}},
+			}, msg: "multiple tables"},
+			{m: &Module{
+				ImportSection: []*segments.ImportSegment{
+					{Module: "env", Name: "g", Desc: &segments.ImportDesc{Kind: segments.KindGlobal, GlobalTypePtr: &types.GlobalType{ValType: i32, Mutable: true}}},
+				},
+				GlobalSection: []*segments.GlobalSegment{
+					{Type: &types.GlobalType{ValType: i32}, Init: &expr.Expression{OpCode: expr.OpCodeGlobalGet, Data: []byte{0x00}}},
+				},
+			}, msg: "global[1]: global 0 is mutable"},
+			{m: &Module{
+				MemorySection: []*types.MemoryType{{Min: 1}},
+				GlobalSection: []*segments.GlobalSegment{
+					{Type: &types.GlobalType{ValType: i32}, Init: &expr.Expression{OpCode: expr.OpCodeI32Const, Data: []byte{0x00}}},
+				},
+				// the offset can not refer to the global defined in the module
+				DataSection: []*segments.DataSegment{
+					{OffsetExpression: &expr.Expression{OpCode: expr.OpCodeGlobalGet, Data: []byte{0x00}}},
+				},
+			}, msg: "data[0]: invalid offset"},
+		} {
+			t.Run(utils.IntToString(i), func(t *testing.T) {
+				err := c.m.Validate()
+				if !errors.Is(err, ErrInvalidModule) || !strings.Contains(err.Error(), c.msg) {
+					t.Logf("err: %v", err)
+					t.Fail()
+				}
+			})
+		}
+	})
+}